@@ -0,0 +1,91 @@
+package scr
+
+import (
+	"math/rand"
+)
+
+const (
+	defaultLocationPushThreshold = 0.05
+	defaultLocationPullInterval  = 50
+)
+
+// announceLocation pushes the location of each of this node's positions that
+// has moved more than threshold radians since it was last announced to the
+// peers it knows. It returns the number of peers told.
+func (n *Node) announceLocation(c Coordinator, threshold float64) (announced int) {
+	for _, p := range n.positions() {
+		if p.announcedLocation.Equals(V{}) {
+			// Peers learned the first location when adding this one.
+			p.announcedLocation = p.Location
+			continue
+		}
+		if p.Location.GreatCircleDistance(p.announcedLocation) <= threshold {
+			continue
+		}
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			if o == nil {
+				return
+			}
+			// NODE INTERACTION: LOCATION ANNOUNCEMENT
+			c.Send(&Message{
+				Kind:     MsgLocation,
+				From:     p,
+				To:       o,
+				Location: p.Location,
+			})
+			announced++
+		})
+		p.announcedLocation = p.Location
+	}
+	return
+}
+
+// pullPeerLocations asks every known peer for its current location, once
+// every interval ticks. The answers are handled as they arrive.
+func (n *Node) pullPeerLocations(c Coordinator, now, interval int) {
+	if n.lastPull == 0 {
+		// Spread the pulls of nodes over the interval.
+		n.lastPull = now - rand.Intn(interval)
+	}
+	if now-n.lastPull < interval {
+		return
+	}
+	n.lastPull = now
+	for _, p := range n.positions() {
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			if o == nil {
+				return
+			}
+			// NODE INTERACTION: LOCATION REQUEST
+			p.request(c, &Message{
+				Kind:     MsgLocationRequest,
+				From:     p,
+				To:       o,
+				Location: p.Location,
+			})
+		})
+	}
+}
+
+// peerLocationErrors sums the distance between where this node believes its
+// online peers are and where they actually are.
+func (n *Node) peerLocationErrors() (entries int, sum, max float64) {
+	for _, p := range n.positions() {
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			if o == nil || o.physical().departed {
+				return
+			}
+			loc, ok := p.peers.PeerLocation(o)
+			if !ok {
+				return
+			}
+			err := loc.GreatCircleDistance(o.Location)
+			entries++
+			sum += err
+			if err > max {
+				max = err
+			}
+		})
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package scr
+
+import (
+	"math"
+	"testing"
+)
+
+// newPeerPair has two idle nodes that know each other where they are.
+func newPeerPair() (s *Simulation, n, o *Node) {
+	s = newIdleSimulation(2, 0, 1)
+	n, o = s.NodeCache[0], s.NodeCache[1]
+	n.Location = V{0, 0, 1}
+	o.Location = V{1, 0, 0}
+	n.addPeerAt(o, o.Location)
+	o.addPeerAt(n, n.Location)
+	return
+}
+
+// fromPole is angle radians from the north pole towards the x axis.
+func fromPole(angle float64) V {
+	return V{math.Sin(angle), 0, math.Cos(angle)}
+}
+
+func TestNodeAnnouncesLocationBeyondThreshold(t *testing.T) {
+	s, n, o := newPeerPair()
+	if pushed := n.announceLocation(s, 0.1); pushed != 0 {
+		t.Fatalf("expected the first location not to be announced, got %d", pushed)
+	}
+	tests := []struct {
+		name        string
+		angle       float64
+		expectPush  bool
+		expectKnown V
+	}{
+		{"small move", 0.05, false, V{0, 0, 1}},
+		// Measured from the last location announced, not the last move.
+		{"small moves add up", 0.12, true, fromPole(0.12)},
+		{"large move", 0.5, true, fromPole(0.5)},
+	}
+	for i, test := range tests {
+		n.Location = fromPole(test.angle)
+		pushed := n.announceLocation(s, 0.1)
+		if (pushed == 1) != test.expectPush {
+			t.Fatalf("%s: expected pushed %v, got %d", test.name, test.expectPush, pushed)
+		}
+		runTicks(s, i+1, i+2)
+		if known, _ := o.peers.PeerLocation(n); known.GreatCircleDistance(test.expectKnown) > 1e-9 {
+			t.Fatalf("%s: expected the peer to know %s, got %s", test.name, test.expectKnown, known)
+		}
+	}
+}
+
+func TestNodePullRefreshesPeerLocations(t *testing.T) {
+	s, n, o := newPeerPair()
+	o.Location = V{0, 1, 0}
+	entries, before, _ := n.peerLocationErrors()
+	if entries != 1 || before < 1 {
+		t.Fatalf("expected the moved peer to be misplaced, got %d entries and an error of %v", entries, before)
+	}
+	const interval = 10
+	n.lastPull = 1
+	n.pullPeerLocations(s, interval, interval)
+	if n.awaiting(MsgLocationRequest) {
+		t.Fatal("expected no pull before the interval")
+	}
+	n.pullPeerLocations(s, 1+interval, interval)
+	runTicks(s, 1, 3)
+	if s.LocationsPulled != 1 {
+		t.Fatalf("expected 1 location pulled, got %d", s.LocationsPulled)
+	}
+	if _, after, _ := n.peerLocationErrors(); after >= before || after > 1e-9 {
+		t.Fatalf("expected the error to fall from %v, got %v", before, after)
+	}
+}
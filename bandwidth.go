@@ -0,0 +1,61 @@
+package scr
+
+// transfer uploads as much of the message's payload as the sender and
+// receiver have bandwidth left for this tick, returning whether it is done.
+func transfer(m *Message) bool {
+	from := m.From.physical()
+	to := m.To.physical()
+	n := m.remaining
+	if from.UploadCap > 0 && from.UploadCap-from.uploadedTick < n {
+		n = from.UploadCap - from.uploadedTick
+	}
+	if to.DownloadCap > 0 && to.DownloadCap-to.downloadedTick < n {
+		n = to.DownloadCap - to.downloadedTick
+	}
+	if n > 0 {
+		m.remaining -= n
+		from.uploadedTick += n
+		from.Uploaded += n
+		to.downloadedTick += n
+		to.Downloaded += n
+	}
+	return m.remaining == 0
+}
+
+// progress continues the transfers in the order they were sent, scheduling
+// those that finish. It returns those abandoned because either node has gone
+// offline, which their senders time out as if they had been sent.
+func (w *Network) progress(now int) (abandoned []*Message) {
+	kept := w.transferring[:0]
+	for _, m := range w.transferring {
+		if m.From.physical().departed || m.To.physical().departed {
+			w.carry(m, -1)
+			m.remaining = 0
+			abandoned = append(abandoned, m)
+		} else if transfer(m) {
+			w.schedule(m, now)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	for i := len(kept); i < len(w.transferring); i++ {
+		w.transferring[i] = nil
+	}
+	w.transferring = kept
+	return
+}
+
+// SetBandwidthCaps limits the bytes of Data every node, including those
+// already created, may upload and download each tick. A cap of 0 is no limit.
+func (s *Simulation) SetBandwidthCaps(upload, download int) {
+	for _, c := range s.Classes {
+		c.UploadCap = upload
+		c.DownloadCap = download
+	}
+	for _, n := range s.NodeCache {
+		if n != nil {
+			n.UploadCap = upload
+			n.DownloadCap = download
+		}
+	}
+}
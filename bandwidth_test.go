@@ -0,0 +1,108 @@
+package scr
+
+import (
+	"testing"
+)
+
+// offerOnceState has a node offer Data to a peer the first time it is
+// applied, and otherwise idles.
+type offerOnceState struct {
+	from, to *Node
+	d        *Data
+	offered  bool
+}
+
+func (*offerOnceState) Name() string {
+	return "offerOnce"
+}
+
+func (o *offerOnceState) Apply(n *Node, c Coordinator) (int, string) {
+	if n == o.from && !o.offered {
+		o.offered = true
+		n.offerData(c, o.to, o.d, false)
+	}
+	return n.S.id, ""
+}
+
+// newTransfer has a sender that may upload 3 bytes each tick offer 10 bytes of
+// Data to a receiver with room for it, at tick 0.
+func newTransfer(t *testing.T) (s *Simulation, sender, receiver *Node, d *Data) {
+	s = newIdleSimulation(2, 0, 1)
+	sender, receiver = s.NodeCache[0], s.NodeCache[1]
+	sender.Store = NewMemoryStore(-1, -1)
+	receiver.Store = NewMemoryStore(-1, -1)
+	sender.UploadCap = 3
+	d = &Data{Address: Address("d"), Location: V{1, 0, 0}, DataSize: 10}
+	if !sender.keep(d) {
+		t.Fatal("expected the store to take the Data")
+	}
+	sender.addPeerAt(receiver, receiver.Location)
+	s.States.Replace(0, &offerOnceState{from: sender, to: receiver, d: d})
+	return
+}
+
+func TestNetworkCapsUpload(t *testing.T) {
+	s, sender, receiver, d := newTransfer(t)
+	// Three ticks of 3 bytes, then a fourth of 1.
+	runTicks(s, 0, 3)
+	if sender.Uploaded != 9 || receiver.Downloaded != 9 || s.BytesUploaded != 9 || s.BytesDownloaded != 9 {
+		t.Fatalf("expected 9 bytes transferred in 3 ticks, got %d uploaded and %d downloaded, counted %d and %d",
+			sender.Uploaded, receiver.Downloaded, s.BytesUploaded, s.BytesDownloaded)
+	}
+	if len(s.Network.transferring) != 1 || receiver.holds(d) {
+		t.Fatal("expected the Data still being transferred")
+	}
+	runTicks(s, 3, 4)
+	if sender.Uploaded != 10 || receiver.Downloaded != 10 || s.BytesUploaded != 10 || s.BytesDownloaded != 10 {
+		t.Fatalf("expected all 10 bytes transferred in 4 ticks, got %d uploaded and %d downloaded, counted %d and %d",
+			sender.Uploaded, receiver.Downloaded, s.BytesUploaded, s.BytesDownloaded)
+	}
+	if len(s.Network.transferring) != 0 || s.Network.InTransit() != 1 {
+		t.Fatal("expected the transferred Data to be on its way")
+	}
+	runTicks(s, 4, 6)
+	if !receiver.holds(d) || sender.holds(d) || d.holders != 1 {
+		t.Fatalf("expected the Data to move to the receiver, got %d holders", d.holders)
+	}
+}
+
+func TestNetworkAbandonsTransferOfDeparted(t *testing.T) {
+	tests := []struct {
+		name         string
+		senderLeaves bool
+		expectLost   int
+	}{
+		// The sender still holds the Data it offered.
+		{"receiver departs", false, 0},
+		// The Data goes with the sender, once it is no longer carried.
+		{"sender departs", true, 1},
+	}
+	for _, test := range tests {
+		s, sender, receiver, d := newTransfer(t)
+		runTicks(s, 0, 2)
+		leaving := receiver
+		if test.senderLeaves {
+			leaving = sender
+		}
+		s.nodeLeaves(leaving, false)
+		if s.DataLost != 0 || !s.Network.carries(d) {
+			t.Fatalf("%s: expected the Data in transfer not to be lost yet, got %d lost", test.name, s.DataLost)
+		}
+		runTicks(s, 2, 3)
+		if s.Network.InTransit() != 0 || s.Network.carries(d) {
+			t.Fatalf("%s: expected the transfer abandoned", test.name)
+		}
+		if s.DataLost != test.expectLost {
+			t.Fatalf("%s: expected %d lost, got %d", test.name, test.expectLost, s.DataLost)
+		}
+		if test.senderLeaves {
+			continue
+		}
+		// The sender gives up on the offer, and may offer the Data again.
+		runTicks(s, 3, 3+s.Network.RequestTimeout)
+		if s.RequestsTimedOut != 1 || sender.offered[d] || !sender.holds(d) || s.DataLost != 0 {
+			t.Fatalf("%s: expected the offer to time out with the Data kept, got %d timed out and %d lost",
+				test.name, s.RequestsTimedOut, s.DataLost)
+		}
+	}
+}
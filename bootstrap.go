@@ -0,0 +1,70 @@
+package scr
+
+import (
+	"math/rand"
+)
+
+const (
+	defaultMaxJoinAttempts = 10
+)
+
+// Bootstrap is how a joining node finds a first node to introduce itself to.
+type Bootstrap interface {
+	// BootstrapNode returns nil if bootstrapping failed.
+	BootstrapNode(s *Simulation, joining *Node) *Node
+}
+
+var _ Bootstrap = ArbitraryBootstrap{}
+
+// ArbitraryBootstrap introduces the joining node to any online node, which no
+// real node could know of.
+type ArbitraryBootstrap struct{}
+
+func (ArbitraryBootstrap) BootstrapNode(s *Simulation, joining *Node) *Node {
+	return s.FindOtherArbitraryNode(joining)
+}
+
+var _ Bootstrap = &SeedBootstrap{}
+
+// SeedBootstrap introduces the joining node to one of a fixed set of seed
+// nodes. Seeds that have left, or that are unavailable, fail the bootstrap.
+type SeedBootstrap struct {
+	// Seeds are the IDs of the seed nodes. If empty, the first NSeeds nodes
+	// online when first bootstrapping become the seeds.
+	Seeds  []NodeID
+	NSeeds int
+	// Availability is the chance an online seed answers.
+	Availability float64
+}
+
+func (b *SeedBootstrap) BootstrapNode(s *Simulation, joining *Node) *Node {
+	if len(b.Seeds) == 0 {
+		for _, n := range s.NodeCache {
+			if len(b.Seeds) >= b.NSeeds {
+				break
+			}
+			if n != nil {
+				b.Seeds = append(b.Seeds, n.ID)
+			}
+		}
+	}
+	if len(b.Seeds) == 0 {
+		return nil
+	}
+	o := s.NodeByID(b.Seeds[rand.Intn(len(b.Seeds))])
+	if o == nil || o == joining || rand.Float64() >= b.Availability {
+		return nil
+	}
+	return o
+}
+
+// hasPeers is whether any of the node's positions knows a peer.
+func (n *Node) hasPeers() bool {
+	has := false
+	n.iterateOverPeersWith(func(o *Node) {
+		if o != nil {
+			has = true
+		}
+	})
+	return has
+}
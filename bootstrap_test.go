@@ -0,0 +1,56 @@
+package scr
+
+import (
+	"testing"
+)
+
+func TestJoinGivesUpWithoutBootstrapNode(t *testing.T) {
+	s := newTestSimulation(1, 0, DiscardData)
+	s.States.Replace(StateJoin, JoinState{MaxAttempts: 3, Join: StateJoin, Wait: StateWait})
+	n := s.NodeCache[0]
+	runTicks(s, 0, 3)
+	if n.S.id != StateJoin || n.joinAttempts != 3 {
+		t.Fatalf("expected the lone node still joining after 3 attempts, got state %d after %d", n.S.id, n.joinAttempts)
+	}
+	runTicks(s, 3, 10)
+	if n.S.id == StateJoin || n.joinAttempts != 3 {
+		t.Fatalf("expected the lone node to give up after 3 attempts, got state %d after %d", n.S.id, n.joinAttempts)
+	}
+	if s.JoinAttempts != 3 || s.JoinsFailed != 1 || s.JoinsSucceeded != 0 {
+		t.Fatalf("expected 3 attempts and 1 failed join, got %d attempts, %d failed and %d succeeded",
+			s.JoinAttempts, s.JoinsFailed, s.JoinsSucceeded)
+	}
+}
+
+func TestJoinRetriesUnavailableSeed(t *testing.T) {
+	s := newTestSimulation(2, 0, DiscardData)
+	s.States.Replace(StateJoin, JoinState{Join: StateJoin, Wait: StateWait})
+	seed, n := s.NodeCache[0], s.NodeCache[1]
+	seed.S = State{id: StateWait}
+	seed.WaitActivity = 0
+	b := &SeedBootstrap{Seeds: []NodeID{seed.ID}}
+	s.Bootstrap = b
+	runTicks(s, 0, 5)
+	if n.S.id != StateJoin || n.joinAttempts != 5 || n.hasPeers() {
+		t.Fatalf("expected the node to keep retrying the offline seed, got state %d after %d attempts", n.S.id, n.joinAttempts)
+	}
+	b.Availability = 1
+	joined := -1
+	for i := 5; i < 20 && joined < 0; i++ {
+		runTicks(s, i, i+1)
+		if n.S.id != StateJoin {
+			joined = i
+		}
+	}
+	if joined < 0 || !n.hasPeers() {
+		t.Fatal("expected the node to join once the seed answered")
+	}
+	if s.JoinAttempts != 6 || s.JoinsSucceeded != 1 || s.JoinsFailed != 0 {
+		t.Fatalf("expected 6 attempts and 1 successful join, got %d attempts, %d succeeded and %d failed",
+			s.JoinAttempts, s.JoinsSucceeded, s.JoinsFailed)
+	}
+	// Counted at the tick the node found its first peer.
+	if s.joinTicks != joined-n.createdTick {
+		t.Fatalf("expected %d ticks to the first peer, got %d", joined-n.createdTick, s.joinTicks)
+	}
+}
@@ -0,0 +1,113 @@
+package scr
+
+import (
+	"math/rand"
+)
+
+type SessionLengthFactoryFn func() func() int
+
+// NodeClass is a kind of node, such as an always-on server or a flaky client,
+// with its own distributions of capacity, activity and session length.
+type NodeClass struct {
+	// Name is appended to the metric files of nodes of this class.
+	Name string
+	// Weight is the proportion of created nodes of this class, relative to
+	// the weights of the other classes.
+	Weight float64
+
+	NodeInitialDataFactoryFn     NodeInitialDataFactoryFn
+	AllocateNDataToNodeFactoryFn AllocateNDataToNodeFactoryFn
+	NodeMaxBSizeFactoryFn        NodeMaxBSizeFactoryFn
+	WaitActivityFactoryFn        WaitActivityFactoryFn
+	PeerListFactoryFn            PeerListFactoryFn
+	// SessionLengthFactoryFn gives the ticks a node is online before it
+	// leaves. If nil, or the length is not positive, nodes stay online.
+	SessionLengthFactoryFn SessionLengthFactoryFn
+
+	// The chance each tick of a node of this class joining, and of each node
+	// of this class leaving. Used by ClassChurn.
+	JoinChance  float64
+	LeaveChance float64
+	// Whether nodes of this class hand off their Data before leaving.
+	LeaveGracefully bool
+	// The bytes of Data nodes of this class may upload and download each
+	// tick, 0 for no limit.
+	UploadCap   int
+	DownloadCap int
+}
+
+func (s *Simulation) randomClass() *NodeClass {
+	total := 0.0
+	for _, c := range s.Classes {
+		total += c.Weight
+	}
+	r := rand.Float64() * total
+	for _, c := range s.Classes {
+		if r < c.Weight {
+			return c
+		}
+		r -= c.Weight
+	}
+	return s.Classes[len(s.Classes)-1]
+}
+
+// classIndex is -1 for a class not in the simulation.
+func (s *Simulation) classIndex(c *NodeClass) int {
+	for i, o := range s.Classes {
+		if o == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// nodesOfClass are the online nodes of the class, or all of them if the
+// class is nil.
+func (s *Simulation) nodesOfClass(c *NodeClass) []*Node {
+	nodes := make([]*Node, 0, len(s.NodeCache))
+	for _, n := range s.NodeCache {
+		if n == nil || (c != nil && n.Class != c) {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// count applies f to the counters of the whole simulation and of the node's
+// class.
+func (s *Simulation) count(n *Node, f func(c *Counters)) {
+	f(&s.Counters)
+	if i := s.classIndex(n.physical().Class); i >= 0 {
+		f(&s.ClassCounters[i])
+	}
+}
+
+var _ Tocker = &ClassChurn{}
+
+// ClassChurn is a Tocker that has nodes join and leave according to their
+// class.
+type ClassChurn struct {
+	// After is the iteration after which churn begins.
+	After int
+}
+
+func (c *ClassChurn) Tock(s *Simulation, i int) {
+	if i <= c.After {
+		return
+	}
+	for _, class := range s.Classes {
+		if rand.Float64() < class.JoinChance {
+			s.NewNodeOfClassJoins(class)
+		}
+	}
+	for _, n := range s.NodeCache {
+		if n == nil || n.S.id == StateLeave {
+			continue
+		}
+		sessionOver := n.sessionEnd > 0 && i >= n.sessionEnd
+		if sessionOver || rand.Float64() < n.Class.LeaveChance {
+			s.nodeLeaves(n, n.Class.LeaveGracefully)
+		}
+	}
+}
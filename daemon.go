@@ -0,0 +1,666 @@
+package scr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTickInterval = 100 * time.Millisecond
+	// Daemons answer over the network rather than a tick later, so they
+	// allow more ticks for a response than the simulation does.
+	defaultDaemonRequestTimeout = 10
+	defaultDaemonReportEvery    = 50
+	// The most time spent connecting to a daemon and exchanging a message.
+	daemonIOTimeout = 5 * time.Second
+	// The most daemons Get asks for Data.
+	maxFetchHops = 8
+)
+
+// ErrEmptyData is returned by Daemon.Put for Data without content.
+var ErrEmptyData = errors.New("empty data")
+
+// ErrDataTooLarge is returned by Daemon.Put for Data too large to be sent.
+var ErrDataTooLarge = errors.New("data too large")
+
+// Daemon hosts one Node, driving it through the same ticks as a Simulation
+// does, with its messages sent to and received from other daemons over TCP in
+// the wire format described in WIRE.md.
+//
+// Daemons are known by the address they listen on. Each remote node is stood
+// in for by a Node whose ID is that address, and whose Location is where it
+// was last heard from. Only the interactions of joining, finding peers,
+// exchanging Data and keeping peer locations up to date are spoken, along with
+// fetching Data for clients; messages of any other kind are dropped.
+//
+// The bytes of the node's Data are kept in memory as well as in its Store, as
+// Data swapped away leaves the Store before it is sent.
+type Daemon struct {
+	// Node is the node hosted, which must not have virtual positions.
+	Node *Node
+	// Addr is the address the daemon listens on, as its peers dial it.
+	Addr string
+	// Bootstrap are the addresses of daemons to join through.
+	Bootstrap []string
+	States    *StateRegistry
+	// TickInterval is the time between ticks.
+	TickInterval time.Duration
+	// As for a Simulation, in ticks.
+	RequestTimeout        int
+	PeerTimeout           int
+	MaxPeerFailures       int
+	LocationPushThreshold float64
+	LocationPullInterval  int
+	// Log gets a status line every ReportEvery ticks, 0 for none, and on
+	// departing, and the summary of every state applied if Verbose.
+	Log         io.Writer
+	ReportEvery int
+	Verbose     bool
+
+	ln net.Listener
+	// mu guards everything below, and the node.
+	mu    sync.Mutex
+	tickN int
+	seq   int
+	// The stand-ins for remote nodes by address, and the messages received
+	// from them since the last tick.
+	remotes  map[string]*Node
+	received []*Message
+}
+
+// NewDaemon hosts the node on the listener, joining through the daemons at
+// the bootstrap addresses. The node's Data is read into memory. As in a
+// Simulation, a node whose SolverBudget has no MaxIterations is given
+// defaultSolverMaxIterations, as it solves while holding up its messages.
+func NewDaemon(n *Node, ln net.Listener, bootstrap []string) (*Daemon, error) {
+	if n.SolverBudget.MaxIterations == 0 {
+		n.SolverBudget.MaxIterations = defaultSolverMaxIterations
+	}
+	for _, e := range n.Store.List() {
+		if e.bytes != nil {
+			continue
+		}
+		b, err := n.Store.Read(e.Address)
+		if err != nil {
+			return nil, err
+		}
+		e.bytes = b
+	}
+	return &Daemon{
+		Node:                  n,
+		Addr:                  ln.Addr().String(),
+		Bootstrap:             bootstrap,
+		States:                DefaultStateRegistry(),
+		TickInterval:          defaultTickInterval,
+		RequestTimeout:        defaultDaemonRequestTimeout,
+		PeerTimeout:           defaultPeerTimeout,
+		MaxPeerFailures:       defaultMaxPeerFailures,
+		LocationPushThreshold: defaultLocationPushThreshold,
+		LocationPullInterval:  defaultLocationPullInterval,
+		Log:                   io.Discard,
+		ReportEvery:           defaultDaemonReportEvery,
+		ln:                    ln,
+		remotes:               make(map[string]*Node),
+	}, nil
+}
+
+// Run ticks until the context is done, then leaves gracefully, handing the
+// node's Data off to its peers before closing the listener.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.mu.Lock()
+	d.Node.ID = NodeID(d.Addr)
+	d.mu.Unlock()
+	go d.accept()
+	t := time.NewTicker(d.TickInterval)
+	defer t.Stop()
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			done = nil
+			d.mu.Lock()
+			d.Node.S = State{
+				id:        StateLeave,
+				lastState: d.Node.S.id,
+			}
+			d.mu.Unlock()
+		case <-t.C:
+			if d.tick() {
+				return d.ln.Close()
+			}
+		}
+	}
+}
+
+// Put stores b as Data of the node, returning its address. The node moves to
+// account for it, and gives it to a closer peer in time.
+func (d *Daemon) Put(b []byte) (Address, error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyData
+	} else if len(b) > maxWireData {
+		return nil, ErrDataTooLarge
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.data(b)
+	if d.Node.holds(e) {
+		return e.Address, nil
+	}
+	if err := d.Node.Store.Put(e); err != nil {
+		return nil, err
+	}
+	e.holders++
+	d.Node.computeLocation()
+	return e.Address, nil
+}
+
+// Open is the content of the Data at addr, and its size, from the node's
+// Store if it holds it or else streamed from the daemon that does. It asks the
+// node's peers from the closest to the Data, along with each peer named by a
+// daemon asked that is closer to the Data than it, skipping those that cannot
+// be reached. It returns ErrNotStored if none of those asked hold it, or the
+// last error if none could be asked.
+//
+// The content must be closed. Content streamed from another daemon fails to
+// read its last byte unless all of it matches addr.
+func (d *Daemon) Open(ctx context.Context, addr Address) (content io.ReadCloser, size int64, err error) {
+	loc := AddressToPosition(addr)
+	d.mu.Lock()
+	e, err := d.Node.Store.Get(addr)
+	peers := d.peersByDistance(loc)
+	d.mu.Unlock()
+	if err == nil {
+		b := e.Bytes()
+		return io.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+	}
+	asked := map[string]bool{d.Addr: true}
+	answered := false
+	var lastErr error
+	for hop := 0; len(peers) > 0 && hop < maxFetchHops; {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		p := peers[0]
+		peers = peers[1:]
+		if asked[p.Addr] {
+			continue
+		}
+		asked[p.Addr] = true
+		hop++
+		content, size, next, err := d.fetch(ctx, p.Addr, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered = true
+		if content != nil {
+			return content, size, nil
+		}
+		if next.Addr != "" && loc.GreatCircleDistance(next.Location) < loc.GreatCircleDistance(p.Location) {
+			peers = append(peers, next)
+			sort.SliceStable(peers, func(i, j int) bool {
+				return loc.GreatCircleDistance(peers[i].Location) < loc.GreatCircleDistance(peers[j].Location)
+			})
+		}
+	}
+	if !answered && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	return nil, 0, ErrNotStored
+}
+
+// Get is the content of the Data at addr, read whole. See Open.
+func (d *Daemon) Get(ctx context.Context, addr Address) ([]byte, error) {
+	content, _, err := d.Open(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// fetch asks the daemon at peer for the Data at addr. If it holds it, content
+// streams it from the connection, and must be closed; otherwise next is the
+// peer it named, if any.
+func (d *Daemon) fetch(ctx context.Context, peer string, addr Address) (content io.ReadCloser, size int64, next PeerStatus, err error) {
+	ctx, cancel := context.WithTimeout(ctx, daemonIOTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", peer)
+	if err != nil {
+		return nil, 0, next, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(daemonIOTimeout))
+	d.mu.Lock()
+	h := wireHeader{
+		Kind:     uint8(MsgFetch),
+		From:     d.Addr,
+		Location: d.Node.Location,
+	}
+	d.mu.Unlock()
+	b, err := encodeFrame(h, wireFetch{Address: addr})
+	if err == nil {
+		_, err = conn.Write(b)
+	}
+	var r wireFetchResponse
+	var n int
+	if err == nil {
+		r, n, err = readFetchResponse(conn)
+	}
+	if err != nil || n == 0 {
+		conn.Close()
+		return nil, 0, PeerStatus{Addr: r.Peer, Location: r.PeerLocation}, err
+	}
+	return &verifiedReader{
+		peer: peer,
+		addr: addr,
+		conn: conn,
+		r:    io.LimitReader(conn, int64(n-1)),
+		h:    sha256.New(),
+	}, int64(n), next, nil
+}
+
+// verifiedReader reads the content of Data from a connection, holding back
+// its last byte until all of it hashes to the address, as DataToAddress does.
+type verifiedReader struct {
+	peer string
+	addr Address
+	conn net.Conn
+	// r is the content but its last byte, and last is that byte once
+	// verified.
+	r    io.Reader
+	h    hash.Hash
+	last []byte
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	if v.r == nil {
+		if len(v.last) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, v.last)
+		v.last = v.last[n:]
+		return n, nil
+	}
+	_ = v.conn.SetReadDeadline(time.Now().Add(daemonIOTimeout))
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		v.r = nil
+		if err = v.verify(); err == nil {
+			m := copy(p[n:], v.last)
+			v.last = v.last[m:]
+			n += m
+		}
+	}
+	return n, err
+}
+
+func (v *verifiedReader) verify() error {
+	last := make([]byte, 1)
+	if _, err := io.ReadFull(v.conn, last); err != nil {
+		return fmt.Errorf("%s: %w", v.peer, io.ErrUnexpectedEOF)
+	}
+	v.h.Write(last)
+	if !bytes.Equal(v.h.Sum(nil), v.addr) {
+		return fmt.Errorf("%s answered with other data", v.peer)
+	}
+	v.last = last
+	return nil
+}
+
+func (v *verifiedReader) Close() error {
+	return v.conn.Close()
+}
+
+// answerFetch answers with the Data asked for, or with the peer closest to it.
+func (d *Daemon) answerFetch(conn net.Conn, f wireFetch) error {
+	d.mu.Lock()
+	h := wireHeader{
+		Kind:     uint8(MsgFetchResponse),
+		From:     d.Addr,
+		Location: d.Node.Location,
+	}
+	var r wireFetchResponse
+	b, err := d.Node.Store.Read(f.Address)
+	if err == nil {
+		r.Data = b
+	} else {
+		r.Peer, r.PeerLocation = d.closestPeer(AddressToPosition(f.Address))
+	}
+	d.mu.Unlock()
+	if b, err = encodeFrame(h, r); err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+// closestPeer is the address of the node's peer believed to be closest to
+// loc, and where it is believed to be, or empty if it has no peers.
+func (d *Daemon) closestPeer(loc V) (addr string, at V) {
+	if peers := d.peersByDistance(loc); len(peers) > 0 {
+		return peers[0].Addr, peers[0].Location
+	}
+	return "", V{}
+}
+
+// peersByDistance are the node's peers whose location is known, from the one
+// believed to be closest to loc.
+func (d *Daemon) peersByDistance(loc V) (peers []PeerStatus) {
+	d.Node.peers.IterateOverPeersWith(func(o *Node) {
+		if o == nil {
+			return
+		}
+		if at, ok := d.Node.peers.PeerLocation(o); ok {
+			peers = append(peers, PeerStatus{Addr: string(o.ID), Location: at})
+		}
+	})
+	sort.SliceStable(peers, func(i, j int) bool {
+		return loc.GreatCircleDistance(peers[i].Location) < loc.GreatCircleDistance(peers[j].Location)
+	})
+	return
+}
+
+// DaemonStatus is what a Daemon's node is doing.
+type DaemonStatus struct {
+	Addr  string
+	State string
+	// Location is where the node is, and Objective its f(x) over its Data.
+	Location  V
+	Objective float64
+	// The pieces and bytes of Data stored, and the Store's capacity for
+	// them, negative for no limit.
+	Items    int
+	Bytes    int
+	MaxItems int
+	MaxBytes int
+	Peers    []PeerStatus
+}
+
+// PeerStatus is a peer of a Daemon's node, and where it is believed to be.
+type PeerStatus struct {
+	Addr     string
+	Location V
+}
+
+func (d *Daemon) Status() DaemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.Node
+	st := DaemonStatus{
+		Addr:      d.Addr,
+		Location:  n.Location,
+		Objective: n.fx,
+	}
+	if h := d.States.Handler(n.S.id); h != nil {
+		st.State = h.Name()
+	}
+	st.Items, st.Bytes = n.Store.Used()
+	st.MaxItems, st.MaxBytes = n.Store.Capacity()
+	n.peers.IterateOverPeersWith(func(o *Node) {
+		if o == nil {
+			return
+		}
+		loc, _ := n.peers.PeerLocation(o)
+		st.Peers = append(st.Peers, PeerStatus{Addr: string(o.ID), Location: loc})
+	})
+	return st
+}
+
+// tick is Simulation.tick for the one node, returning whether it departed.
+func (d *Daemon) tick() (departed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tickN++
+	n := d.Node
+	n.evictStalePeers(d.tickN, d.PeerTimeout, d.MaxPeerFailures)
+	n.expireRequests(d.tickN, d.RequestTimeout)
+	n.inbox = append(n.inbox, d.received...)
+	d.received = nil
+	n.handleMessages(d, nil, nil)
+	// Whether Data let go of is lost is for its new holders to know.
+	n.released = nil
+	summary := n.ApplyState(d)
+	if len(summary) > 0 && d.Verbose {
+		fmt.Fprintf(d.Log, "%d: %s\n", d.tickN, summary)
+	}
+	if !n.departed {
+		if d.LocationPushThreshold >= 0 {
+			n.announceLocation(d, d.LocationPushThreshold)
+		}
+		if d.LocationPullInterval > 0 {
+			n.pullPeerLocations(d, d.tickN, d.LocationPullInterval)
+		}
+	}
+	n.AdvanceState()
+	if d.ReportEvery > 0 && (d.tickN%d.ReportEvery == 0 || n.departed) {
+		d.report()
+	}
+	return n.departed
+}
+
+// report writes the node's status to the log.
+func (d *Daemon) report() {
+	n := d.Node
+	items, bytes := n.Store.Used()
+	avg := 0.0
+	if n.nfx > 0 {
+		avg = n.fx / float64(n.nfx)
+	}
+	fmt.Fprintf(d.Log, "%d: node %s holds %d data (%d bytes), f(x)/n %.4f, %d peers\n",
+		d.tickN, n, items, bytes, avg, len(n.PeerLocations()))
+}
+
+func (d *Daemon) BootstrapNode(joining *Node) *Node {
+	var addrs []string
+	for _, a := range d.Bootstrap {
+		if a != d.Addr {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+	return d.remote(addrs[rand.Intn(len(addrs))])
+}
+
+func (d *Daemon) StateHandler(id int) StateHandler {
+	return d.States.Handler(id)
+}
+
+// Send encodes the message at once, as the Data it carries may change hands
+// before it is written. Messages of kinds the daemon does not speak are not
+// sent.
+func (d *Daemon) Send(m *Message) {
+	if _, ok := wireBody(m.Kind); !ok {
+		return
+	}
+	if m.Kind.isRequest() {
+		d.seq++
+		m.Seq = d.seq
+	}
+	m.sentTick = d.tickN
+	b, err := encodeFrame(d.encode(m))
+	if err != nil {
+		// Lost, as a message that cannot be delivered is.
+		fmt.Fprintf(d.Log, "%d: dropped %s to %s: %s\n", d.tickN, m.Kind, m.To.physical().ID, err)
+		return
+	}
+	go d.deliver(string(m.To.physical().ID), b)
+}
+
+// deliver writes the encoded message to the daemon at addr. A message that
+// cannot be delivered is lost, as on the Network.
+func (d *Daemon) deliver(addr string, b []byte) {
+	conn, err := net.DialTimeout("tcp", addr, daemonIOTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(daemonIOTimeout))
+	_, _ = conn.Write(b)
+}
+
+// accept serves connections until the listener is closed.
+func (d *Daemon) accept() {
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.serve(conn)
+	}
+}
+
+// serve receives the messages written to the connection, for the next tick to
+// handle. Frames that cannot be decoded are skipped.
+func (d *Daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(daemonIOTimeout))
+	for {
+		b, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		h, body, err := decodeFrame(b)
+		if err != nil {
+			continue
+		}
+		switch f := body.(type) {
+		case wireFetch:
+			if err := d.answerFetch(conn, f); err != nil {
+				return
+			}
+			continue
+		case wireFetchResponse:
+			// Only meaningful on the connection fetching.
+			continue
+		}
+		d.mu.Lock()
+		if m := d.decode(h, body); m != nil {
+			d.received = append(d.received, m)
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Daemon) encode(m *Message) (h wireHeader, body interface{}) {
+	h = wireHeader{
+		Kind:     uint8(m.Kind),
+		Seq:      uint64(m.Seq),
+		From:     d.Addr,
+		Location: m.Location,
+	}
+	switch m.Kind {
+	case MsgPeerResponse:
+		r := wirePeerResponse{}
+		if m.Peer != nil {
+			r.Peer = string(m.Peer.physical().ID)
+			r.PeerLocation = m.PeerLocation
+		}
+		body = r
+	case MsgData:
+		body = wireData{
+			HandOff:  m.HandOff,
+			BSize:    int64(m.BSize),
+			MaxBSize: int64(m.MaxBSize),
+			Data:     m.Data.Bytes(),
+		}
+	case MsgDataAck:
+		a := wireDataAck{
+			HandOff:  m.HandOff,
+			Accepted: m.Accepted,
+			Address:  m.Data.Address,
+		}
+		if m.Swap != nil {
+			a.Swap = m.Swap.Bytes()
+		}
+		body = a
+	}
+	return
+}
+
+// decode is the message received, or nil if it claims to be from this daemon.
+func (d *Daemon) decode(h wireHeader, body interface{}) *Message {
+	if h.From == "" || h.From == d.Addr {
+		return nil
+	}
+	from := d.remote(h.From)
+	from.Location = h.Location
+	m := &Message{
+		Kind:     MessageKind(h.Kind),
+		From:     from,
+		To:       d.Node,
+		Seq:      int(h.Seq),
+		Location: h.Location,
+	}
+	switch b := body.(type) {
+	case wirePeerResponse:
+		if b.Peer != "" {
+			m.Peer = d.remote(b.Peer)
+			m.PeerLocation = b.PeerLocation
+			if m.Peer.Location.Equals(V{}) {
+				m.Peer.Location = b.PeerLocation
+			}
+		}
+	case wireData:
+		m.HandOff = b.HandOff
+		m.BSize = int(b.BSize)
+		m.MaxBSize = int(b.MaxBSize)
+		m.Data = d.data(b.Data)
+	case wireDataAck:
+		m.HandOff = b.HandOff
+		m.Accepted = b.Accepted
+		m.Data = d.acknowledged(b.Address)
+		if len(b.Swap) > 0 {
+			m.Swap = d.data(b.Swap)
+		}
+	}
+	return m
+}
+
+// remote is the stand-in for the node at addr, or the hosted node if it is
+// the daemon's own address.
+func (d *Daemon) remote(addr string) *Node {
+	if addr == d.Addr {
+		return d.Node
+	}
+	o, ok := d.remotes[addr]
+	if !ok {
+		o = &Node{ID: NodeID(addr)}
+		d.remotes[addr] = o
+	}
+	return o
+}
+
+// data is the Data with the content b, as stored if the node holds it.
+func (d *Daemon) data(b []byte) *Data {
+	e := NewData(b)
+	if held, err := d.Node.Store.Get(e.Address); err == nil {
+		return held
+	}
+	e.bytes = b
+	return e
+}
+
+// acknowledged is the Data at addr, as stored if the node still holds it.
+func (d *Daemon) acknowledged(addr Address) *Data {
+	if held, err := d.Node.Store.Get(addr); err == nil {
+		return held
+	}
+	return &Data{
+		Address:  addr,
+		Location: AddressToPosition(addr),
+	}
+}
@@ -0,0 +1,302 @@
+package scr
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// startDaemon runs a daemon on a loopback port, ticking every few
+// milliseconds, until the test ends.
+func startDaemon(t *testing.T, bootstrap []string) *Daemon {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	d, err := NewDaemon(n, ln, bootstrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.TickInterval = 5 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+func TestNewDaemonBoundsSolver(t *testing.T) {
+	for _, test := range []struct {
+		maxIterations int
+		expected      int
+	}{
+		{0, defaultSolverMaxIterations},
+		{50, 50},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		n := NewNode(NewMemoryStore(-1, -1), 1, Budget{MaxIterations: test.maxIterations}, NewMaximizePeerSpread(8))
+		d, err := NewDaemon(n, ln, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Node.SolverBudget.MaxIterations != test.expected {
+			t.Fatalf("expected a budget of %d iterations for %d, got %d",
+				test.expected, test.maxIterations, d.Node.SolverBudget.MaxIterations)
+		}
+	}
+}
+
+func TestDaemonIgnoresUnsolicitedDataAck(t *testing.T) {
+	d := startDaemon(t, nil)
+	addr, err := d.Put([]byte("keep me"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", d.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Acknowledge taking the Data without having been offered it, under
+	// every Seq the daemon could have used, and push other Data on it in
+	// return.
+	for seq := 0; seq < 16; seq++ {
+		b, err := encodeFrame(wireHeader{
+			Kind: uint8(MsgDataAck),
+			Seq:  uint64(seq),
+			From: "127.0.0.1:1",
+		}, wireDataAck{Accepted: true, Address: addr, Swap: []byte("pushed")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * d.TickInterval)
+	if st := d.Status(); st.Items != 1 {
+		t.Fatalf("expected only the Data put to be kept, got %d items", st.Items)
+	}
+}
+
+func TestDaemonDropsUnencodableMessage(t *testing.T) {
+	d := startDaemon(t, nil)
+	var log bytes.Buffer
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Log = &log
+	d.Send(&Message{
+		Kind: MsgData,
+		To:   d.remote("127.0.0.1:1"),
+		Data: retainedData(string(make([]byte, maxWireData+1))),
+	})
+	if !bytes.Contains(log.Bytes(), []byte("dropped data")) {
+		t.Fatalf("expected the oversize Data dropped, got log %q", log.String())
+	}
+}
+
+// objectivePerData is f(x) per piece of Data over all the daemons' nodes, and
+// the Data they hold.
+func objectivePerData(ds []*Daemon) (avg float64, items int) {
+	fx := 0.0
+	for _, d := range ds {
+		st := d.Status()
+		fx += st.Objective
+		items += st.Items
+	}
+	return fx / float64(items), items
+}
+
+func TestDaemonsMoveDataCloser(t *testing.T) {
+	const nDaemons, nData = 4, 20
+	first := startDaemon(t, nil)
+	ds := []*Daemon{first}
+	for i := 1; i < nDaemons; i++ {
+		ds = append(ds, startDaemon(t, []string{first.Addr}))
+	}
+	put := make(map[string]*Daemon)
+	var content [][]byte
+	for _, d := range ds {
+		for j := 0; j < nData; j++ {
+			b := make([]byte, 64)
+			_, _ = rand.Read(b)
+			addr, err := d.Put(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			put[string(addr)] = d
+			content = append(content, b)
+		}
+	}
+	start, _ := objectivePerData(ds)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		time.Sleep(50 * time.Millisecond)
+		if avg, _ := objectivePerData(ds); avg < 0.8*start {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected f(x)/n to fall from %v, got %v", start, avg)
+		}
+	}
+	moved := 0
+	for _, d := range ds {
+		d.mu.Lock()
+		for _, e := range d.Node.Store.List() {
+			if put[string(e.Address)] != d {
+				moved++
+			}
+		}
+		d.mu.Unlock()
+	}
+	if moved == 0 {
+		t.Fatalf("expected Data to move between daemons")
+	}
+	// Every piece can be fetched through any daemon, wherever it went,
+	// though not always at once while Data is still changing hands.
+	for i, b := range content {
+		var got []byte
+		var err error
+		for try := 0; try < 20; try++ {
+			if got, err = ds[i%nDaemons].Get(context.Background(), DataToAddress(b)); err == nil {
+				break
+			}
+			time.Sleep(10 * first.TickInterval)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, b) {
+			t.Fatalf("expected %x, got %x", b, got)
+		}
+	}
+}
+
+func TestDaemonGetSkipsUnreachablePeers(t *testing.T) {
+	a := startDaemon(t, nil)
+	b := startDaemon(t, []string{a.Addr})
+	for len(a.Status().Peers) == 0 {
+		time.Sleep(a.TickInterval)
+	}
+	content := []byte("held by b")
+	addr, err := b.Put(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A peer that cannot be reached, at the Data itself.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+	a.mu.Lock()
+	a.Node.introducePeerAt(a.remote(dead), AddressToPosition(addr))
+	a.mu.Unlock()
+	got, err := a.Get(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expected %q, got %q", content, got)
+	}
+}
+
+func TestDaemonGetRejectsOtherData(t *testing.T) {
+	d := startDaemon(t, nil)
+	// A peer that answers every fetch with the same Data.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := readFrame(conn); err == nil {
+				b, _ := encodeFrame(wireHeader{
+					Kind: uint8(MsgFetchResponse),
+					From: ln.Addr().String(),
+				}, wireFetchResponse{Data: []byte("other")})
+				_, _ = conn.Write(b)
+			}
+			conn.Close()
+		}
+	}()
+	addr := DataToAddress([]byte("wanted"))
+	d.mu.Lock()
+	d.Node.introducePeerAt(d.remote(ln.Addr().String()), AddressToPosition(addr))
+	d.mu.Unlock()
+	if b, err := d.Get(context.Background(), addr); err == nil {
+		t.Fatalf("expected an error, got %q", b)
+	}
+}
+
+// settle calls step until f(x)/n, as it returns it, has not fallen 1% below
+// its lowest for five steps, returning the lowest.
+func settle(step func() float64, maxSteps int) float64 {
+	lowest, since := step(), 0
+	for i := 1; i < maxSteps && since < 5; i++ {
+		if avg := step(); avg < 0.99*lowest {
+			lowest, since = avg, 0
+		} else {
+			since++
+		}
+	}
+	return lowest
+}
+
+func TestDaemonsConvergeLikeSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs twenty daemons until they settle")
+	}
+	const nDaemons, nData = 20, 10
+	first := startDaemon(t, nil)
+	ds := []*Daemon{first}
+	for i := 1; i < nDaemons; i++ {
+		ds = append(ds, startDaemon(t, []string{first.Addr}))
+	}
+	for _, d := range ds {
+		for j := 0; j < nData; j++ {
+			b := make([]byte, 64)
+			_, _ = rand.Read(b)
+			if _, err := d.Put(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	daemons := settle(func() float64 {
+		time.Sleep(200 * first.TickInterval)
+		avg, _ := objectivePerData(ds)
+		return avg
+	}, 100)
+	// The same number of nodes and Data, with as much room as the daemons'.
+	s := newTestSimulation(nDaemons, nData, DiscardData)
+	s.SetStoreFactory(func(int, int) Store { return NewMemoryStore(-1, -1) })
+	tickN := 0
+	simulated := settle(func() float64 {
+		runTicks(s, tickN, tickN+50)
+		tickN += 50
+		fx, _, nfx := computeFxStatistics(s.NodeCache)
+		return fx / float64(nfx)
+	}, 100)
+	if daemons > 1.5*simulated || simulated > 1.5*daemons {
+		t.Fatalf("expected the daemons to settle near the simulation's f(x)/n of %v, got %v", simulated, daemons)
+	}
+}
@@ -9,12 +9,21 @@ import (
 // The actual implementation for how to map "content" => "address" doesn't
 // matter to this routing simulation.
 //
-// Note we don't actually store the random data generated, as it's uninteresting
-// and a waste of RAM.
+// Note we don't actually store the random data generated by default, as it's
+// uninteresting and a waste of RAM. See DataRetention.
 type Data struct {
 	Address  Address
 	Location V
 	DataSize int
+	// The simulation tick at which this Data was created.
+	createdTick int
+	// The number of node Stores holding this Data. A lost acknowledgement
+	// can leave it held by both sides of an exchange.
+	holders int
+	// The bytes of the Data if they are retained, or else the seed they
+	// are regenerated from if it is not 0. See DataRetention.
+	bytes []byte
+	seed  int64
 }
 
 func NewData(b []byte) *Data {
@@ -24,6 +33,17 @@ func NewData(b []byte) *Data {
 	return d
 }
 
+// Bytes are the content of the Data, or nil if it was discarded.
+func (d *Data) Bytes() []byte {
+	if d.bytes != nil {
+		return d.bytes
+	}
+	if d.seed != 0 {
+		return regenerateData(d.seed, d.DataSize)
+	}
+	return nil
+}
+
 func (d Data) String() string {
 	return fmt.Sprintf("%s@%s", d.Address, d.Location)
 }
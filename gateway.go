@@ -0,0 +1,189 @@
+package scr
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Gateway is the HTTP API of a Daemon, for applications to store and retrieve
+// Data through it:
+//
+//	PUT /data              stores the request body, answering its address
+//	GET /data/{address}    the content at the address, from whichever daemon holds it
+//	GET /peers             the node's peers, and where they are believed to be
+//	GET /status            the node's location, objective, store use and peers
+//
+// Addresses are in hex, and answers other than content are JSON. Data put is
+// stored by this daemon's node, which gives it to a closer peer in time.
+type Gateway struct {
+	d *Daemon
+}
+
+var _ http.Handler = &Gateway{}
+
+func NewGateway(d *Daemon) *Gateway {
+	return &Gateway{d: d}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path := r.URL.Path; {
+	case path == "/data":
+		if allowMethod(w, r, http.MethodPut) {
+			g.put(w, r)
+		}
+	case strings.HasPrefix(path, "/data/"):
+		if allowMethod(w, r, http.MethodGet) {
+			g.get(w, r, strings.TrimPrefix(path, "/data/"))
+		}
+	case path == "/peers":
+		if allowMethod(w, r, http.MethodGet) {
+			g.peers(w, r)
+		}
+	case path == "/status":
+		if allowMethod(w, r, http.MethodGet) {
+			g.status(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowMethod answers that the method is not allowed, unless it is the one
+// the path is served for.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// jsonLocation is a V as the gateway answers it.
+type jsonLocation struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+func toJSONLocation(v V) jsonLocation {
+	return jsonLocation{X: v.X, Y: v.Y, Z: v.Z}
+}
+
+type jsonPeer struct {
+	Address  string       `json:"address"`
+	Location jsonLocation `json:"location"`
+}
+
+type jsonStatus struct {
+	Address  string       `json:"address"`
+	State    string       `json:"state"`
+	Location jsonLocation `json:"location"`
+	// The objective over the node's Data, and that per piece.
+	Objective        float64 `json:"objective"`
+	ObjectivePerData float64 `json:"objective_per_data"`
+	// Negative maximums are no limit.
+	Data          int            `json:"data"`
+	Bytes         int            `json:"bytes"`
+	MaxData       int            `json:"max_data"`
+	MaxBytes      int            `json:"max_bytes"`
+	PeerLocations []jsonLocation `json:"peer_locations"`
+}
+
+func (g *Gateway) put(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWireData))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, ErrDataTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	addr, err := g.d.Put(b)
+	switch {
+	case errors.Is(err, ErrEmptyData):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrDataTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrStoreFull):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		Address string `json:"address"`
+	}{hex.EncodeToString(addr)})
+}
+
+func (g *Gateway) get(w http.ResponseWriter, r *http.Request, address string) {
+	addr, err := hex.DecodeString(address)
+	if err != nil || len(addr) == 0 {
+		http.Error(w, "address is not hex", http.StatusBadRequest)
+		return
+	}
+	content, size, err := g.d.Open(r.Context(), addr)
+	if errors.Is(err, ErrNotStored) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, content); err != nil {
+		// Cut the response short of its length, so the client does
+		// not take it for the content.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (g *Gateway) peers(w http.ResponseWriter, r *http.Request) {
+	peers := []jsonPeer{}
+	for _, p := range g.d.Status().Peers {
+		peers = append(peers, jsonPeer{
+			Address:  p.Addr,
+			Location: toJSONLocation(p.Location),
+		})
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (g *Gateway) status(w http.ResponseWriter, r *http.Request) {
+	st := g.d.Status()
+	js := jsonStatus{
+		Address:       st.Addr,
+		State:         st.State,
+		Location:      toJSONLocation(st.Location),
+		Objective:     st.Objective,
+		Data:          st.Items,
+		Bytes:         st.Bytes,
+		MaxData:       st.MaxItems,
+		MaxBytes:      st.MaxBytes,
+		PeerLocations: []jsonLocation{},
+	}
+	if st.Items > 0 {
+		js.ObjectivePerData = st.Objective / float64(st.Items)
+	}
+	for _, p := range st.Peers {
+		js.PeerLocations = append(js.PeerLocations, toJSONLocation(p.Location))
+	}
+	writeJSON(w, http.StatusOK, js)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
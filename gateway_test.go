@@ -0,0 +1,132 @@
+package scr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startGateway serves the gateway of a daemon joined to the others given,
+// until the test ends.
+func startGateway(t *testing.T, bootstrap []string) (*Daemon, *httptest.Server) {
+	d := startDaemon(t, bootstrap)
+	srv := httptest.NewServer(NewGateway(d))
+	t.Cleanup(srv.Close)
+	return d, srv
+}
+
+func doRequest(t *testing.T, method, url string, body []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+func TestGatewayPutGet(t *testing.T) {
+	_, srv := startGateway(t, nil)
+	content := []byte("some data")
+	resp, b := doRequest(t, http.MethodPut, srv.URL+"/data", content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, resp.StatusCode, b)
+	}
+	var put struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(b, &put); err != nil {
+		t.Fatal(err)
+	}
+	if expected := hex.EncodeToString(DataToAddress(content)); put.Address != expected {
+		t.Fatalf("expected %s, got %s", expected, put.Address)
+	}
+	resp, b = doRequest(t, http.MethodGet, srv.URL+"/data/"+put.Address, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(b, content) {
+		t.Fatalf("expected %d %q, got %d %q", http.StatusOK, content, resp.StatusCode, b)
+	}
+	if resp.ContentLength != int64(len(content)) {
+		t.Fatalf("expected length %d, got %d", len(content), resp.ContentLength)
+	}
+}
+
+func TestGatewayGetFromPeer(t *testing.T) {
+	a, srv := startGateway(t, nil)
+	b := startDaemon(t, []string{a.Addr})
+	for len(a.Status().Peers) == 0 {
+		time.Sleep(a.TickInterval)
+	}
+	content := bytes.Repeat([]byte("held by a peer "), 1000)
+	addr, err := b.Put(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, got := doRequest(t, http.MethodGet, srv.URL+"/data/"+hex.EncodeToString(addr), nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, content) {
+		t.Fatalf("expected %d and the content, got %d %q", http.StatusOK, resp.StatusCode, got)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	_, srv := startGateway(t, nil)
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     []byte
+		expected int
+	}{
+		{"missing", http.MethodGet, "/data/" + hex.EncodeToString(DataToAddress([]byte("missing"))), nil, http.StatusNotFound},
+		{"bad hex", http.MethodGet, "/data/xyz", nil, http.StatusBadRequest},
+		{"no address", http.MethodGet, "/data/", nil, http.StatusBadRequest},
+		{"empty", http.MethodPut, "/data", nil, http.StatusBadRequest},
+		{"oversize", http.MethodPut, "/data", make([]byte, maxWireData+1), http.StatusRequestEntityTooLarge},
+		{"method", http.MethodGet, "/data", nil, http.StatusMethodNotAllowed},
+		{"unknown", http.MethodGet, "/nothing", nil, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, b := doRequest(t, test.method, srv.URL+test.path, test.body)
+		if resp.StatusCode != test.expected {
+			t.Fatalf("%s: expected %d, got %d: %s", test.name, test.expected, resp.StatusCode, b)
+		}
+	}
+}
+
+func TestGatewayPeersStatus(t *testing.T) {
+	a, srv := startGateway(t, nil)
+	b := startDaemon(t, []string{a.Addr})
+	for len(a.Status().Peers) == 0 {
+		time.Sleep(a.TickInterval)
+	}
+	if _, err := a.Put([]byte("status")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := doRequest(t, http.MethodGet, srv.URL+"/peers", nil)
+	var peers []jsonPeer
+	if err := json.Unmarshal(body, &peers); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(peers) != 1 || peers[0].Address != b.Addr {
+		t.Fatalf("expected %d and peer %s, got %d %s", http.StatusOK, b.Addr, resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, http.MethodGet, srv.URL+"/status", nil)
+	var st jsonStatus
+	if err := json.Unmarshal(body, &st); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || st.Address != a.Addr || len(st.PeerLocations) != 1 || st.MaxData != -1 {
+		t.Fatalf("expected %d and the status of %s, got %d %s", http.StatusOK, a.Addr, resp.StatusCode, body)
+	}
+}
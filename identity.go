@@ -0,0 +1,49 @@
+package scr
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// nodeIDBytes of a public key are kept in a NodeID, which is plenty to
+	// be unique within a simulation and short enough to read in logs.
+	nodeIDBytes = 8
+)
+
+// NodeID identifies a node for as long as it is online, however it moves.
+type NodeID string
+
+// NodeIDFromPublicKey derives the ID of a node from its public key.
+func NodeIDFromPublicKey(pub ed25519.PublicKey) NodeID {
+	return NodeID(hex.EncodeToString(pub[:nodeIDBytes]))
+}
+
+// String identifies the node, and the position it is presenting.
+func (n *Node) String() string {
+	return fmt.Sprintf("%s at %s", n.physical().ID, n.Location)
+}
+
+// assignIdentity gives the node an ID from a new key pair if the simulation
+// uses keys, or from a counter if it does not.
+func (s *Simulation) assignIdentity(n *Node) {
+	if s.NodeKeys {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		n.PublicKey = pub
+		n.ID = NodeIDFromPublicKey(pub)
+	} else {
+		s.nNodeIDs++
+		n.ID = NodeID(fmt.Sprintf("%d", s.nNodeIDs))
+	}
+	s.nodesByID[n.ID] = n
+}
+
+// NodeByID returns the online node with the ID, or nil.
+func (s *Simulation) NodeByID(id NodeID) *Node {
+	return s.nodesByID[id]
+}
@@ -0,0 +1,183 @@
+package scr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultRegions        = 8
+	defaultRegionSpread   = 0.05
+	defaultMinLatency     = 1
+	defaultRouteInflation = 1.5
+	// Kilometers, and kilometers light travels through fiber in a
+	// millisecond.
+	earthRadius  = 6371
+	fiberKmPerMs = 200
+)
+
+// Host is where a node sits in a LatencySpace, unrelated to its Location.
+type Host int
+
+// LatencySpace is the physical network the nodes run on.
+type LatencySpace interface {
+	// NewHost places a newly created node.
+	NewHost() Host
+	// Latency is the one-way latency between two hosts, in milliseconds.
+	Latency(a, b Host) float64
+}
+
+var _ LatencySpace = &SyntheticGeography{}
+
+// SyntheticGeography places hosts on the Earth, clustered around regions, and
+// takes latency to grow with the distance between them.
+type SyntheticGeography struct {
+	// Regions are the centers hosts cluster around.
+	Regions []V
+	// Spread is the standard deviation, in radians, of the distance of a
+	// host from its region.
+	Spread float64
+	// MinLatency is the latency between hosts in the same place, in
+	// milliseconds, and RouteInflation is how much longer routes are than
+	// the great circle between hosts.
+	MinLatency     float64
+	RouteInflation float64
+
+	hosts []V
+}
+
+// NewSyntheticGeography has regions at random points on the Earth.
+func NewSyntheticGeography(nRegions int, spread float64) *SyntheticGeography {
+	g := &SyntheticGeography{
+		Regions:        make([]V, nRegions),
+		Spread:         spread,
+		MinLatency:     defaultMinLatency,
+		RouteInflation: defaultRouteInflation,
+	}
+	for i := range g.Regions {
+		g.Regions[i] = RandomVector()
+	}
+	return g
+}
+
+func (g *SyntheticGeography) NewHost() Host {
+	r := g.Regions[rand.Intn(len(g.Regions))]
+	// Move away from the region in a random direction.
+	u := RandomVector()
+	t := u.Sub(r.MulScalar(u.Dot(r)))
+	if t.Norm() == 0 {
+		g.hosts = append(g.hosts, r)
+		return Host(len(g.hosts) - 1)
+	}
+	a := math.Abs(rand.NormFloat64() * g.Spread)
+	loc := r.MulScalar(math.Cos(a)).Add(t.Unit().MulScalar(math.Sin(a)))
+	g.hosts = append(g.hosts, loc)
+	return Host(len(g.hosts) - 1)
+}
+
+// Location is where on the Earth the host is.
+func (g *SyntheticGeography) Location(h Host) V {
+	return g.hosts[h]
+}
+
+func (g *SyntheticGeography) Latency(a, b Host) float64 {
+	km := g.hosts[a].GreatCircleDistance(g.hosts[b]) * earthRadius
+	return g.MinLatency + km*g.RouteInflation/fiberKmPerMs
+}
+
+var _ LatencySpace = &LatencyMatrix{}
+
+// LatencyMatrix has measured latencies between a fixed set of hosts, which
+// nodes are placed on at random. Nodes may share a host.
+type LatencyMatrix struct {
+	// Latencies[a][b] is from host a to host b, in milliseconds.
+	Latencies [][]float64
+}
+
+// ReadLatencyMatrix reads a square matrix of latencies in milliseconds, a row
+// per line, with entries separated by commas or whitespace. Lines beginning
+// with '#' are ignored. A negative entry is unknown, and is taken from the
+// reverse direction, or else the mean of the known entries.
+func ReadLatencyMatrix(r io.Reader) (*LatencyMatrix, error) {
+	var rows [][]float64
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<24)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		row := make([]float64, len(fields))
+		for i, f := range fields {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			row[i] = v
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no latencies")
+	}
+	sum := 0.0
+	known := 0
+	for i, row := range rows {
+		if len(row) != len(rows) {
+			return nil, fmt.Errorf("row %d: expected %d entries but got %d", i+1, len(rows), len(row))
+		}
+		for _, v := range row {
+			if v >= 0 {
+				sum += v
+				known++
+			}
+		}
+	}
+	mean := 0.0
+	if known > 0 {
+		mean = sum / float64(known)
+	}
+	m := &LatencyMatrix{Latencies: make([][]float64, len(rows))}
+	for i, row := range rows {
+		m.Latencies[i] = make([]float64, len(row))
+		for j, v := range row {
+			if v < 0 {
+				v = rows[j][i]
+			}
+			if v < 0 {
+				v = mean
+			}
+			m.Latencies[i][j] = v
+		}
+	}
+	return m, nil
+}
+
+func (m *LatencyMatrix) NewHost() Host {
+	return Host(rand.Intn(len(m.Latencies)))
+}
+
+func (m *LatencyMatrix) Latency(a, b Host) float64 {
+	return m.Latencies[a][b]
+}
+
+// SpaceLatency has messages take the latency between the hosts of their
+// nodes, in ticks of msPerTick milliseconds.
+func SpaceLatency(space LatencySpace, msPerTick float64) LatencyFn {
+	return func(from, to *Node) int {
+		return int(math.Ceil(space.Latency(from.physical().Host, to.physical().Host) / msPerTick))
+	}
+}
@@ -0,0 +1,50 @@
+package scr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLatencyMatrix is from host a, by row, to host b, by column. Going from
+// host 0 to host 2 through host 1 takes four times as long as going directly.
+const testLatencyMatrix = `# milliseconds
+0, 10, 5
+10, 0, 10
+5, -1, 0
+`
+
+func TestReadLatencyMatrix(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		expect [][]float64
+	}{
+		{"unknown from reverse", testLatencyMatrix, [][]float64{{0, 10, 5}, {10, 0, 10}, {5, 10, 0}}},
+		// The known entries are 0, 4, 2, 0 and 0.
+		{"unknown both ways", "0 -1 4\n2\t0 -1\n-1 -1 0\n", [][]float64{{0, 2, 4}, {2, 0, 1.2}, {4, 1.2, 0}}},
+	}
+	for _, test := range tests {
+		m, err := ReadLatencyMatrix(strings.NewReader(test.in))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(m.Latencies, test.expect) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expect, m.Latencies)
+		}
+	}
+}
+
+func TestReadLatencyMatrixErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"# only a comment\n",
+		"0, 1\n1, 0, 2\n",
+		"0, 1, 2\n1, 0, 2\n",
+		"0, fast\nslow, 0\n",
+	} {
+		if m, err := ReadLatencyMatrix(strings.NewReader(in)); err == nil {
+			t.Fatalf("expected an error reading %q, got %v", in, m.Latencies)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package scr
+
+import (
+	"fmt"
+)
+
+const (
+	defaultHandOffsPerTick = 10
+	defaultMaxLeaveTicks   = 10
+)
+
+var _ StateHandler = LeaveState{}
+
+// LeaveState gracefully takes a node offline: each tick it offers some of its
+// Data to the peers it believes closest to that Data, each piece to a peer
+// that has not refused it, and forgets those the peers acknowledge taking. It
+// departs once it holds no Data, or once it has run out of ticks, losing
+// whatever Data remains.
+type LeaveState struct {
+	// HandOffsPerTick caps the pieces of Data offered each tick.
+	HandOffsPerTick int
+	// MaxTicks is the number of ticks spent handing off before departing.
+	MaxTicks int
+	// Leave is the identifier of this state.
+	Leave int
+}
+
+func (LeaveState) Name() string {
+	return "leave"
+}
+
+func (l LeaveState) Apply(n *Node, c Coordinator) (int, string) {
+	n.leavingTicks++
+	offered := 0
+	remaining := 0
+	for _, d := range n.Store.List() {
+		remaining++
+		if offered >= l.HandOffsPerTick || n.offered[d] {
+			continue
+		}
+		// NODE INTERACTION: HAND OFF DATA
+		if o := n.handOffPeer(d); o != nil {
+			n.offerData(c, o, d, true)
+			offered++
+		}
+	}
+	if remaining == 0 || n.leavingTicks >= l.MaxTicks {
+		n.departed = true
+		return l.Leave, fmt.Sprintf("Node %s handed off %d data and departed with %d remaining", n, n.handedOff, remaining)
+	}
+	return l.Leave, fmt.Sprintf("Node %s offered %d data to hand off with %d remaining", n, offered, remaining)
+}
+
+// handOffPeer is the peer believed to be closest to the Data, of those that
+// have not refused it, or nil.
+func (n *Node) handOffPeer(d *Data) *Node {
+	refused := n.handOffRefused[d]
+	var closest *Node
+	minDist := 0.0
+	for _, p := range n.positions() {
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			if o == nil {
+				return
+			}
+			for _, r := range refused {
+				if r == o.physical() {
+					return
+				}
+			}
+			loc, ok := p.peers.PeerLocation(o)
+			if !ok {
+				return
+			}
+			if dist := loc.GreatCircleDistance(d.Location); closest == nil || dist < minDist {
+				closest = o
+				minDist = dist
+			}
+		})
+	}
+	return closest
+}
+
+// refuseHandOff records that the peer o did not take the Data handed off.
+func (n *Node) refuseHandOff(d *Data, o *Node) {
+	if n.handOffRefused == nil {
+		n.handOffRefused = make(map[*Data][]*Node)
+	}
+	n.handOffRefused[d] = append(n.handOffRefused[d], o)
+}
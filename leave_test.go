@@ -0,0 +1,72 @@
+package scr
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newLeave has a node about to leave, holding nData pieces of Data around the
+// north pole, and two waiting peers of it with room for nearSlots and
+// farSlots pieces: one near the pole and one farther away.
+func newLeave(t *testing.T, nData, nearSlots, farSlots int, l LeaveState) (s *Simulation, leaver, near, far *Node) {
+	s = newTestSimulation(3, 0, DiscardData)
+	s.LocationPushThreshold = -1
+	s.LocationPullInterval = 0
+	l.Leave = StateLeave
+	s.States.Replace(StateLeave, l)
+	leaver, near, far = s.NodeCache[0], s.NodeCache[1], s.NodeCache[2]
+	leaver.Store = NewMemoryStore(-1, -1)
+	near.Store = NewMemoryStore(nearSlots, -1)
+	far.Store = NewMemoryStore(farSlots, -1)
+	for i := 0; i < nData; i++ {
+		if !leaver.keep(testData(fmt.Sprintf("d%d", i), V{0.01 * float64(i), 0, 1})) {
+			t.Fatal("expected the store to take the Data")
+		}
+	}
+	near.Location = V{0.1, 0, 1}.Unit()
+	far.Location = V{1, 0, 0.2}.Unit()
+	for _, o := range []*Node{near, far} {
+		o.S = State{id: StateWait}
+		o.WaitActivity = 0
+		leaver.addPeerAt(o, o.Location)
+	}
+	s.nodeLeaves(leaver, true)
+	return
+}
+
+func TestLeaveHandsOffToClosestPeer(t *testing.T) {
+	s, leaver, near, far := newLeave(t, 5, 10, 10, LeaveState{HandOffsPerTick: 2, MaxTicks: 10})
+	runTicks(s, 1, 4)
+	if leaver.departed || leaver.handedOff == 0 || leaver.handedOff == 5 {
+		t.Fatalf("expected the Data handed off over several ticks, got %d handed off", leaver.handedOff)
+	}
+	runTicks(s, 4, 10)
+	if !leaver.departed || s.NodesDeparted != 1 {
+		t.Fatal("expected the node to depart once it handed off all of its Data")
+	}
+	if len(near.Store.List()) != 5 || len(far.Store.List()) != 0 {
+		t.Fatalf("expected every piece handed to the closest peer, got %d and %d", len(near.Store.List()), len(far.Store.List()))
+	}
+	if s.DataHandedOff != 5 || s.DataLost != 0 {
+		t.Fatalf("expected 5 handed off and none lost, got %d and %d", s.DataHandedOff, s.DataLost)
+	}
+}
+
+func TestLeaveHandsOffRefusedDataToNextPeer(t *testing.T) {
+	s, leaver, near, far := newLeave(t, 3, 1, 1, LeaveState{HandOffsPerTick: 3, MaxTicks: 6})
+	runTicks(s, 1, 10)
+	if !leaver.departed || s.NodesDeparted != 1 {
+		t.Fatal("expected the node to depart after running out of ticks")
+	}
+	nearHeld, farHeld := near.Store.List(), far.Store.List()
+	if len(nearHeld) != 1 || len(farHeld) != 1 {
+		t.Fatalf("expected each peer to take what it has room for, got %d and %d", len(nearHeld), len(farHeld))
+	}
+	refused := leaver.handOffRefused[farHeld[0]]
+	if len(refused) != 1 || refused[0] != near {
+		t.Fatalf("expected the Data the far peer took to have been refused by the near one, got %v", refused)
+	}
+	if s.DataHandedOff != 2 || s.DataLost != 1 {
+		t.Fatalf("expected 2 handed off and the rest lost, got %d and %d", s.DataHandedOff, s.DataLost)
+	}
+}
@@ -0,0 +1,82 @@
+package scr
+
+const (
+	defaultPeerTimeout     = 100
+	defaultMaxPeerFailures = 5
+)
+
+// peerLiveness is what a node knows about whether a peer is still online.
+type peerLiveness struct {
+	// The tick this node last heard from the peer.
+	lastSeen int
+	// Interactions with the peer that failed since it was last seen.
+	failures int
+}
+
+// peerSeen records a successful interaction with the peer.
+func (n *Node) peerSeen(o *Node) {
+	if n.liveness == nil {
+		n.liveness = make(map[*Node]*peerLiveness)
+	}
+	l, ok := n.liveness[o]
+	if !ok {
+		l = &peerLiveness{}
+		n.liveness[o] = l
+	}
+	l.lastSeen = n.now
+	l.failures = 0
+}
+
+// peerFailed records an interaction that the peer did not respond to.
+func (n *Node) peerFailed(o *Node) {
+	if l, ok := n.liveness[o]; ok {
+		l.failures++
+	}
+}
+
+// evictStalePeers forgets peers that have failed maxFailures interactions
+// since they were last seen, or that have failed and not been seen for more
+// than timeout ticks. A limit of 0 disables that rule. It returns the number
+// of peers forgotten.
+func (n *Node) evictStalePeers(now, timeout, maxFailures int) (evicted int) {
+	n.now = now
+	for _, p := range n.positions() {
+		p.now = now
+		var stale []*Node
+		live := make(map[*Node]*peerLiveness, len(p.liveness))
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			l, ok := p.liveness[o]
+			if !ok {
+				l = &peerLiveness{lastSeen: now}
+			}
+			if (maxFailures > 0 && l.failures >= maxFailures) ||
+				(timeout > 0 && l.failures > 0 && now-l.lastSeen > timeout) {
+				stale = append(stale, o)
+				return
+			}
+			live[o] = l
+		})
+		for _, o := range stale {
+			p.peers.RemovePeer(o)
+		}
+		p.liveness = live
+		evicted += len(stale)
+	}
+	return
+}
+
+// countStalePeers counts the peers known by this node that have departed, and
+// the sum of how many ticks ago they did.
+func (n *Node) countStalePeers(now int) (entries, stale, staleTicks int) {
+	n.iterateOverPeersWith(func(o *Node) {
+		if o == nil {
+			return
+		}
+		entries++
+		if o.departed {
+			stale++
+			staleTicks += now - o.departedTick
+		}
+	})
+	return
+}
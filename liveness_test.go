@@ -0,0 +1,69 @@
+package scr
+
+import (
+	"testing"
+)
+
+func TestNodeEvictStalePeers(t *testing.T) {
+	const now = 200
+	tests := []struct {
+		name string
+		// When the peer was last seen and the interactions it failed
+		// since, or no liveness if it was never seen.
+		unseen   bool
+		lastSeen int
+		failures int
+		// The timeout and most failures evicted on.
+		timeout     int
+		maxFailures int
+		expectEvict bool
+	}{
+		{"recently seen", false, now - 10, 0, 100, 5, false},
+		{"long seen without failing", false, now - 150, 0, 100, 5, false},
+		{"failing but recently seen", false, now - 10, 2, 100, 5, false},
+		{"failing and not seen for timeout", false, now - 150, 1, 100, 5, true},
+		{"failing at timeout", false, now - 100, 1, 100, 5, false},
+		{"max failures", false, now - 10, 5, 100, 5, true},
+		{"max failures disabled", false, now - 10, 5, 100, 0, false},
+		{"timeout disabled", false, now - 150, 1, 0, 5, false},
+		{"never seen", true, 0, 0, 100, 5, false},
+	}
+	for _, test := range tests {
+		n := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, newBasePeerList(8))
+		o := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, newBasePeerList(8))
+		n.peers.AddPeer(n.Location, o)
+		if !test.unseen {
+			n.now = test.lastSeen
+			n.peerSeen(o)
+			for i := 0; i < test.failures; i++ {
+				n.peerFailed(o)
+			}
+		}
+		evicted := n.evictStalePeers(now, test.timeout, test.maxFailures)
+		_, known := n.peers.PeerLocation(o)
+		if (evicted == 1) != test.expectEvict || known == test.expectEvict {
+			t.Fatalf("%s: expected evicted %v, got %d evicted and still known %v", test.name, test.expectEvict, evicted, known)
+		}
+		if _, tracked := n.liveness[o]; tracked == test.expectEvict {
+			t.Fatalf("%s: expected the liveness of evicted peers forgotten, got tracked %v", test.name, tracked)
+		}
+	}
+}
+
+func TestNodeCountStalePeers(t *testing.T) {
+	n := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, newBasePeerList(8))
+	var peers []*Node
+	for i := 0; i < 4; i++ {
+		o := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, newBasePeerList(8))
+		n.peers.AddPeer(n.Location, o)
+		peers = append(peers, o)
+	}
+	peers[1].departed = true
+	peers[1].departedTick = 90
+	peers[3].departed = true
+	peers[3].departedTick = 70
+	entries, stale, staleTicks := n.countStalePeers(100)
+	if entries != 4 || stale != 2 || staleTicks != 40 {
+		t.Fatalf("expected 4 entries, 2 stale for 40 ticks, got %d, %d and %d", entries, stale, staleTicks)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/andlabs/ui"
@@ -21,12 +22,48 @@ var nInitialNodes = flag.Int("n_init_nodes", 100, "Initial number of nodes to si
 var nMaxData = flag.Int("n_max_data", 10000000, "Maximum number of pieces of data to simulate")
 var nMaxNodes = flag.Int("n_max_nodes", 1000, "Maximum number of nodes to simulate")
 var vizOnly = flag.Bool("viz", false, "Only displays the UI when enabled")
+var solverMaxIter = flag.Int("solver_max_iter", 0, "Most iterations a node may spend computing its location, 0 for the default of 1000")
+var mcWorkers = flag.Int("mc_workers", 1, "Goroutines each node's location solve runs its Monte Carlo restarts across")
+var seed = flag.Int64("seed", 0, "Master seed each node's location solves are seeded from, 0 for unseeded sources")
+var nVirtual = flag.Int("n_virtual", 1, "Number of virtual positions each node presents, splitting its data among them")
+var solverTimeout = flag.Duration("solver_timeout", 0, "Most time a node may spend computing its location, 0 for no budget")
+var peerTimeout = flag.Int("peer_timeout", 100, "Ticks a node waits to hear from a failing peer before evicting it, 0 to disable")
+var peerMaxFailures = flag.Int("peer_max_failures", 5, "Failed interactions after which a node evicts a peer, 0 to disable")
+var locationPushThreshold = flag.Float64("location_push_threshold", 0.05, "Radians a node moves before announcing its location to peers, negative to disable")
+var locationPullInterval = flag.Int("location_pull_interval", 50, "Ticks between a node requesting its peers' locations, 0 to disable")
+var storeDir = flag.String("store_dir", "", "Empty directory under which each node stores its data on disk, empty to store it in memory")
+var dataBytes = flag.String("data_bytes", "discard", "What is kept of the bytes of created data: discard, retain, or regenerate from a seed")
+var challengeInterval = flag.Int("challenge_interval", 0, "Ticks between a node challenging a peer to prove it stores data given to it, 0 to disable; needs data_bytes")
+var challengeRange = flag.Int("challenge_range", 32, "Most bytes of data a storage proof covers")
+var challengeMaxFailures = flag.Int("challenge_max_failures", 2, "Failed storage challenges after which a peer failing more than it passed is distrusted")
+var verifyInterval = flag.Int("verify_interval", 0, "Ticks between a node verifying the location a peer claims against a sample of its data, 0 to disable")
+var verifySample = flag.Int("verify_sample", 8, "Claimed data a verifying node asks a peer for")
+var verifyTolerance = flag.Float64("verify_tolerance", 0.25, "Mean radians the claimed location may be farther from the sampled data than the recomputed one")
+var serverFraction = flag.Float64("server_fraction", 0, "Fraction of nodes that are always-on servers rather than flaky clients, 0 for a single class of node")
+var clientSession = flag.Float64("client_session", 2000, "Mean number of iterations a client node stays online, when server_fraction is set")
+var nodeKeys = flag.Bool("node_keys", false, "Derive node IDs from ed25519 key pairs instead of numbering nodes")
+var bootstrapSeeds = flag.Int("bootstrap_seeds", 0, "Number of seed nodes joining nodes bootstrap from, 0 to bootstrap from any node")
+var seedAvailability = flag.Float64("seed_availability", 1, "Chance an online seed node answers a joining node")
+var joinAttempts = flag.Int("join_attempts", 10, "Times a node introduces itself to a bootstrap node before giving up joining, 0 to try until it succeeds")
+var latency = flag.Int("latency", 1, "Ticks a message takes between nodes")
+var dropChance = flag.Float64("drop_chance", 0, "Chance a message between nodes is lost")
+var reorderChance = flag.Float64("reorder_chance", 0, "Chance a message between nodes is held back, arriving after later ones")
+var requestTimeout = flag.Int("request_timeout", 4, "Ticks a node waits for a response before counting a request as failed")
+var networkSeed = flag.Int64("network_seed", 0, "Seed of which messages between nodes are dropped and reordered, 0 for an unseeded source")
+var latencyMatrix = flag.String("latency_matrix", "", "File of a square matrix of latencies in milliseconds between hosts nodes are placed on, instead of a synthetic geography")
+var regions = flag.Int("regions", 8, "Number of regions nodes cluster around in the synthetic geography")
+var msPerTick = flag.Float64("ms_per_tick", 0, "Milliseconds of latency a message takes per tick between nodes' hosts, 0 to use latency instead")
+var uploadCap = flag.Int("upload_cap", 0, "Bytes of data a node may upload each tick, 0 for no limit")
+var downloadCap = flag.Int("download_cap", 0, "Bytes of data a node may download each tick, 0 for no limit")
+var sybilNodes = flag.Int("sybil_nodes", 0, fmt.Sprintf("Number of colluding attackers injected around a target at iteration %d, 0 for none", relaxedIter))
+var sybilRadius = flag.Float64("sybil_radius", 0.1, "Radians from the target within which attackers are placed")
+var gracefulLeave = flag.Bool("graceful_leave", false, "Leaving nodes hand their data off to peers before going offline, instead of losing it")
 
 var expNodeJoin = flag.Bool("exp_node_join", false, fmt.Sprintf("Run experiment with a node joining at iteration %d", relaxedIter))
 var expNodeLeave = flag.Bool("exp_node_leave", false, fmt.Sprintf("Run experiment with a node leaving at iteration %d", relaxedIter))
 var expGenerateDataAfterRelax = flag.Bool("exp_gen_data_after_relax", false, fmt.Sprintf("Run experiment with nodes generating new data after iteration %d @ 1%%", relaxedIter))
 var expGenerateDataAfterRelax2 = flag.Bool("exp_gen_data_after_relax_2", false, fmt.Sprintf("Run experiment with nodes generating new data after iteration %d @ 2%%", relaxedIter))
-var expProd = flag.Bool("exp_prod", false, fmt.Sprintf("Run experiment with nodes joining 5% of the time and data growth beginning at iteration %d, nodes can leave beginning 2500 iterations later", relaxedIter))
+var expProd = flag.Bool("exp_prod", false, fmt.Sprintf("Run experiment with nodes joining 5%% of the time and data growth beginning at iteration %d, nodes can leave beginning 2500 iterations later", relaxedIter))
 
 var peerClosest = flag.Bool("peer_closest", false, "Enable closest-peer network")
 var peerMaxThenClosest = flag.Bool("peer_max_spread_then_closest", false, "Enable closest-peer after max-spread-peer network")
@@ -116,50 +153,204 @@ func CheckFlags() (s *scr.Simulation) {
 	} else if np > 1 {
 		panic("too many peer_* flags chosen")
 	}
-	s = scr.NewSimulation(*nInitialNodes,
-		*nMaxData,
-		*nMaxNodes,
-		t,
-		/* # of initial pieces of Data for a Node */
-		cappedUncertainNormalDistFactoryFn(
-			/*Std Dev's Mean & Std Dev*/
-			2, 2,
-			/*Mean's Mean & Std Dev*/
-			100, 2,
-		),
-		/* # of maximum Data pieces of Data a Node can have */
-		cappedUncertainNormalDistFactoryFn(
-			/*Std Dev's Mean & Std Dev*/
-			10, 10,
-			/*Mean's Mean & Std Dev*/
-			2000, 10,
-		),
-		/* # of bytes in a piece of Data (Datashards is always 32kb) */
-		uncertainNormalDistByteFactoryFn(
-			/*Std Dev's Mean & Std Dev*/
-			0, 0,
-			/*Mean's Mean & Std Dev*/
-			32000, 0,
-		),
-		/* # of maximum bytes a Node can have */
-		addedUncertainNormalDistFactoryFn(
-			/*Std Dev's Mean & Std Dev*/
-			1000000, 1000000,
-			/*Mean's Mean & Std Dev*/
-			1000000000, 1000000,
-		),
-		/* % of time the node waits (does nothing) */
-		uncertainNormalDistFloatFactoryFn(
-			/*Std Dev's Mean & Std Dev*/
-			0.03, 0.01,
-			/*Mean's Mean & Std Dev*/
-			0.5, 0.01,
-		),
-		/* % chance nodes will grow data (if experiment enabled) */
-		dataGrowth,
-		/* Peer list factory function */
-		peerListFactoryFn,
-		*vizOnly)
+	if *sybilNodes > 0 {
+		t = append(t, &scr.SybilAttack{
+			At:     relaxedIter,
+			N:      *sybilNodes,
+			Target: scr.RandomVector(),
+			Radius: *sybilRadius,
+		})
+	}
+	/* # of initial pieces of Data for a Node */
+	nodeInitialData := cappedUncertainNormalDistFactoryFn(
+		/*Std Dev's Mean & Std Dev*/
+		2, 2,
+		/*Mean's Mean & Std Dev*/
+		100, 2,
+	)
+	/* # of maximum Data pieces of Data a Node can have */
+	allocateNData := cappedUncertainNormalDistFactoryFn(
+		/*Std Dev's Mean & Std Dev*/
+		10, 10,
+		/*Mean's Mean & Std Dev*/
+		2000, 10,
+	)
+	/* # of bytes in a piece of Data (Datashards is always 32kb) */
+	createData := uncertainNormalDistByteFactoryFn(
+		/*Std Dev's Mean & Std Dev*/
+		0, 0,
+		/*Mean's Mean & Std Dev*/
+		32000, 0,
+	)
+	/* # of maximum bytes a Node can have */
+	nodeMaxBSize := addedUncertainNormalDistFactoryFn(
+		/*Std Dev's Mean & Std Dev*/
+		1000000, 1000000,
+		/*Mean's Mean & Std Dev*/
+		1000000000, 1000000,
+	)
+	/* % of time the node waits (does nothing) */
+	waitActivity := uncertainNormalDistFloatFactoryFn(
+		/*Std Dev's Mean & Std Dev*/
+		0.03, 0.01,
+		/*Mean's Mean & Std Dev*/
+		0.5, 0.01,
+	)
+	/* Budget for each node computing its location */
+	budget := scr.Budget{
+		MaxIterations: *solverMaxIter,
+		MaxDuration:   *solverTimeout,
+	}
+	var dataRetention scr.DataRetention
+	switch *dataBytes {
+	case "discard":
+		dataRetention = scr.DiscardData
+	case "retain":
+		dataRetention = scr.RetainData
+	case "regenerate":
+		dataRetention = scr.RegenerateData
+	default:
+		panic("unknown data_bytes: " + *dataBytes)
+	}
+	if *serverFraction > 0 {
+		classes := []*scr.NodeClass{
+			{
+				Name:                         "server",
+				Weight:                       *serverFraction,
+				NodeInitialDataFactoryFn:     nodeInitialData,
+				AllocateNDataToNodeFactoryFn: allocateNData,
+				/* Servers have ten times the bytes */
+				NodeMaxBSizeFactoryFn: addedUncertainNormalDistFactoryFn(
+					/*Std Dev's Mean & Std Dev*/
+					10000000, 10000000,
+					/*Mean's Mean & Std Dev*/
+					10000000000, 10000000,
+				),
+				WaitActivityFactoryFn: waitActivity,
+				PeerListFactoryFn: func() func() scr.PeerList {
+					return func() scr.PeerList {
+						return scr.NewMaxSpreadThenClosestNeighbors(nMaxPeerSpreadDefault-nThenAfterClosest, nThenAfterClosest)
+					}
+				},
+				JoinChance:      0.001,
+				LeaveGracefully: true,
+			},
+			{
+				Name:                         "client",
+				Weight:                       1 - *serverFraction,
+				NodeInitialDataFactoryFn:     nodeInitialData,
+				AllocateNDataToNodeFactoryFn: allocateNData,
+				NodeMaxBSizeFactoryFn:        nodeMaxBSize,
+				/* Clients are less often active */
+				WaitActivityFactoryFn: uncertainNormalDistFloatFactoryFn(
+					/*Std Dev's Mean & Std Dev*/
+					0.03, 0.01,
+					/*Mean's Mean & Std Dev*/
+					0.2, 0.01,
+				),
+				PeerListFactoryFn:      peerListFactoryFn,
+				SessionLengthFactoryFn: exponentialDistIntFactoryFn(*clientSession),
+				JoinChance:             0.02,
+			},
+		}
+		t = append(t, &scr.ClassChurn{After: relaxedIter})
+		s = scr.NewSimulationWithClasses(*nInitialNodes,
+			*nMaxData,
+			*nMaxNodes,
+			t,
+			createData,
+			/* % chance nodes will grow data (if experiment enabled) */
+			dataGrowth,
+			classes,
+			budget,
+			/* # of virtual positions per node */
+			*nVirtual,
+			*nodeKeys,
+			dataRetention,
+			*vizOnly)
+	} else {
+		s = scr.NewSimulation(*nInitialNodes,
+			*nMaxData,
+			*nMaxNodes,
+			t,
+			nodeInitialData,
+			allocateNData,
+			createData,
+			nodeMaxBSize,
+			waitActivity,
+			/* % chance nodes will grow data (if experiment enabled) */
+			dataGrowth,
+			/* Peer list factory function */
+			peerListFactoryFn,
+			budget,
+			/* # of virtual positions per node */
+			*nVirtual,
+			*nodeKeys,
+			dataRetention,
+			*vizOnly)
+	}
+	s.SetSolver(*mcWorkers, *seed)
+	s.PeerTimeout = *peerTimeout
+	s.MaxPeerFailures = *peerMaxFailures
+	s.LocationPushThreshold = *locationPushThreshold
+	s.LocationPullInterval = *locationPullInterval
+	if *storeDir != "" {
+		s.SetStoreFactory(scr.DiskStoreFactory(*storeDir))
+	}
+	if *challengeInterval > 0 {
+		if s.DataRetention == scr.DiscardData {
+			panic("challenge_interval needs data_bytes retain or regenerate")
+		}
+		s.Challenges = &scr.Challenges{
+			Interval:    *challengeInterval,
+			RangeLength: *challengeRange,
+			MaxFailures: *challengeMaxFailures,
+		}
+	}
+	if *verifyInterval > 0 {
+		if *verifySample < 1 {
+			panic("verify_sample must be at least 1")
+		}
+		s.Verification = &scr.Verification{
+			Interval:  *verifyInterval,
+			Sample:    *verifySample,
+			Tolerance: *verifyTolerance,
+		}
+	}
+	if *bootstrapSeeds > 0 {
+		s.Bootstrap = &scr.SeedBootstrap{
+			NSeeds:       *bootstrapSeeds,
+			Availability: *seedAvailability,
+		}
+	}
+	s.States.Replace(scr.StateJoin, scr.JoinState{
+		MaxAttempts: *joinAttempts,
+		Join:        scr.StateJoin,
+		Wait:        scr.StateWait,
+	})
+	if *latencyMatrix != "" {
+		f, err := os.Open(*latencyMatrix)
+		if err != nil {
+			panic(err)
+		}
+		m, err := scr.ReadLatencyMatrix(f)
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+		s.SetLatencySpace(m)
+	} else {
+		s.SetLatencySpace(scr.NewSyntheticGeography(*regions, 0.05))
+	}
+	linkLatency := scr.ConstantLatency(*latency)
+	if *msPerTick > 0 {
+		linkLatency = scr.SpaceLatency(s.Space, *msPerTick)
+	}
+	s.Network = scr.NewNetwork(linkLatency, *dropChance, *reorderChance, *requestTimeout)
+	if *networkSeed != 0 {
+		s.Network.Rand = rand.New(rand.NewSource(*networkSeed))
+	}
+	s.SetBandwidthCaps(*uploadCap, *downloadCap)
 	return
 }
 
@@ -190,11 +381,13 @@ func setup(s *scr.Simulation) func() {
 		vizLabel := ui.NewLabel("viz draw:")
 		vizLabelDur := ui.NewLabel("?")
 		vizLabelDurLockless := ui.NewLabel("?")
+		vizLabelNodes := ui.NewLabel("")
 		visual := &viz{
 			s:                   s,
 			vizMode:             vizModeTwoNodes,
 			vizLabelDur:         vizLabelDur,
 			vizLabelDurLockless: vizLabelDurLockless,
+			vizLabelNodes:       vizLabelNodes,
 			enableLink:          true,
 			enableNode:          true,
 			enableData:          true,
@@ -236,6 +429,7 @@ func setup(s *scr.Simulation) func() {
 		grid.Append(vizLabel, 0, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
 		grid.Append(vizLabelDur, 1, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
 		grid.Append(vizLabelDurLockless, 2, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(vizLabelNodes, 0, 4, 5, 1, false, ui.AlignFill, false, ui.AlignFill)
 		vbox.Append(grid, false)
 
 		// Viz Mode Buttons
@@ -346,6 +540,16 @@ func uncertainNormalDistFloatFactoryFn(
 	}
 }
 
+// exponentialDistIntFactoryFn gives exponentially distributed integers, such
+// as the lengths of sessions that end with a constant chance.
+func exponentialDistIntFactoryFn(mean float64) func() func() int {
+	return func() func() int {
+		return func() int {
+			return int(math.Ceil(rand.ExpFloat64() * mean))
+		}
+	}
+}
+
 // UI Helpers
 
 func vizModeButton(vm string, v *viz, l *ui.Label, proj *ui.Area) *ui.Button {
@@ -360,6 +564,14 @@ func vizModeButton(vm string, v *viz, l *ui.Label, proj *ui.Area) *ui.Button {
 
 // scr.Tockers
 
+func existingNodeLeaves(s *scr.Simulation) {
+	if *gracefulLeave {
+		s.ExistingNodeLeavesGracefully()
+	} else {
+		s.ExistingNodeLeaves()
+	}
+}
+
 var _ scr.Tocker = &join15k{}
 
 type join15k struct{}
@@ -376,7 +588,7 @@ type leave15k struct{}
 
 func (*leave15k) Tock(s *scr.Simulation, i int) {
 	if i == relaxedIter {
-		s.ExistingNodeLeaves()
+		existingNodeLeaves(s)
 	}
 }
 
@@ -401,7 +613,7 @@ func (*prod) Tock(s *scr.Simulation, i int) {
 		if ch < 2 {
 			r := rand.Intn(2)
 			if r == 0 && i > relaxedIter+1000 {
-				s.ExistingNodeLeaves()
+				existingNodeLeaves(s)
 			} else {
 				s.NewNodeJoins()
 			}
@@ -50,11 +50,13 @@ type viz struct {
 	vizMode             string
 	vizLabelDur         *ui.Label
 	vizLabelDurLockless *ui.Label
-	enableLink          bool
-	enableNode          bool
-	enableData          bool
-	enableInnerCircle   bool
-	enableOuterCircle   bool
+	// vizLabelNodes identifies the highlighted nodes.
+	vizLabelNodes     *ui.Label
+	enableLink        bool
+	enableNode        bool
+	enableData        bool
+	enableInnerCircle bool
+	enableOuterCircle bool
 }
 
 func (v *viz) Draw(a *ui.Area, p *ui.AreaDrawParams) {
@@ -142,15 +144,16 @@ func (v *viz) Draw(a *ui.Area, p *ui.AreaDrawParams) {
 			}
 			if i < nData {
 				dataDiv = len(dataToDraw)
-				for _, di := range n.DataIndices {
-					if v.s.DataCache[di] == nil {
-						continue
-					}
-					dataToDraw = append(dataToDraw, v.s.DataCache[di])
-				}
+				dataToDraw = append(dataToDraw, n.Store.List()...)
 			}
 		}
 
+		nodeIDs := ""
+		if len(nodesToDraw) > 1 {
+			nodeIDs = fmt.Sprintf("green: node %s, yellow: node %s", nodesToDraw[0].ID, nodesToDraw[1].ID)
+		}
+		v.vizLabelNodes.SetText(nodeIDs)
+
 		if v.enableLink {
 			lineBrush := NewSolidBrush(colorBlue, 1.0)
 			path = ui.DrawNewPath(ui.DrawFillModeWinding)
@@ -272,10 +275,7 @@ func (v *viz) Draw(a *ui.Area, p *ui.AreaDrawParams) {
 		nodeBrush := NewSolidBrush(colorYellow, 1.0)
 
 		path = ui.DrawNewPath(ui.DrawFillModeWinding)
-		for _, d := range v.s.DataCache {
-			if d == nil {
-				continue
-			}
+		for _, d := range v.s.HeldData() {
 			xProj, yProj := d.Location.ProjectGSD()
 			x := adjustXFn(xProj)
 			y := adjustYFn(yProj)
@@ -1,18 +1,108 @@
 package scr
 
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+const (
+	monteCarloSamples = 1000000
+	// monteCarloChunkSize is the number of samples drawn from one seeded
+	// source. Work is divided into chunks rather than among workers, so the
+	// samples drawn never depend on the number of workers.
+	monteCarloChunkSize = 10000
+)
+
 func MonteCarloMinimizer(
 	existingLocations []V, // Existing locations on a unit sphere
 	existingLocationWeights []float64) V {
-	v := RandomVector()
-	min, _ := geodesicDistances(v, existingLocations, existingLocationWeights)
-	minV := v
-	for i := 0; i < 1000000; i++ {
-		v = RandomVector()
-		d, _ := geodesicDistances(v, existingLocations, existingLocationWeights)
-		if d < min {
-			min = d
-			minV = v
+	return MonteCarloMinimizerParallel(
+		existingLocations,
+		existingLocationWeights,
+		monteCarloSamples,
+		runtime.NumCPU(),
+		rand.Int63())
+}
+
+// MonteCarloMinimizerParallel samples nSamples random points on the unit
+// sphere across nWorkers goroutines, and returns the one with the smallest
+// weighted great circle distance to the existing locations.
+//
+// The result is identical for a given seed regardless of nWorkers.
+func MonteCarloMinimizerParallel(
+	existingLocations []V, // Existing locations on a unit sphere
+	existingLocationWeights []float64,
+	nSamples int,
+	nWorkers int,
+	seed int64) V {
+	if nSamples < 1 {
+		nSamples = 1
+	}
+	nChunks := (nSamples + monteCarloChunkSize - 1) / monteCarloChunkSize
+	minVs := make([]V, nChunks)
+	mins := make([]float64, nChunks)
+	forEachIndexParallel(nChunks, nWorkers, func(c int) {
+		r := rand.New(rand.NewSource(deriveSeed(seed, c)))
+		n := nSamples - c*monteCarloChunkSize
+		if n > monteCarloChunkSize {
+			n = monteCarloChunkSize
+		}
+		for i := 0; i < n; i++ {
+			v := RandomVectorFrom(r)
+			d, _ := geodesicDistances(v, existingLocations, existingLocationWeights)
+			if i == 0 || d < mins[c] {
+				mins[c] = d
+				minVs[c] = v
+			}
+		}
+	})
+	// Reduce in chunk order so ties resolve the same way every time.
+	min := mins[0]
+	minV := minVs[0]
+	for c := 1; c < nChunks; c++ {
+		if mins[c] < min {
+			min = mins[c]
+			minV = minVs[c]
 		}
 	}
 	return minV
 }
+
+// forEachIndexParallel calls f once for every index in [0, n) using up to
+// nWorkers goroutines, returning once all calls are done.
+//
+// f must only write to state owned by its index.
+func forEachIndexParallel(n, nWorkers int, f func(i int)) {
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+	if nWorkers > n {
+		nWorkers = n
+	}
+	idxCh := make(chan int)
+	wg := &sync.WaitGroup{}
+	wg.Add(nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idxCh {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+}
+
+// deriveSeed deterministically derives the seed of the i'th independent
+// stream of random numbers from a master seed, using the SplitMix64 mixer.
+func deriveSeed(master int64, i int) int64 {
+	z := uint64(master) + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
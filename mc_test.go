@@ -1,6 +1,7 @@
 package scr
 
 import (
+	"context"
 	"testing"
 )
 
@@ -17,3 +18,72 @@ func TestMonteCarlo(t *testing.T) {
 	v := MonteCarloMinimizer(existing, weights)
 	t.Log(v)
 }
+
+func TestMonteCarloParallelIsDeterministic(t *testing.T) {
+	existing := []V{
+		V{0, 0, -1},
+		V{0, -1, 0},
+		V{1, 0, 0},
+	}
+	weights := []float64{1, 2, 3}
+	expected := MonteCarloMinimizerParallel(existing, weights, 50000, 1, 42)
+	for _, nWorkers := range []int{2, 3, 8} {
+		actual := MonteCarloMinimizerParallel(existing, weights, 50000, nWorkers, 42)
+		if !actual.Equals(expected) {
+			t.Fatalf("expected %v, got %v for nWorkers=%d", expected, actual, nWorkers)
+		}
+	}
+}
+
+func TestNEMFLMonteCarloParallelIsDeterministic(t *testing.T) {
+	existing := []V{
+		V{0, 0.6, 0.8},
+		V{0.6, 0, 0.8},
+		V{0, -0.6, 0.8},
+		V{-0.6, 0, 0.8},
+	}
+	weights := []float64{1, 2, 1, 2}
+	expected, _, _, _, err := SolveNonEuclideanMultifacilityLocationMonteCarloParallel(context.Background(), existing, weights, 0.0001, 0.0001, 8, 1, 7, Budget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nWorkers := range []int{2, 4, 16} {
+		actual, _, _, _, err := SolveNonEuclideanMultifacilityLocationMonteCarloParallel(context.Background(), existing, weights, 0.0001, 0.0001, 8, nWorkers, 7, Budget{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !actual.Equals(expected) {
+			t.Fatalf("expected %v, got %v for nWorkers=%d", expected, actual, nWorkers)
+		}
+	}
+}
+
+func TestNEMFLMonteCarloParallelTraceTagsRestarts(t *testing.T) {
+	existing := []V{
+		V{0, 0.6, 0.8},
+		V{0.6, 0, 0.8},
+		V{0, -0.6, 0.8},
+		V{-0.6, 0, 0.8},
+	}
+	weights := []float64{1, 2, 1, 2}
+	const nMC = 8
+	lastK := make(map[int]int)
+	_, _, _, _, err := SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(context.Background(), existing, weights, 0.0001, 0.0001, nMC, 4, 7, Budget{}, func(it Iterate) {
+		if !it.Smooth {
+			if it.Restart != 0 {
+				t.Errorf("expected the nonsmooth solver in restart 0, got %d", it.Restart)
+			}
+			return
+		}
+		if it.K <= lastK[it.Restart] {
+			t.Errorf("expected restart %d to iterate in order, got k=%d after k=%d", it.Restart, it.K, lastK[it.Restart])
+		}
+		lastK[it.Restart] = it.K
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lastK) != nMC {
+		t.Fatalf("expected iterates of %d restarts, got %v", nMC, lastK)
+	}
+}
@@ -0,0 +1,328 @@
+package scr
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	defaultLatency        = 1
+	defaultRequestTimeout = 4
+)
+
+// MessageKind is what a Message asks or answers.
+type MessageKind int
+
+const (
+	// MsgHello introduces the sender, which the receiver adds as a peer. It
+	// is answered by MsgHelloAck.
+	MsgHello MessageKind = iota
+	MsgHelloAck
+	// MsgPeerRequest asks for one of the receiver's peers. It is answered by
+	// MsgPeerResponse.
+	MsgPeerRequest
+	MsgPeerResponse
+	// MsgData offers the receiver Data that is closer to it. It is answered
+	// by MsgDataAck.
+	MsgData
+	MsgDataAck
+	// MsgLocation tells a peer that the sender has moved. It is not
+	// answered.
+	MsgLocation
+	// MsgLocationRequest asks for the receiver's location. It is answered by
+	// MsgLocationResponse.
+	MsgLocationRequest
+	MsgLocationResponse
+	// MsgClaimRequest asks for the addresses of the Data the receiver holds.
+	// It is answered by MsgClaimResponse.
+	MsgClaimRequest
+	MsgClaimResponse
+	// MsgSampleRequest asks for some of the Data the receiver claimed. It is
+	// answered by MsgSampleResponse.
+	MsgSampleRequest
+	MsgSampleResponse
+	// MsgChallenge asks for a proof that the receiver stores Data given to
+	// it. It is answered by MsgChallengeResponse.
+	MsgChallenge
+	MsgChallengeResponse
+	// MsgFetch asks for the content of Data by address, on behalf of a
+	// client rather than the state machine. It is answered by
+	// MsgFetchResponse, with the Data or a peer closer to it.
+	MsgFetch
+	MsgFetchResponse
+)
+
+func (k MessageKind) String() string {
+	switch k {
+	case MsgHello:
+		return "hello"
+	case MsgHelloAck:
+		return "helloAck"
+	case MsgPeerRequest:
+		return "peerRequest"
+	case MsgPeerResponse:
+		return "peerResponse"
+	case MsgData:
+		return "data"
+	case MsgDataAck:
+		return "dataAck"
+	case MsgLocation:
+		return "location"
+	case MsgLocationRequest:
+		return "locationRequest"
+	case MsgLocationResponse:
+		return "locationResponse"
+	case MsgClaimRequest:
+		return "claimRequest"
+	case MsgClaimResponse:
+		return "claimResponse"
+	case MsgSampleRequest:
+		return "sampleRequest"
+	case MsgSampleResponse:
+		return "sampleResponse"
+	case MsgChallenge:
+		return "challenge"
+	case MsgChallengeResponse:
+		return "challengeResponse"
+	case MsgFetch:
+		return "fetch"
+	case MsgFetchResponse:
+		return "fetchResponse"
+	}
+	return fmt.Sprintf("MessageKind(%d)", int(k))
+}
+
+// isRequest is whether a message of this kind is answered.
+func (k MessageKind) isRequest() bool {
+	switch k {
+	case MsgHello, MsgPeerRequest, MsgData, MsgLocationRequest, MsgClaimRequest, MsgSampleRequest, MsgChallenge, MsgFetch:
+		return true
+	}
+	return false
+}
+
+// Message is sent from one node position to another over the Network.
+type Message struct {
+	Kind MessageKind
+	// From and To are the sending and receiving positions.
+	From *Node
+	To   *Node
+	// Seq identifies a request, and is repeated in its response.
+	Seq int
+	// Location is where the sender was when it sent the message.
+	Location V
+	// Data is offered by MsgData, and acknowledged by MsgDataAck.
+	Data *Data
+	// Swap is the Data given back in return by MsgDataAck, if any.
+	Swap *Data
+	// The bytes stored by the sender of MsgData, and the most it may store
+	// or negative for no limit, for the receiver to decide on a swap.
+	BSize    int
+	MaxBSize int
+	// HandOff is set on MsgData, and its MsgDataAck, from a leaving node.
+	// Nothing is swapped in return.
+	HandOff bool
+	// Accepted is whether the Data of MsgData is now held by the receiver.
+	Accepted bool
+	// Peer answers MsgPeerRequest, with where the responder believes it is.
+	Peer         *Node
+	PeerLocation V
+	// Addresses answer MsgClaimRequest, and are asked for by
+	// MsgSampleRequest.
+	Addresses []Address
+	// Sample is the Data asked for by MsgSampleRequest that the responder
+	// holds. It is a copy, and stays held by the responder.
+	Sample []*Data
+	// MsgChallenge asks for the proof of the Data at Address over Length
+	// bytes from Offset, salted by Nonce. Proof answers it, and is nil if
+	// the responder no longer holds the Data, in which case Peer names the
+	// node it gave the Data to, if it can.
+	Address Address
+	Nonce   []byte
+	Offset  int
+	Length  int
+	Proof   []byte
+
+	sentTick    int
+	deliverTick int
+	// The proof the sender of MsgChallenge expects.
+	expected []byte
+	// Bytes of the payload still to be uploaded.
+	remaining int
+}
+
+// payload is the number of bytes of Data the message carries.
+func (m *Message) payload() int {
+	switch {
+	case m.Kind == MsgData:
+		return m.Data.DataSize
+	case m.Kind == MsgDataAck && m.Swap != nil:
+		return m.Swap.DataSize
+	case m.Kind == MsgSampleResponse:
+		size := 0
+		for _, d := range m.Sample {
+			size += d.DataSize
+		}
+		return size
+	}
+	return 0
+}
+
+// reply is the response to the request m.
+func (m *Message) reply(kind MessageKind) *Message {
+	return &Message{
+		Kind:     kind,
+		From:     m.To,
+		To:       m.From,
+		Seq:      m.Seq,
+		Location: m.To.Location,
+	}
+}
+
+func (m *Message) String() string {
+	return fmt.Sprintf("%s #%d from %s to %s", m.Kind, m.Seq, m.From, m.To)
+}
+
+// LatencyFn gives the ticks a message takes from one node position to
+// another.
+type LatencyFn func(from, to *Node) int
+
+// ConstantLatency has every message take the same number of ticks.
+func ConstantLatency(ticks int) LatencyFn {
+	return func(from, to *Node) int {
+		return ticks
+	}
+}
+
+// Network carries messages between nodes. A message sent during a tick is
+// delivered at the start of a later one, once its payload has been
+// transferred within the bandwidth of both nodes.
+type Network struct {
+	// Latency gives the ticks a message takes over a link, at least 1.
+	Latency LatencyFn
+	// DropChance is the chance a message is lost.
+	DropChance float64
+	// ReorderChance is the chance a message is held back for up to its
+	// latency again, arriving after messages sent after it.
+	ReorderChance float64
+	// RequestTimeout is the ticks a node waits for a response before
+	// counting the request as failed.
+	RequestTimeout int
+	// Rand decides drops and reordering, so that they can be repeated, or
+	// the global source does if it is nil.
+	Rand *rand.Rand
+
+	seq       int
+	inTransit []*Message
+	// Messages whose payload is still being transferred, in the order
+	// they were sent.
+	transferring []*Message
+	// The number of messages in transit carrying each piece of Data.
+	carrying map[*Data]int
+}
+
+func NewNetwork(latency LatencyFn, dropChance, reorderChance float64, requestTimeout int) *Network {
+	return &Network{
+		Latency:        latency,
+		DropChance:     dropChance,
+		ReorderChance:  reorderChance,
+		RequestTimeout: requestTimeout,
+		carrying:       make(map[*Data]int),
+	}
+}
+
+// send numbers the message if it is a request, and returns false if it is
+// dropped.
+func (w *Network) send(m *Message, now int) bool {
+	if m.Kind.isRequest() && m.Seq == 0 {
+		w.seq++
+		m.Seq = w.seq
+	}
+	m.sentTick = now
+	if w.float64() < w.DropChance {
+		return false
+	}
+	w.carry(m, 1)
+	m.remaining = m.payload()
+	if transfer(m) {
+		w.schedule(m, now)
+	} else {
+		w.transferring = append(w.transferring, m)
+	}
+	return true
+}
+
+// schedule delivers the message, now that it has been transferred, after
+// the latency of the link.
+func (w *Network) schedule(m *Message, now int) {
+	m.sentTick = now
+	latency := w.Latency(m.From, m.To)
+	if latency < 1 {
+		latency = 1
+	}
+	m.deliverTick = now + latency
+	if w.float64() < w.ReorderChance {
+		m.deliverTick += 1 + w.intn(latency)
+	}
+	w.inTransit = append(w.inTransit, m)
+}
+
+func (w *Network) float64() float64 {
+	if w.Rand != nil {
+		return w.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+func (w *Network) intn(n int) int {
+	if w.Rand != nil {
+		return w.Rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+func (w *Network) carry(m *Message, delta int) {
+	for _, d := range []*Data{m.Data, m.Swap} {
+		if d == nil {
+			continue
+		}
+		if w.carrying[d] += delta; w.carrying[d] == 0 {
+			delete(w.carrying, d)
+		}
+	}
+}
+
+// carries is whether a message in transit carries the Data.
+func (w *Network) carries(d *Data) bool {
+	return w.carrying[d] > 0
+}
+
+// due removes and returns the messages to deliver by now, in the order they
+// arrive.
+func (w *Network) due(now int) []*Message {
+	var due []*Message
+	kept := w.inTransit[:0]
+	for _, m := range w.inTransit {
+		if m.deliverTick <= now {
+			due = append(due, m)
+			w.carry(m, -1)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	for i := len(kept); i < len(w.inTransit); i++ {
+		w.inTransit[i] = nil
+	}
+	w.inTransit = kept
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].deliverTick < due[j].deliverTick
+	})
+	return due
+}
+
+// InTransit is the number of messages sent but not yet delivered, including
+// those still being transferred.
+func (w *Network) InTransit() int {
+	return len(w.inTransit) + len(w.transferring)
+}
@@ -0,0 +1,185 @@
+package scr
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// idleState does nothing, so that tests drive the interactions of nodes
+// themselves.
+type idleState struct{}
+
+func (idleState) Name() string {
+	return "idle"
+}
+
+func (idleState) Apply(n *Node, c Coordinator) (int, string) {
+	return n.S.id, ""
+}
+
+// newIdleSimulation has nNodes idle nodes, each holding nDataPerNode pieces
+// of Data, that announce and pull no locations, on a network seeded by seed.
+func newIdleSimulation(nNodes, nDataPerNode int, seed int64) *Simulation {
+	s := newTestSimulation(nNodes, nDataPerNode, DiscardData)
+	s.States = NewStateRegistry()
+	s.States.Register(idleState{})
+	s.LocationPushThreshold = -1
+	s.LocationPullInterval = 0
+	s.Network.Rand = rand.New(rand.NewSource(seed))
+	return s
+}
+
+// testData is a piece of Data of one byte at loc.
+func testData(name string, loc V) *Data {
+	return &Data{Address: Address(name), Location: loc.Unit(), DataSize: 1}
+}
+
+func TestNetworkDeliversInLatencyOrder(t *testing.T) {
+	a := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	near := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	far := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	w := NewNetwork(func(from, to *Node) int {
+		if to == far {
+			return 3
+		}
+		return 1
+	}, 0, 0, defaultRequestTimeout)
+	w.Rand = rand.New(rand.NewSource(1))
+	toFar := &Message{Kind: MsgLocation, From: a, To: far}
+	toNear := &Message{Kind: MsgLocation, From: a, To: near}
+	w.send(toFar, 0)
+	w.send(toNear, 1)
+	if due := w.due(1); len(due) != 0 {
+		t.Fatalf("expected nothing delivered before its latency, got %v", due)
+	}
+	if due := w.due(2); len(due) != 1 || due[0] != toNear {
+		t.Fatalf("expected the message over the faster link first, got %v", due)
+	}
+	if due := w.due(3); len(due) != 1 || due[0] != toFar {
+		t.Fatalf("expected the message over the slower link after its latency, got %v", due)
+	}
+	if w.InTransit() != 0 {
+		t.Fatalf("expected nothing left in transit, got %d", w.InTransit())
+	}
+}
+
+func TestNetworkReorders(t *testing.T) {
+	a := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	b := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	for seed := int64(1); seed <= 8; seed++ {
+		w := NewNetwork(ConstantLatency(2), 0, 1, defaultRequestTimeout)
+		w.Rand = rand.New(rand.NewSource(seed))
+		first := &Message{Kind: MsgLocation, From: a, To: b}
+		w.send(first, 0)
+		w.ReorderChance = 0
+		second := &Message{Kind: MsgLocation, From: a, To: b}
+		w.send(second, 0)
+		if due := w.due(2); len(due) != 1 || due[0] != second {
+			t.Fatalf("seed %d: expected only the later message after the latency, got %v", seed, due)
+		}
+		// Held back by up to the latency again.
+		if due := w.due(4); len(due) != 1 || due[0] != first {
+			t.Fatalf("seed %d: expected the held back message after the later one, got %v", seed, due)
+		}
+	}
+}
+
+func TestNetworkDropsRepeatably(t *testing.T) {
+	a := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	b := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+	dropped := func() (d []bool) {
+		w := NewNetwork(ConstantLatency(1), 0.5, 0, defaultRequestTimeout)
+		w.Rand = rand.New(rand.NewSource(7))
+		for i := 0; i < 32; i++ {
+			d = append(d, !w.send(&Message{Kind: MsgLocation, From: a, To: b}, i))
+		}
+		return
+	}
+	first, second := dropped(), dropped()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same messages dropped with the same seed, differing at %d", i)
+		}
+	}
+}
+
+// newExchange has a sender holding Data closer to the receiver, and a full
+// receiver holding Data closer to the sender, so that they swap them.
+func newExchange(t *testing.T) (s *Simulation, sender, receiver *Node, offered, own *Data) {
+	s = newIdleSimulation(2, 0, 1)
+	sender, receiver = s.NodeCache[0], s.NodeCache[1]
+	sender.Store = NewMemoryStore(1, -1)
+	receiver.Store = NewMemoryStore(1, -1)
+	offered = testData("offered", V{0.9, 0, 0.1})
+	own = testData("own", V{0.1, 0, 0.9})
+	if !sender.keep(offered) || !receiver.keep(own) {
+		t.Fatal("expected the stores to take the Data")
+	}
+	sender.Location = V{0, 0, 1}
+	receiver.Location = V{1, 0, 0}
+	sender.addPeerAt(receiver, receiver.Location)
+	return
+}
+
+func TestSimulationDroppedDataIsNotLost(t *testing.T) {
+	s, sender, receiver, offered, own := newExchange(t)
+	s.Network.DropChance = 1
+	sender.offerData(s, receiver, offered, false)
+	runTicks(s, 1, 2)
+	if s.MessagesDropped != 1 || s.DataLost != 0 {
+		t.Fatalf("expected 1 message dropped and no Data lost, got %d and %d", s.MessagesDropped, s.DataLost)
+	}
+	runTicks(s, 2, 2+s.Network.RequestTimeout)
+	if !sender.holds(offered) || !receiver.holds(own) || offered.holders != 1 || own.holders != 1 {
+		t.Fatalf("expected each node to keep its own Data, got holders %d and %d", offered.holders, own.holders)
+	}
+	if s.RequestsTimedOut != 1 || s.DataLost != 0 {
+		t.Fatalf("expected the offer to time out with no Data lost, got %d and %d", s.RequestsTimedOut, s.DataLost)
+	}
+}
+
+func TestSimulationDroppedSwapIsLostOnce(t *testing.T) {
+	s, sender, receiver, offered, own := newExchange(t)
+	sender.offerData(s, receiver, offered, false)
+	// The acknowledgement carrying the swap is lost.
+	s.Network.DropChance = 1
+	runTicks(s, 1, 2)
+	if s.MessagesDropped != 1 || !receiver.holds(offered) || receiver.holds(own) {
+		t.Fatalf("expected the receiver to swap and its acknowledgement to be dropped, got %d dropped", s.MessagesDropped)
+	}
+	// The sender still holds what it offered, having not heard back.
+	if offered.holders != 2 || own.holders != 0 {
+		t.Fatalf("expected the offered Data held twice and the swap by none, got %d and %d", offered.holders, own.holders)
+	}
+	if s.DataLost != 1 || s.DataLostBytes != own.DataSize {
+		t.Fatalf("expected the swap counted as lost, got %d lost", s.DataLost)
+	}
+	runTicks(s, 2, 2+2*s.Network.RequestTimeout)
+	if s.DataLost != 1 {
+		t.Fatalf("expected the swap counted as lost only once, got %d", s.DataLost)
+	}
+	if live, _, duplicated := liveData(s.NodeCache); live != 1 || duplicated != 1 {
+		t.Fatalf("expected the offered Data live and duplicated, got %d live and %d duplicated", live, duplicated)
+	}
+}
+
+func TestSimulationRequestTimeoutFailsPeer(t *testing.T) {
+	s, sender, receiver, offered, _ := newExchange(t)
+	s.MaxPeerFailures = 0
+	s.PeerTimeout = 0
+	s.Network.DropChance = 1
+	sender.offerData(s, receiver, offered, false)
+	timeout := s.Network.RequestTimeout
+	runTicks(s, 1, timeout)
+	if s.RequestsTimedOut != 0 || sender.liveness[receiver].failures != 0 {
+		t.Fatalf("expected no timeout before %d ticks, got %d", timeout, s.RequestsTimedOut)
+	}
+	runTicks(s, timeout, timeout+1)
+	if s.RequestsTimedOut != 1 || sender.liveness[receiver].failures != 1 {
+		t.Fatalf("expected the request to time out as a failure of the peer, got %d timed out and %d failures",
+			s.RequestsTimedOut, sender.liveness[receiver].failures)
+	}
+	if sender.awaiting(MsgData) || sender.offered[offered] {
+		t.Fatal("expected the sender to stop awaiting its offer")
+	}
+}
@@ -0,0 +1,429 @@
+package scr
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Counters are running totals, kept for the whole simulation and for each
+// node class.
+type Counters struct {
+	// Nodes that have left, and their Data that was handed off to peers
+	// when they did.
+	NodesDeparted int
+	DataHandedOff int
+	// Every Data created, and lost when the only node holding it went
+	// offline, by count and bytes.
+	DataCreated      int
+	DataCreatedBytes int
+	DataLost         int
+	DataLostBytes    int
+	// Sum over lost Data of the ticks each was alive.
+	lostTicks int
+	// Peer entries evicted as stale.
+	PeersEvicted int
+	// Peers told a node's location, and told a peer's location on request.
+	LocationsPushed int
+	LocationsPulled int
+	// Bootstrap nodes introduced to by nodes that finished joining, and
+	// joins that found a first peer or that gave up.
+	JoinAttempts   int
+	JoinsSucceeded int
+	JoinsFailed    int
+	// Sum over successful joins of the ticks from creation to the first
+	// peer.
+	joinTicks int
+	// Messages sent, of those the ones lost by the network, and requests
+	// that went unanswered.
+	MessagesSent     int
+	MessagesDropped  int
+	RequestsTimedOut int
+	// Bytes of Data uploaded and downloaded by nodes.
+	BytesUploaded   int
+	BytesDownloaded int
+	// Peers' location claims checked out, found false, and of no Data to
+	// check.
+	VerificationsPassed       int
+	VerificationsFailed       int
+	VerificationsUnverifiable int
+	// Storage challenges answered with the right proof, with a wrong one,
+	// and by a peer no longer holding the Data.
+	ProofsPassed int
+	ProofsFailed int
+	ProofsMoved  int
+	// Computations of a node's location that its SolverBudget cut short,
+	// settling for the best iterate so far.
+	SolverBudgetExhausted int
+}
+
+// metricFiles are the per-tick output files for a group of nodes.
+type metricFiles struct {
+	// suffix is appended to the names of the files, including those
+	// written only on some ticks.
+	suffix    string
+	nodeState *os.File
+	// transition has the count of every from>to state transition.
+	transition *os.File
+	fx         *os.File
+	load       *os.File
+	departure  *os.File
+	durability *os.File
+	liveness   *os.File
+	location   *os.File
+	join       *os.File
+	message    *os.File
+	traffic    *os.File
+	verify     *os.File
+	proof      *os.File
+	solver     *os.File
+}
+
+func createMetricFile(name, suffix, header string) *os.File {
+	f, err := os.OpenFile(name+suffix+".txt", os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(f, "%s\n", header)
+	return f
+}
+
+// openMetricFiles appends the suffix to the name of each file.
+func openMetricFiles(suffix string, stateNames []string) *metricFiles {
+	transitions := make([]string, 0, len(stateNames)*len(stateNames))
+	for _, from := range stateNames {
+		for _, to := range stateNames {
+			transitions = append(transitions, from+">"+to)
+		}
+	}
+	return &metricFiles{
+		suffix:     suffix,
+		nodeState:  createMetricFile("states", suffix, "iter,"+strings.Join(stateNames, ",")),
+		transition: createMetricFile("transitions", suffix, "iter,"+strings.Join(transitions, ",")),
+		fx:         createMetricFile("fx", suffix, "iter,fx,fx^2,n,avg,stddev"),
+		load:       createMetricFile("load", suffix, "iter,nodes,avg,stddev,min,max"),
+		departure:  createMetricFile("departures", suffix, "iter,departed,handedOff,lost"),
+		durability: createMetricFile("durability", suffix, "iter,created,createdBytes,live,liveBytes,lost,lostBytes,meanTimeToLoss,duplicated"),
+		liveness:   createMetricFile("liveness", suffix, "iter,peers,stale,staleAge,evicted"),
+		location:   createMetricFile("locations", suffix, "iter,peers,meanError,maxError,pushed,pulled"),
+		join:       createMetricFile("joins", suffix, "iter,attempts,succeeded,failed,meanTimeToFirstPeer"),
+		message:    createMetricFile("messages", suffix, "iter,sent,dropped,timedOut"),
+		traffic:    createMetricFile("traffic", suffix, "iter,uploaded,downloaded,meanUpload,meanDownload,maxUpload,maxDownload,totalUploaded,totalDownloaded"),
+		verify:     createMetricFile("verifications", suffix, "iter,passed,failed,unverifiable,distrusted"),
+		proof:      createMetricFile("proofs", suffix, "iter,passed,failed,moved,challengedPeers,failingPeers"),
+		solver:     createMetricFile("solver", suffix, "iter,budgetExhausted"),
+	}
+}
+
+func (m *metricFiles) close() {
+	m.nodeState.Close()
+	m.transition.Close()
+	m.fx.Close()
+	m.load.Close()
+	m.departure.Close()
+	m.durability.Close()
+	m.liveness.Close()
+	m.location.Close()
+	m.join.Close()
+	m.message.Close()
+	m.traffic.Close()
+	m.verify.Close()
+	m.proof.Close()
+	m.solver.Close()
+}
+
+// write records this tick's metrics over the nodes, which are online.
+func (m *metricFiles) write(i int, nodes []*Node, c *Counters, nStates int) {
+	m.writeNodeState(i, nodes, nStates)
+	m.writeFx(i, nodes)
+	m.writeLoad(i, nodes)
+	m.writeDeparture(i, c)
+	m.writeDurability(i, nodes, c)
+	m.writeLiveness(i, nodes, c)
+	m.writeLocation(i, nodes, c)
+	m.writeJoin(i, c)
+	m.writeMessage(i, c)
+	m.writeTraffic(i, nodes, c)
+	m.writeVerify(i, nodes, c)
+	m.writeProof(i, nodes, c)
+	m.writeSolver(i, c)
+}
+
+// writeNodeState records the states applied this tick, and the transitions
+// between them, with a column for every registered state.
+func (m *metricFiles) writeNodeState(i int, nodes []*Node, nStates int) {
+	counts := make([]int, nStates)
+	t := make([][]int, nStates)
+	for j := range t {
+		t[j] = make([]int, nStates)
+	}
+	for _, n := range nodes {
+		n.CountLastState(counts)
+		n.CountTransition(t)
+	}
+	fmt.Fprintf(m.nodeState, "%d", i)
+	for _, c := range counts {
+		fmt.Fprintf(m.nodeState, ",%d", c)
+	}
+	fmt.Fprintf(m.nodeState, "\n")
+	fmt.Fprintf(m.transition, "%d", i)
+	for _, row := range t {
+		for _, c := range row {
+			fmt.Fprintf(m.transition, ",%d", c)
+		}
+	}
+	fmt.Fprintf(m.transition, "\n")
+}
+
+func (m *metricFiles) writeFx(i int, nodes []*Node) {
+	fx, fxsq, nfx := computeFxStatistics(nodes)
+	var avg float64
+	var stddev float64
+	if nfx > 0 {
+		avg = fx / float64(nfx)
+		stddev = fxsq/float64(nfx) - (avg * avg)
+	}
+	fmt.Fprintf(m.fx, "%v,%v,%v,%v,%v,%v\n", i, fx, fxsq, nfx, avg, stddev)
+}
+
+// writeLoad records how evenly data is spread across nodes, by the number of
+// pieces of Data each holds.
+func (m *metricFiles) writeLoad(i int, nodes []*Node) {
+	sum := 0.0
+	sumsq := 0.0
+	min := -1
+	max := 0
+	for _, node := range nodes {
+		held := len(node.getDataLocations())
+		sum += float64(held)
+		sumsq += float64(held * held)
+		if min < 0 || held < min {
+			min = held
+		}
+		if held > max {
+			max = held
+		}
+	}
+	n := len(nodes)
+	var avg float64
+	var stddev float64
+	if n > 0 {
+		avg = sum / float64(n)
+		stddev = math.Sqrt(sumsq/float64(n) - avg*avg)
+	}
+	fmt.Fprintf(m.load, "%v,%v,%v,%v,%v,%v\n", i, n, avg, stddev, min, max)
+}
+
+// writeDeparture records the running totals of departed nodes and the fate
+// of their Data.
+func (m *metricFiles) writeDeparture(i int, c *Counters) {
+	fmt.Fprintf(m.departure, "%v,%v,%v,%v\n", i, c.NodesDeparted, c.DataHandedOff, c.DataLost)
+}
+
+// writeDurability records the running totals of Data created and lost, the
+// distinct Data still held by the nodes, and the mean number of ticks lost
+// Data was alive (0 until any is lost).
+func (m *metricFiles) writeDurability(i int, nodes []*Node, c *Counters) {
+	live, liveBytes, duplicated := liveData(nodes)
+	var meanTimeToLoss float64
+	if c.DataLost > 0 {
+		meanTimeToLoss = float64(c.lostTicks) / float64(c.DataLost)
+	}
+	fmt.Fprintf(m.durability, "%v,%v,%v,%v,%v,%v,%v,%v,%v\n",
+		i,
+		c.DataCreated,
+		c.DataCreatedBytes,
+		live,
+		liveBytes,
+		c.DataLost,
+		c.DataLostBytes,
+		meanTimeToLoss,
+		duplicated)
+}
+
+// liveData is the distinct Data held by the nodes, by count and bytes, and of
+// those the pieces held by more than one node, as a lost acknowledgement of
+// an exchange leaves them.
+func liveData(nodes []*Node) (live, liveBytes, duplicated int) {
+	seen := make(map[*Data]bool)
+	for _, n := range nodes {
+		for _, d := range n.Store.List() {
+			if !seen[d] {
+				seen[d] = true
+				live++
+				liveBytes += d.DataSize
+				if d.holders > 1 {
+					duplicated++
+				}
+			}
+		}
+	}
+	return
+}
+
+// writeLiveness records the peer entries held by the nodes, how many of them
+// are of departed nodes and how many ticks ago on average those departed,
+// and the running total of evicted entries.
+func (m *metricFiles) writeLiveness(i int, nodes []*Node, c *Counters) {
+	entries := 0
+	stale := 0
+	staleTicks := 0
+	for _, n := range nodes {
+		e, st, stt := n.countStalePeers(i)
+		entries += e
+		stale += st
+		staleTicks += stt
+	}
+	var staleAge float64
+	if stale > 0 {
+		staleAge = float64(staleTicks) / float64(stale)
+	}
+	fmt.Fprintf(m.liveness, "%v,%v,%v,%v,%v\n", i, entries, stale, staleAge, c.PeersEvicted)
+}
+
+// writeLocation records how far, in radians, the locations the nodes hold
+// for their online peers are from where those peers actually are, and the
+// running totals of locations pushed and pulled.
+func (m *metricFiles) writeLocation(i int, nodes []*Node, c *Counters) {
+	entries := 0
+	sum := 0.0
+	max := 0.0
+	for _, n := range nodes {
+		e, sm, mx := n.peerLocationErrors()
+		entries += e
+		sum += sm
+		if mx > max {
+			max = mx
+		}
+	}
+	var meanError float64
+	if entries > 0 {
+		meanError = sum / float64(entries)
+	}
+	fmt.Fprintf(m.location, "%v,%v,%v,%v,%v,%v\n", i, entries, meanError, max, c.LocationsPushed, c.LocationsPulled)
+}
+
+// writeJoin records the running totals of attempts to join and of their
+// outcomes, and the mean number of ticks a successfully joined node took to
+// find its first peer (0 until any has).
+func (m *metricFiles) writeJoin(i int, c *Counters) {
+	var meanTimeToFirstPeer float64
+	if c.JoinsSucceeded > 0 {
+		meanTimeToFirstPeer = float64(c.joinTicks) / float64(c.JoinsSucceeded)
+	}
+	fmt.Fprintf(m.join, "%v,%v,%v,%v,%v\n", i, c.JoinAttempts, c.JoinsSucceeded, c.JoinsFailed, meanTimeToFirstPeer)
+}
+
+// writeMessage records the running totals of messages sent and dropped, and
+// of requests that timed out.
+func (m *metricFiles) writeMessage(i int, c *Counters) {
+	fmt.Fprintf(m.message, "%v,%v,%v,%v\n", i, c.MessagesSent, c.MessagesDropped, c.RequestsTimedOut)
+}
+
+// writeTraffic records the bytes of Data the nodes uploaded and downloaded
+// this tick, in all and per node, and the running totals.
+func (m *metricFiles) writeTraffic(i int, nodes []*Node, c *Counters) {
+	up := 0
+	down := 0
+	maxUp := 0
+	maxDown := 0
+	for _, n := range nodes {
+		up += n.uploadedTick
+		down += n.downloadedTick
+		if n.uploadedTick > maxUp {
+			maxUp = n.uploadedTick
+		}
+		if n.downloadedTick > maxDown {
+			maxDown = n.downloadedTick
+		}
+	}
+	var meanUp float64
+	var meanDown float64
+	if len(nodes) > 0 {
+		meanUp = float64(up) / float64(len(nodes))
+		meanDown = float64(down) / float64(len(nodes))
+	}
+	fmt.Fprintf(m.traffic, "%v,%v,%v,%v,%v,%v,%v,%v,%v\n", i, up, down, meanUp, meanDown, maxUp, maxDown, c.BytesUploaded, c.BytesDownloaded)
+}
+
+// writeVerify records the running totals of verifications by outcome, and
+// the nodes distrusted by the nodes.
+func (m *metricFiles) writeVerify(i int, nodes []*Node, c *Counters) {
+	distrusted := 0
+	for _, n := range nodes {
+		distrusted += len(n.distrusted)
+	}
+	fmt.Fprintf(m.verify, "%v,%v,%v,%v,%v\n", i, c.VerificationsPassed, c.VerificationsFailed, c.VerificationsUnverifiable, distrusted)
+}
+
+// writeProof records the running totals of storage challenges by outcome,
+// and over the records the nodes keep of their peers, the peers challenged
+// and those that have failed more challenges than they passed.
+func (m *metricFiles) writeProof(i int, nodes []*Node, c *Counters) {
+	challenged := 0
+	failing := 0
+	for _, n := range nodes {
+		for _, st := range n.proofs {
+			challenged++
+			if st.failed > st.passed {
+				failing++
+			}
+		}
+	}
+	fmt.Fprintf(m.proof, "%v,%v,%v,%v,%v,%v\n", i, c.ProofsPassed, c.ProofsFailed, c.ProofsMoved, challenged, failing)
+}
+
+// writeSolver records the running total of location computations cut short
+// by their budget.
+func (m *metricFiles) writeSolver(i int, c *Counters) {
+	fmt.Fprintf(m.solver, "%v,%v\n", i, c.SolverBudgetExhausted)
+}
+
+// createTickFile creates a file written only on tick i.
+func (m *metricFiles) createTickFile(name string, i int, header string) *os.File {
+	return createMetricFile(fmt.Sprintf("%s%s_%d", name, m.suffix, i), "", header)
+}
+
+// writeHopHist records, of the nodes, how many hops each is from each Data by
+// hops, and how many pieces of Data cannot be reached by how many of them.
+// hops is the number of hops from every node able to reach each Data, of at
+// most nNodes nodes.
+func (m *metricFiles) writeHopHist(i int, data []*Data, hops map[*Data]map[*Node]int, nodes []*Node, nNodes int) {
+	hopsHist := make([]int, nNodes)
+	disjHist := make([]int, nNodes)
+	for _, d := range data {
+		nodeMap := hops[d]
+		disj := 0
+		for _, n := range nodes {
+			if h, ok := nodeMap[n]; ok {
+				hopsHist[h] += 1
+			} else {
+				disj++
+			}
+		}
+		// Data every node reaches is not counted.
+		if disj > 0 {
+			disjHist[disj] += 1
+		}
+	}
+	histF := m.createTickFile("hist", i, "hops,count")
+	defer histF.Close()
+	for h, v := range hopsHist {
+		fmt.Fprintf(histF, "%d,%d\n", h, v)
+	}
+	disjF := m.createTickFile("disj", i, "disjoint,count")
+	defer disjF.Close()
+	for d, v := range disjHist {
+		fmt.Fprintf(disjF, "%d,%d\n", d, v)
+	}
+}
+
+func computeFxStatistics(nodes []*Node) (fx float64, fxsq float64, nfx int) {
+	for _, n := range nodes {
+		fx += n.fx
+		fxsq += n.fxsq
+		nfx += n.nfx
+	}
+	return
+}
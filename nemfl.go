@@ -1,31 +1,151 @@
 package scr
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 )
 
+// ErrBudgetExhausted is returned alongside the best iterate found so far when
+// a solver runs out of its Budget, or its context is done.
+var ErrBudgetExhausted = errors.New("budget exhausted")
+
+// Budget bounds the work of a single call to a solver, on top of the package
+// limits. The zero Budget is bounded by the package limits alone.
+type Budget struct {
+	// MaxIterations is the most iterations of each smooth search, not of
+	// the whole call: a call that restarts the search, or searches once
+	// per cluster, may take as many in each.
+	MaxIterations int
+	// MaxDuration is the most time for the whole call.
+	MaxDuration time.Duration
+}
+
+func (b Budget) withContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.MaxDuration > 0 {
+		return context.WithTimeout(ctx, b.MaxDuration)
+	}
+	return context.WithCancel(ctx)
+}
+
 // See documentation for solveNonEuclideanMultifacilityLocation.
+//
+// Degenerate existing locations are handled as documented by
+// SolveNonEuclideanMultifacilityLocationAll, returning the canonical optimum.
 func SolveNonEuclideanMultifacilityLocation(
 	existingLocations []V,
 	existingLocationWeights []float64,
 	nonsmoothTolerance, smoothTolerance float64) (V, error) {
-	result, x0, alpha0, _, _, _ := solveNonEuclideanMultifacilityLocationNonSmooth(
+	return SolveNonEuclideanMultifacilityLocationContext(
+		context.Background(),
 		existingLocations,
 		existingLocationWeights,
-		nonsmoothTolerance)
-	if !result.Equals(noAnswer) {
-		return result, nil
+		nonsmoothTolerance, smoothTolerance,
+		Budget{})
+}
+
+// SolveNonEuclideanMultifacilityLocationContext is
+// SolveNonEuclideanMultifacilityLocation bounded by a context and a budget. If
+// either runs out, the best iterate so far is returned with an error wrapping
+// ErrBudgetExhausted.
+func SolveNonEuclideanMultifacilityLocationContext(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance, smoothTolerance float64,
+	budget Budget) (V, error) {
+	optima, _, err := SolveNonEuclideanMultifacilityLocationTrace(
+		ctx,
+		existingLocations,
+		existingLocationWeights,
+		nonsmoothTolerance, smoothTolerance,
+		budget,
+		nil)
+	if len(optima) == 0 {
+		return V{}, err
 	}
-	smoothSolution, _, _, _ := solveNonEuclideanMultifacilityLocationSmooth(
+	return optima[0], err
+}
+
+// SolveNonEuclideanMultifacilityLocationAll detects degenerate existing
+// locations before solving: co-located duplicates, antipodal pairs, locations
+// along one great circle, and equal-weight symmetric sets. Instead of an
+// arbitrary answer it returns every equivalent optimum it finds, in the order
+// of the existing locations they came from.
+//
+// The first optimum is the canonical choice. When every point on the sphere
+// is optimal, the canonical choice is the first existing location and it is
+// the only optimum returned.
+//
+// See documentation for solveNonEuclideanMultifacilityLocation.
+func SolveNonEuclideanMultifacilityLocationAll(
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance, smoothTolerance float64) ([]V, Degeneracy, error) {
+	return SolveNonEuclideanMultifacilityLocationTrace(
+		context.Background(),
 		existingLocations,
 		existingLocationWeights,
+		nonsmoothTolerance, smoothTolerance,
+		Budget{},
+		nil)
+}
+
+// SolveNonEuclideanMultifacilityLocationTrace is
+// SolveNonEuclideanMultifacilityLocationAll, calling trace with each existing
+// location the nonsmooth solver examines and then each iterate of the smooth
+// solver. Degenerate problems solved in closed form have no iterates.
+//
+// It is bounded by a context and a budget. If either runs out, the best
+// iterate so far is the only optimum returned, with an error wrapping
+// ErrBudgetExhausted.
+func SolveNonEuclideanMultifacilityLocationTrace(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance, smoothTolerance float64,
+	budget Budget,
+	trace TraceFn) ([]V, Degeneracy, error) {
+	if len(existingLocations) == 0 {
+		return nil, 0, fmt.Errorf("No NEMFL solution")
+	}
+	ctx, cancel := budget.withContext(ctx)
+	defer cancel()
+	r := reduceDegenerateLocations(existingLocations, existingLocationWeights)
+	if optima, ok := r.closedFormOptima(existingLocations); ok {
+		if len(optima) > 1 {
+			r.deg |= DegenerateTiedOptima
+		}
+		return optima, r.deg, nil
+	}
+	optimal, at0idx, faj := existingOptima(r.locs, r.weights, nonsmoothTolerance, trace)
+	if len(optimal) > 0 {
+		optima := make([]V, len(optimal))
+		for i, t := range optimal {
+			optima[i] = r.locs[t]
+		}
+		if len(optima) > 1 {
+			r.deg |= DegenerateTiedOptima
+		}
+		return optima, r.deg, nil
+	}
+	x0, alpha0 := initialSmoothPoint(ctx, r.locs, r.weights, at0idx, faj, rand.Float64)
+	smoothSolution, _, _, _, err := solveNonEuclideanMultifacilityLocationSmooth(
+		ctx,
+		r.locs,
+		r.weights,
 		smoothTolerance,
 		x0,
-		alpha0)
+		alpha0,
+		budget.MaxIterations,
+		trace)
 	if smoothSolution == noAnswer {
-		return V{}, fmt.Errorf("No NEMFL solution")
+		return nil, r.deg, fmt.Errorf("No NEMFL solution")
 	}
-	return smoothSolution, nil
+	return []V{smoothSolution}, r.deg, err
 }
 
 // See documentation for solveNonEuclideanMultifacilityLocation.
@@ -34,12 +154,22 @@ func SolveNonEuclideanMultifacilityLocationSkipNonSmooth(
 	existingLocationWeights []float64,
 	nonsmoothTolerance, smoothTolerance float64,
 	initialPoint V) (V, error) {
-	smoothSolution, _, _, _ := solveNonEuclideanMultifacilityLocationSmooth(
-		existingLocations,
-		existingLocationWeights,
+	if len(existingLocations) == 0 {
+		return V{}, fmt.Errorf("No NEMFL solution")
+	}
+	r := reduceDegenerateLocations(existingLocations, existingLocationWeights)
+	if optima, ok := r.closedFormOptima(existingLocations); ok {
+		return optima[0], nil
+	}
+	smoothSolution, _, _, _, _ := solveNonEuclideanMultifacilityLocationSmooth(
+		context.Background(),
+		r.locs,
+		r.weights,
 		smoothTolerance,
 		initialPoint,
-		0.001)
+		0.001,
+		0,
+		nil)
 	if smoothSolution == noAnswer {
 		return V{}, fmt.Errorf("No NEMFL solution")
 	}
@@ -52,51 +182,187 @@ func SolveNonEuclideanMultifacilityLocationMonteCarlo(
 	existingLocationWeights []float64,
 	nonsmoothTolerance, smoothTolerance float64,
 	nMC int) (V, float64, float64, int, error) {
-	result, x0, alpha0, fxaj, fxsq, nfx := solveNonEuclideanMultifacilityLocationNonSmooth(
+	return SolveNonEuclideanMultifacilityLocationMonteCarloParallel(
+		context.Background(),
+		existingLocations,
+		existingLocationWeights,
+		nonsmoothTolerance, smoothTolerance,
+		nMC,
+		1,
+		rand.Int63(),
+		Budget{})
+}
+
+// SolveNonEuclideanMultifacilityLocationMonteCarloParallel runs the nMC
+// smooth searches of SolveNonEuclideanMultifacilityLocationMonteCarlo across
+// nWorkers goroutines. Each restart draws its starting point from its own
+// source seeded from the master seed, so the result is identical for a given
+// seed regardless of nWorkers.
+//
+// It is bounded by a context and a budget. The time is shared by all
+// restarts, and the iterations bound each. If either runs out, the best
+// iterate so far is returned with an error wrapping ErrBudgetExhausted. A time
+// budget makes the result depend on timing.
+//
+// See documentation for solveNonEuclideanMultifacilityLocation.
+func SolveNonEuclideanMultifacilityLocationMonteCarloParallel(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance, smoothTolerance float64,
+	nMC int,
+	nWorkers int,
+	seed int64,
+	budget Budget) (V, float64, float64, int, error) {
+	return SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(
+		ctx,
 		existingLocations,
 		existingLocationWeights,
-		nonsmoothTolerance)
+		nonsmoothTolerance, smoothTolerance,
+		nMC,
+		nWorkers,
+		seed,
+		budget,
+		nil)
+}
+
+// SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace is
+// SolveNonEuclideanMultifacilityLocationMonteCarloParallel, calling trace with
+// each existing location the nonsmooth solver examines and then each iterate
+// of every restart, tagged with the restart it belongs to. The first restart
+// continues from the nonsmooth solver. Iterates of different restarts
+// interleave, but trace is only called by one goroutine at a time.
+func SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance, smoothTolerance float64,
+	nMC int,
+	nWorkers int,
+	seed int64,
+	budget Budget,
+	trace TraceFn) (V, float64, float64, int, error) {
+	if len(existingLocations) == 0 {
+		return V{}, 0, 0, 0, fmt.Errorf("No NEMFL solution")
+	}
+	ctx, cancel := budget.withContext(ctx)
+	defer cancel()
+	r := reduceDegenerateLocations(existingLocations, existingLocationWeights)
+	if optima, ok := r.closedFormOptima(existingLocations); ok {
+		fx, fxsq := geodesicDistances(optima[0], existingLocations, existingLocationWeights)
+		return optima[0], fx, fxsq, len(existingLocations), nil
+	}
+	var mu sync.Mutex
+	restartTrace := func(i int) TraceFn {
+		if trace == nil {
+			return nil
+		}
+		return func(it Iterate) {
+			it.Restart = i
+			mu.Lock()
+			defer mu.Unlock()
+			trace(it)
+		}
+	}
+	r0 := rand.New(rand.NewSource(deriveSeed(seed, 0)))
+	result, x0, alpha0, _, _, _ := solveNonEuclideanMultifacilityLocationNonSmooth(
+		ctx,
+		r.locs,
+		r.weights,
+		nonsmoothTolerance,
+		r0.Float64,
+		restartTrace(0))
 	if !result.Equals(noAnswer) {
-		return result, fxaj, fxsq, nfx, nil
+		fx, fxsq := geodesicDistances(result, existingLocations, existingLocationWeights)
+		return result, fx, fxsq, len(existingLocations), nil
 	}
+	sis := make([]V, nMC)
+	fxkis := make([]float64, nMC)
+	errs := make([]error, nMC)
+	forEachIndexParallel(nMC, nWorkers, func(i int) {
+		xi := x0
+		alphai := alpha0
+		if i > 0 {
+			xi = RandomVectorFrom(rand.New(rand.NewSource(deriveSeed(seed, i))))
+			alphai = 0.001
+		}
+		sis[i], fxkis[i], _, _, errs[i] = solveNonEuclideanMultifacilityLocationSmooth(
+			ctx,
+			r.locs,
+			r.weights,
+			smoothTolerance,
+			xi,
+			alphai,
+			budget.MaxIterations,
+			restartTrace(i))
+	})
+	// Reduce in restart order so ties resolve the same way every time.
 	smoothSolution := noAnswer
 	var fxk float64
+	var err error
 	for i := 0; i < nMC; i++ {
-		if i == 0 {
-			si, fxki, fxksq, nfxk := solveNonEuclideanMultifacilityLocationSmooth(
-				existingLocations,
-				existingLocationWeights,
-				smoothTolerance,
-				x0,
-				alpha0)
-			smoothSolution = si
-			fxk = fxki
-			fxsq = fxksq
-			nfx = nfxk
-		} else {
-			si, fxki, fxksq, nfxk := solveNonEuclideanMultifacilityLocationSmooth(
-				existingLocations,
-				existingLocationWeights,
-				smoothTolerance,
-				RandomVector(),
-				0.001)
-			if !si.Equals(noAnswer) && (smoothSolution.Equals(noAnswer) || fxki < fxk) {
-				smoothSolution = si
-				fxk = fxki
-				fxsq = fxksq
-				nfx = nfxk
-			}
+		if i == 0 || (!sis[i].Equals(noAnswer) && (smoothSolution.Equals(noAnswer) || fxkis[i] < fxk)) {
+			smoothSolution = sis[i]
+			fxk = fxkis[i]
+		}
+		if errs[i] != nil {
+			err = errs[i]
 		}
 	}
 	if smoothSolution == noAnswer {
-		return V{}, fxk, fxsq, nfx, fmt.Errorf("No NEMFL solution")
+		return V{}, 0, 0, 0, fmt.Errorf("No NEMFL solution")
 	}
-	return smoothSolution, fxk, fxsq, nfx, nil
+	// Report f(x) of the original problem, not the reduced one.
+	fx, fxsq := geodesicDistances(smoothSolution, existingLocations, existingLocationWeights)
+	return smoothSolution, fx, fxsq, len(existingLocations), err
+}
+
+// budgetExhausted wraps ErrBudgetExhausted with the reason the budget ran out.
+func budgetExhausted(ctx context.Context, k int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrBudgetExhausted, err)
+	}
+	return fmt.Errorf("%w: %d iterations", ErrBudgetExhausted, k)
+}
+
+// Iterate is one step taken by a NEMFL solver.
+type Iterate struct {
+	// Smooth is false for existing locations examined by the nonsmooth
+	// solver, and true for the iterates of the smooth solver.
+	Smooth bool
+	K      int
+	Xk     V
+	Alphak float64
+	DkNorm float64
+	Fx     float64
+	// StepHalvings is how many times alphak was halved in Step 4 before
+	// the step was taken.
+	StepHalvings int
+	// Restart is the smooth search the iterate belongs to, for solvers that
+	// restart it from several points, and 0 otherwise.
+	Restart int
+}
+
+// TraceFn receives iterates as a solver takes them.
+type TraceFn func(Iterate)
+
+func (t TraceFn) record(it Iterate) {
+	if t != nil {
+		t(it)
+	}
+}
+
+// Objective is f(x), the weighted sum of great circle distances from p to
+// each of the existing locations.
+func Objective(p V, existingLocations []V, existingLocationWeights []float64) float64 {
+	fx, _ := geodesicDistances(p, existingLocations, existingLocationWeights)
+	return fx
 }
 
 const (
-	maxAlpha = 1000
-	maxK     = 100000
+	maxAlpha             = 1000
+	maxK                 = 100000
+	maxInitialPointTries = 100000
 )
 
 var (
@@ -122,54 +388,86 @@ var (
 // Finds the optimal location related to other locations that minimizes a
 // weighted great circle distance to each.
 //
-// Everything must lie on the unit sphere.
+// Everything must lie on the unit sphere, without co-located duplicates or
+// antipodal pairs. See reduceDegenerateLocations.
 func solveNonEuclideanMultifacilityLocationNonSmooth(
+	ctx context.Context,
 	existingLocations []V, // Existing locations on a unit sphere
 	existingLocationWeights []float64, // Must be positive
 	// These tolerances can help bound the number of iterations while
 	// maintaining a degree of accuracy. Must be nonnegative.
-	nonsmoothTolerance float64) (result, x0 V, alpha0, fxaj, fxsq float64, nfx int) {
+	nonsmoothTolerance float64,
+	// Source of random numbers when sampling for an initial point.
+	float64Fn func() float64,
+	// Receives each existing location examined, may be nil.
+	trace TraceFn) (result, x0 V, alpha0, fxaj, fxsq float64, nfx int) {
 	// Step 1
 	//
 	// Check nonsmooth solutions, where smooth solutions are non-
 	// differentiable.
-	faj := make([]float64, len(existingLocations))
-	fsqaj := make([]float64, len(existingLocations))
+	optimal, at0idx, faj := existingOptima(existingLocations, existingLocationWeights, nonsmoothTolerance, trace)
+	if len(optimal) > 0 {
+		t := optimal[0]
+		fxaj, fxsq = geodesicDistancesFromI(t, existingLocations, existingLocationWeights)
+		return existingLocations[t], V{}, 0, fxaj, fxsq, len(existingLocations)
+	}
+	// Step 2
+	x0, alpha0 = initialSmoothPoint(ctx, existingLocations, existingLocationWeights, at0idx, faj, float64Fn)
+	return noAnswer, x0, alpha0, 0.0, 0.0, 0
+}
+
+// existingOptima is Step 1 of the algorithm. It returns, in increasing order,
+// the indices of all existing locations that minimize f(x) and also meet the
+// conditions for optimality.
+//
+// Regardless, at0idx is the first existing location minimizing f(x), and faj
+// is f(x) at each existing location.
+func existingOptima(
+	existingLocations []V,
+	existingLocationWeights []float64,
+	nonsmoothTolerance float64,
+	trace TraceFn) (optimal []int, at0idx int, faj []float64) {
+	faj = make([]float64, len(existingLocations))
 	for j := range existingLocations {
-		faj[j], fsqaj[j] = geodesicDistancesFromI(j, existingLocations, existingLocationWeights)
+		faj[j], _ = geodesicDistancesFromI(j, existingLocations, existingLocationWeights)
 	}
-	var at0idx int
-	for t, at := range existingLocations {
-		isMin := true
-		for j := range existingLocations {
-			if j == t {
-				continue
-			}
-			if faj[j] > faj[t] {
-				isMin = false
-				break
-			}
+	// Several existing locations may tie for the minimum. They are all
+	// equivalent optima if they meet the conditions for optimality.
+	minima := tiedMinima(faj)
+	at0idx = minima[0]
+	for k, t := range minima {
+		if trace != nil {
+			trace.record(Iterate{
+				K:      k + 1,
+				Xk:     existingLocations[t],
+				DkNorm: d(t, existingLocations, existingLocationWeights).Norm(),
+				Fx:     faj[t],
+			})
 		}
-		if isMin {
-			at0idx = t
-			// Guaranteed to occur at least once. If occurs
-			// more than once, we'll just get the last one
-			// for the smooth search. That's OK, the
-			// iterative process is convergent, so having an
-			// arbitrary starting point is fine.
-			if optimalityConditionFromI(t, existingLocations, existingLocationWeights, nonsmoothTolerance) {
-				return at, V{}, 0, faj[t], fsqaj[t], len(existingLocations)
-			}
+		if optimalityConditionFromI(t, existingLocations, existingLocationWeights, nonsmoothTolerance) {
+			optimal = append(optimal, t)
 		}
 	}
-	// Step 2
-	//
-	// Guess an initial point that is near the best nonsmooth
-	// solution, from which we will begin the smooth search.
-	//
-	// We're given at0idx and at0 from Step 1 as a "minimum"
-	foundX := false
-	for !foundX {
+	return
+}
+
+// initialSmoothPoint is Step 2 of the algorithm.
+//
+// Guess an initial point that is near the best nonsmooth solution, from which
+// we will begin the smooth search.
+//
+// We're given at0idx from Step 1 as a "minimum"
+func initialSmoothPoint(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	at0idx int,
+	faj []float64,
+	float64Fn func() float64) (x0 V, alpha0 float64) {
+	for i := 0; i < maxInitialPointTries; i++ {
+		if i%1000 == 0 && ctx.Err() != nil {
+			break
+		}
 		// First condition: that at0 plus a
 		// rotation of alpha*dval is along the convex
 		// hull of ajat.
@@ -182,16 +480,15 @@ func solveNonEuclideanMultifacilityLocationNonSmooth(
 		// aka spreadsheets would it work out.
 		//
 		// Random sampling for the win.
-		xCandidate := RandomVector()
+		xCandidate := randomVector(float64Fn)
 		fx, _ := geodesicDistances(xCandidate, existingLocations, existingLocationWeights)
 		if fx < faj[at0idx] {
-			x0 = xCandidate
-			alpha0 = 0.001
-			foundX = true
-			break
+			return xCandidate, 0.001
 		}
 	}
-	return noAnswer, x0, alpha0, 0.0, 0.0, 0
+	// The better points are too rare to sample, so at0 is nearly optimal,
+	// or we ran out of time. Begin searching from it instead.
+	return existingLocations[at0idx], 0.001
 }
 
 // solveNonEuclideanMultifacilityLocationSmooth implements the algorithm in the
@@ -204,8 +501,10 @@ func solveNonEuclideanMultifacilityLocationNonSmooth(
 // Finds the optimal location related to other locations that minimizes a
 // weighted great circle distance to each for smooth solutions.
 //
-// Everything must lie on the unit sphere.
+// Everything must lie on the unit sphere, without co-located duplicates or
+// antipodal pairs. See reduceDegenerateLocations.
 func solveNonEuclideanMultifacilityLocationSmooth(
+	ctx context.Context,
 	existingLocations []V, // Existing locations on a unit sphere
 	existingLocationWeights []float64, // Must be positive
 	// These tolerances can help bound the number of iterations while
@@ -214,7 +513,12 @@ func solveNonEuclideanMultifacilityLocationSmooth(
 	// 'x0' is an initial point on the unit sphere to begin searching for
 	// the smooth solution. Only used if skipNonSmooth is true.
 	x0 V,
-	alpha0 float64) (V, float64, float64, int) {
+	alpha0 float64,
+	// Most iterations to take before returning the current iterate with
+	// ErrBudgetExhausted. Unlimited if not positive, aside from maxK.
+	maxIterations int,
+	// Receives each iterate, may be nil.
+	trace TraceFn) (V, float64, float64, int, error) {
 	xk := x0
 	alphak := alpha0
 	// Should be convergent. But protect against unreasonable #s of iterations
@@ -224,16 +528,31 @@ func solveNonEuclideanMultifacilityLocationSmooth(
 		// Step 3
 		dk := dx(xk, existingLocations, existingLocationWeights)
 		fxk, fxsqk := geodesicDistances(xk, existingLocations, existingLocationWeights)
+		it := Iterate{
+			Smooth: true,
+			K:      k,
+			Xk:     xk,
+			Alphak: alphak,
+			DkNorm: dk.Norm(),
+			Fx:     fxk,
+		}
+		// Iterates only ever improve, so the current one is the best.
+		if ctx.Err() != nil || (maxIterations > 0 && k > maxIterations) {
+			trace.record(it)
+			return xk, fxk, fxsqk, len(existingLocations), budgetExhausted(ctx, k-1)
+		}
 		if k == 1 {
 			prevFxk = fxk
 		} else if prevFxk == fxk {
 			// This occurs when a point is stuck in a local minima.
-			return xk, fxk, fxsqk, len(existingLocations)
+			trace.record(it)
+			return xk, fxk, fxsqk, len(existingLocations), nil
 		} else {
 			prevFxk = fxk
 		}
 		if optimalityCondition(xk, existingLocations, existingLocationWeights, smoothTolerance) {
-			return xk, fxk, fxsqk, len(existingLocations)
+			trace.record(it)
+			return xk, fxk, fxsqk, len(existingLocations), nil
 		} else {
 			alphak = alphax(xk, existingLocations, existingLocationWeights)
 		}
@@ -254,13 +573,19 @@ func solveNonEuclideanMultifacilityLocationSmooth(
 					prevFxn = fxn
 				} else if prevFxn == fxn {
 					// This occurs when a point is stuck at a local minima.
-					return xk, fxk, fxsqk, len(existingLocations)
+					it.Alphak = alphak
+					it.StepHalvings = alphaIter - 1
+					trace.record(it)
+					return xk, fxk, fxsqk, len(existingLocations), nil
 				}
 				alphak *= 0.5
 			}
 		}
+		it.Alphak = alphak
+		it.StepHalvings = alphaIter - 1
+		trace.record(it)
 	}
-	return noAnswer, 0, 0, 0
+	return noAnswer, 0, 0, 0, nil
 }
 
 // geodesicDistances measures the sum of all great circle distances from an
@@ -309,34 +634,29 @@ func geodesicDistancesFromI(pidx int, eval []V, c []float64) (float64, float64)
 //
 // Equation 15
 func optimalityConditionFromI(pidx int, eval []V, c []float64, tolerance float64) bool {
-	t := c[pidx]
-	p := eval[pidx]
-	var s V
-	for idx, e := range eval {
-		if idx == pidx {
-			continue
-		}
-		num := p.Sub(e.DivScalar(p.Dot(e)))
-		den := num.Norm()
-		s = s.Add(num.MulScalar(c[idx]).DivScalar(den))
-	}
-	return s.Norm() <= t+tolerance
+	return optimalityCondition(eval[pidx], eval, c, tolerance)
 }
 
-// optimalityConditionFromI checks whether an arbitrary location meets the
-// conditions for optimality.
+// optimalityCondition checks whether an arbitrary location meets the
+// conditions for optimality. If it is co-located with existing locations, their
+// weights bound the gradient of the rest instead of zero.
 //
 // c is a parallel array of eval, for weights.
 //
 // Equation 16
 func optimalityCondition(p V, eval []V, c []float64, tolerance float64) bool {
 	var s V
+	t := 0.0
 	for idx, e := range eval {
-		num := p.Sub(e.DivScalar(p.Dot(e)))
-		den := num.Norm()
-		s = s.Add(num.MulScalar(c[idx]).DivScalar(den))
+		if isCoLocated(e, p) {
+			t += c[idx]
+			continue
+		}
+		if dir, ok := tangentAwayFrom(e, p); ok {
+			s = s.Add(dir.MulScalar(c[idx]))
+		}
 	}
-	return s.Norm() > -tolerance && s.Norm() < tolerance
+	return s.Norm() <= t+tolerance
 }
 
 // ajx produces a point coplanar in the unique plane that is tangent on a sphere
@@ -352,10 +672,26 @@ func ajx(aj, x V) V {
 	return aj.DivScalar(x.Dot(aj))
 }
 
+// tangentAwayFrom is the unit vector tangent to the sphere at x pointing
+// directly away from aj, which is the gradient at x of the great circle
+// distance to aj.
+//
+// It is not defined when aj is co-located with x. Nor when aj is antipodal to
+// x, where the distance falls equally fast in every direction so aj has no
+// preferred direction; callers leave it out of gradients.
+func tangentAwayFrom(aj, x V) (V, bool) {
+	if isCoLocated(aj, x) || isAntipodal(aj, x) {
+		return V{}, false
+	}
+	ajxp := stp(aj, x)
+	num := x.Sub(ajxp)
+	return num.DivScalar(num.Norm()), true
+}
+
 // d is the algorithm Step 2 definition of d.
 //
 // t = target index of "minimum" nonsmooth point
-// a = set of all existing locations
+// a = set of all existing locations, without antipodal pairs
 // c = set of all weights, parallel array to a.
 func d(t int, a []V, c []float64) V {
 	at := a[t]
@@ -364,26 +700,9 @@ func d(t int, a []V, c []float64) V {
 		if j == t {
 			continue
 		}
-		// stp cannot handle antipoles.
-		var ajat V
-		if aj.Equals(at.MulScalar(-1)) {
-			if len(a) == 2 {
-				// Arbitrary direction.
-				add := V{1, 0, 0}
-				if at.Equals(add) {
-					add = V{0, 1, 0}
-				}
-				ajat = stp(aj, at.Add(add).Unit())
-			} else {
-				continue
-			}
-		} else {
-			// ajat := ajx(aj, at)
-			ajat = stp(aj, at)
+		if dir, ok := tangentAwayFrom(aj, at); ok {
+			s = s.Add(dir.MulScalar(c[j]))
 		}
-		num := at.Sub(ajat)
-		denom := num.Norm()
-		s = s.Add(num.MulScalar(c[j]).DivScalar(denom))
 	}
 	return s.MulScalar(-1)
 }
@@ -394,19 +713,7 @@ func d(t int, a []V, c []float64) V {
 // a = set of all existing locations
 // c = set of all weights, parallel array to a.
 func dx(xk V, a []V, c []float64) V {
-	var s V
-	for j, aj := range a {
-		// stp cannot handle antipoles.
-		if aj.Equals(xk.MulScalar(-1)) {
-			continue
-		}
-		// ajxk := ajx(aj, xk)
-		ajxk := stp(aj, xk)
-		num := xk.Sub(ajxk)
-		denom := num.Norm()
-		s = s.Add(num.MulScalar(c[j]).DivScalar(denom))
-	}
-	return s.MulScalar(-1)
+	return gradFxk(xk, a, c).MulScalar(-1)
 }
 
 // alphax is the algorithms Step 3 definition of alphak.
@@ -417,13 +724,12 @@ func dx(xk V, a []V, c []float64) V {
 func alphax(xk V, a []V, c []float64) float64 {
 	s := 0.0
 	for j, aj := range a {
-		// stp cannot handle antipoles.
-		if aj.Equals(xk.MulScalar(-1)) {
+		// The distance is still defined at an antipode, but not at
+		// aj itself.
+		if isCoLocated(aj, xk) {
 			continue
 		}
-		// ajxk := ajx(aj, xk)
-		ajxk := stp(aj, xk)
-		s += c[j] / xk.Sub(ajxk).Norm()
+		s += c[j] / xk.GreatCircleDistance(aj)
 	}
 	return 1 / s
 }
@@ -432,15 +738,9 @@ func alphax(xk V, a []V, c []float64) float64 {
 func gradFxk(xk V, a []V, c []float64) V {
 	var s V
 	for j, aj := range a {
-		// stp cannot handle antipoles.
-		if aj.Equals(xk.MulScalar(-1)) {
-			continue
+		if dir, ok := tangentAwayFrom(aj, xk); ok {
+			s = s.Add(dir.MulScalar(c[j]))
 		}
-		// ajxk := ajx(aj, xk)
-		ajxk := stp(aj, xk)
-		num := xk.Sub(ajxk)
-		denom := num.Norm()
-		s = s.Add(num.MulScalar(c[j]).DivScalar(denom))
 	}
 	return s
 }
@@ -0,0 +1,208 @@
+package scr
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// degenerateTolerance is how close the dot product of two unit vectors
+	// must be to 1 (or -1) for them to be co-located (or antipodal).
+	degenerateTolerance = 1e-12
+	// collinearTolerance is how far from a great circle's plane a location
+	// may be while still lying on that great circle.
+	collinearTolerance = 1e-9
+	// tieTolerance is the relative difference in f(x) below which two
+	// optima are considered equivalent.
+	tieTolerance = 1e-9
+)
+
+// Degeneracy describes the ways a set of existing locations is degenerate for
+// the NEMFL solvers. It is a set of flags.
+type Degeneracy int
+
+const (
+	// Two or more existing locations were co-located and merged, summing
+	// their weights.
+	DegenerateDuplicates Degeneracy = 1 << iota
+	// Two existing locations were antipodal. The distances to an antipodal
+	// pair always sum to Pi, so the lighter weight of the pair is cancelled
+	// out of both as a constant.
+	DegenerateAntipodal
+	// The remaining existing locations lie on one great circle. The optimum
+	// is then always at one of them.
+	DegenerateCollinear
+	// Every antipodal pair had equal weights, so f(x) is constant and every
+	// point on the sphere is optimal.
+	DegenerateEverywhereOptimal
+	// Several existing locations are equally optimal.
+	DegenerateTiedOptima
+)
+
+func (d Degeneracy) String() string {
+	if d == 0 {
+		return "none"
+	}
+	var s []string
+	if d&DegenerateDuplicates != 0 {
+		s = append(s, "duplicates")
+	}
+	if d&DegenerateAntipodal != 0 {
+		s = append(s, "antipodal")
+	}
+	if d&DegenerateCollinear != 0 {
+		s = append(s, "collinear")
+	}
+	if d&DegenerateEverywhereOptimal != 0 {
+		s = append(s, "everywhere optimal")
+	}
+	if d&DegenerateTiedOptima != 0 {
+		s = append(s, "tied optima")
+	}
+	return strings.Join(s, ",")
+}
+
+func isCoLocated(a, b V) bool {
+	return a.Dot(b) > 1-degenerateTolerance
+}
+
+func isAntipodal(a, b V) bool {
+	return a.Dot(b) < -1+degenerateTolerance
+}
+
+// reducedLocations is an equivalent NEMFL problem without co-located
+// duplicates or antipodal pairs, which the smooth solver cannot handle.
+type reducedLocations struct {
+	locs    []V
+	weights []float64
+	// origIdx is the index of the first existing location each reduced
+	// location came from. It is increasing.
+	origIdx []int
+	deg     Degeneracy
+}
+
+// reduceDegenerateLocations merges co-located existing locations and cancels
+// out antipodal pairs, neither of which change where the optima are.
+func reduceDegenerateLocations(existingLocations []V, existingLocationWeights []float64) reducedLocations {
+	r := reducedLocations{
+		locs:    make([]V, 0, len(existingLocations)),
+		weights: make([]float64, 0, len(existingLocations)),
+		origIdx: make([]int, 0, len(existingLocations)),
+	}
+	for i, l := range existingLocations {
+		merged := false
+		for j, rl := range r.locs {
+			if isCoLocated(l, rl) {
+				r.weights[j] += existingLocationWeights[i]
+				r.deg |= DegenerateDuplicates
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			r.locs = append(r.locs, l)
+			r.weights = append(r.weights, existingLocationWeights[i])
+			r.origIdx = append(r.origIdx, i)
+		}
+	}
+	// Now that duplicates are merged, each location has at most one
+	// antipode.
+	for i := range r.locs {
+		for j := i + 1; j < len(r.locs); j++ {
+			if r.weights[i] > 0 && r.weights[j] > 0 && isAntipodal(r.locs[i], r.locs[j]) {
+				m := math.Min(r.weights[i], r.weights[j])
+				r.weights[i] -= m
+				r.weights[j] -= m
+				r.deg |= DegenerateAntipodal
+			}
+		}
+	}
+	n := 0
+	for i := range r.locs {
+		if r.weights[i] <= 0 {
+			continue
+		}
+		r.locs[n] = r.locs[i]
+		r.weights[n] = r.weights[i]
+		r.origIdx[n] = r.origIdx[i]
+		n++
+	}
+	r.locs = r.locs[:n]
+	r.weights = r.weights[:n]
+	r.origIdx = r.origIdx[:n]
+	if n == 0 {
+		r.deg |= DegenerateEverywhereOptimal
+	} else if n >= 3 && isGreatCircleCollinear(r.locs) {
+		r.deg |= DegenerateCollinear
+	}
+	return r
+}
+
+// isGreatCircleCollinear determines whether all locations lie on a single
+// great circle. There must be no antipodal pairs.
+func isGreatCircleCollinear(locs []V) bool {
+	var normal V
+	found := false
+	for _, l := range locs[1:] {
+		if !isCoLocated(l, locs[0]) {
+			normal = locs[0].Cross(l).Unit()
+			found = true
+			break
+		}
+	}
+	if !found {
+		return true
+	}
+	for _, l := range locs {
+		if math.Abs(normal.Dot(l)) > collinearTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// closedFormOptima returns the optima of degenerate problems that need no
+// iterative search, in the order of the existing locations they come from:
+//
+//   - With no remaining locations every point is optimal. The canonical
+//     choice is the first existing location, and it is the only optimum
+//     returned.
+//   - With at most two remaining locations, or all of them on one great
+//     circle, the optima returned are the remaining locations minimizing
+//     f(x). Points on the great circle between tied locations may be as good,
+//     but the locations are the canonical choice and no other points are
+//     returned.
+//
+// ok is false if the problem needs an iterative search.
+func (r reducedLocations) closedFormOptima(existingLocations []V) (optima []V, ok bool) {
+	if r.deg&DegenerateEverywhereOptimal != 0 {
+		return []V{existingLocations[0]}, true
+	}
+	if len(r.locs) > 2 && r.deg&DegenerateCollinear == 0 {
+		return nil, false
+	}
+	fx := make([]float64, len(r.locs))
+	for j := range r.locs {
+		fx[j], _ = geodesicDistancesFromI(j, r.locs, r.weights)
+	}
+	for _, j := range tiedMinima(fx) {
+		optima = append(optima, r.locs[j])
+	}
+	return optima, true
+}
+
+// tiedMinima returns, in increasing order, the indices of every value tied
+// for the minimum.
+func tiedMinima(fx []float64) []int {
+	min := math.Inf(1)
+	for _, f := range fx {
+		min = math.Min(min, f)
+	}
+	var idxs []int
+	for i, f := range fx {
+		if f-min <= tieTolerance*(1+math.Abs(min)) {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
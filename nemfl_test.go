@@ -1,6 +1,8 @@
 package scr
 
 import (
+	"context"
+	"errors"
 	"testing"
 )
 
@@ -65,11 +67,14 @@ func TestPaperExample1(t *testing.T) {
 		// weights[idx] = weights[idx] / 100
 	}
 	starting = starting.DivScalar(100)
-	actual := SolveNonEuclideanMultifacilityLocationSkipNonSmooth(
+	actual, err := SolveNonEuclideanMultifacilityLocationSkipNonSmooth(
 		existing,
 		weights,
 		0.0001, 0.0001,
 		starting)
+	if err != nil {
+		t.Fatal(err)
+	}
 	expected := V{43.8601, 51.4813, 73.6612}
 	expected = expected.DivScalar(100)
 	if !vWithinTolerance(actual, expected, 0.0001) {
@@ -90,11 +95,16 @@ func TestThreeOrthogonalPoints(t *testing.T) {
 		V{1, 0, 0},
 	}
 	weights := []float64{1, 1, 1}
-	actual := SolveNonEuclideanMultifacilityLocation(
+	actual, err := SolveNonEuclideanMultifacilityLocation(
 		existing,
 		weights,
 		0.0001, 0.0001)
-	expected := V{0.5773042039196745, -0.5774028380579979, 0.5773437613235639}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The start is random, so compare against the optimum equidistant from
+	// all three rather than one run's answer.
+	expected := V{1, -1, 1}.Unit()
 	if !vWithinTolerance(actual, expected, 0.0001) {
 		t.Fatalf("expected {%v, %v, %v}, got {%v, %v, %v} for tol=%v", expected.X, expected.Y, expected.Z, actual.X, actual.Y, actual.Z, 0.0001)
 	}
@@ -121,13 +131,119 @@ func TestEqualEverywhere(t *testing.T) {
 		V{1, 0, 0},
 	}
 	weights := []float64{1, 1, 1, 1, 1, 1}
-	actual := SolveNonEuclideanMultifacilityLocation(
+	actual, err := SolveNonEuclideanMultifacilityLocation(
 		existing,
 		weights,
 		0.0001, 0.0001)
-	expected := V{X: 0.396167579913271, Y: 0.7359459057790325, Z: -0.549030848307034}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every point is optimal, so the canonical first location is chosen.
+	expected := V{0, 0, -1}
 	if !vWithinTolerance(actual, expected, 0.0001) {
 		t.Fatalf("expected {%v, %v, %v}, got {%v, %v, %v} for tol=%v", expected.X, expected.Y, expected.Z, actual.X, actual.Y, actual.Z, 0.0001)
 	}
+}
+
+func TestDegenerateDuplicatesAndAntipodes(t *testing.T) {
+	existing := []V{
+		V{1, 0, 0},
+		V{-1, 0, 0},
+		V{0, 1, 0},
+		V{0, 1, 0},
+	}
+	weights := []float64{1, 1, 1, 1}
+	optima, deg, err := SolveNonEuclideanMultifacilityLocationAll(
+		existing,
+		weights,
+		0.0001, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deg&DegenerateDuplicates == 0 || deg&DegenerateAntipodal == 0 {
+		t.Fatalf("expected duplicates and antipodes, got %v", deg)
+	}
+	if len(optima) != 1 || !vWithinTolerance(optima[0], V{0, 1, 0}, 0.0001) {
+		t.Fatalf("expected [{0, 1, 0}], got %v", optima)
+	}
+}
+
+func TestDegenerateCollinearTiedOptima(t *testing.T) {
+	// Equally spaced 40 degrees apart along the equator
+	existing := []V{
+		V{1, 0, 0},
+		V{0.766044443118978, 0.6427876096865393, 0},
+		V{0.17364817766693041, 0.984807753012208, 0},
+		V{-0.5, 0.8660254037844387, 0},
+	}
+	weights := []float64{1, 1, 1, 1}
+	optima, deg, err := SolveNonEuclideanMultifacilityLocationAll(
+		existing,
+		weights,
+		0.0001, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deg&DegenerateCollinear == 0 || deg&DegenerateTiedOptima == 0 {
+		t.Fatalf("expected collinear tied optima, got %v", deg)
+	}
+	if len(optima) != 2 || !optima[0].Equals(existing[1]) || !optima[1].Equals(existing[2]) {
+		t.Fatalf("expected [%v %v], got %v", existing[1], existing[2], optima)
+	}
+}
+
+func TestDegenerateTiedExistingOptima(t *testing.T) {
+	const a = 0.9428090415820634 // 2*sqrt(2)/3
+	const b = 0.4714045207910317 // sqrt(2)/3
+	const c = 0.816496580927726  // sqrt(2/3)
+	// Regular tetrahedron
+	existing := []V{
+		V{0, 0, 1},
+		V{a, 0, -1.0 / 3},
+		V{-b, c, -1.0 / 3},
+		V{-b, -c, -1.0 / 3},
+	}
+	weights := []float64{1, 1, 1, 1}
+	optima, deg, err := SolveNonEuclideanMultifacilityLocationAll(
+		existing,
+		weights,
+		0.0001, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deg != DegenerateTiedOptima || len(optima) != len(existing) {
+		t.Fatalf("expected all %d locations to tie, got %v: %v", len(existing), deg, optima)
+	}
+}
 
+func TestBudgetExhausted(t *testing.T) {
+	existing := []V{
+		V{0, -1, 0},
+		V{0, 0, 1},
+		V{1, 0, 0},
+	}
+	weights := []float64{1, 1, 1}
+	actual, err := SolveNonEuclideanMultifacilityLocationContext(
+		context.Background(),
+		existing,
+		weights,
+		0.0001, 0.0001,
+		Budget{MaxIterations: 1})
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected %v, got %v", ErrBudgetExhausted, err)
+	}
+	if !fWithinTolerance(actual.Norm(), 1, 0.0001) {
+		t.Fatalf("expected the best iterate on the unit sphere, got %v", actual)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = SolveNonEuclideanMultifacilityLocationContext(
+		ctx,
+		existing,
+		weights,
+		0.0001, 0.0001,
+		Budget{})
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected %v, got %v", ErrBudgetExhausted, err)
+	}
 }
@@ -1,6 +1,9 @@
 package scr
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"math/rand"
 )
@@ -16,24 +19,48 @@ const (
 // And my fantasy is flying
 // It's a castle in the sky
 type Node struct {
+	// ID is stable for the lifetime of the node, unlike its Location.
+	ID NodeID
+	// PublicKey is set if the simulation gives nodes key pairs, from which
+	// their ID is derived.
+	PublicKey    ed25519.PublicKey
 	Location     V
 	S            State
 	NextS        State
-	CurrentBSize int
-	MaxBSize     int
 	WaitActivity float64
-	// Data is the slice into the global slice, for rapid lookup. Presence
-	// of Data does not indicate memory ownership.
-	Data []*Data
-	// DataIndices are a slice of indices into the Data slice that this node
-	// owns. It is preallocated to a fixed size, indicating a numerical
-	// limit the node can hold. The content of the data it holds can be
-	// accessed by:
-	//
-	//     Data[DataIndices[0:len(DataIndices)-1]]
-	//
-	// If the resulting value is nil, then it is empty
-	DataIndices []int
+	// SolverBudget bounds each computation of this node's location, so
+	// that a pathological set of data cannot stall a tick.
+	SolverBudget Budget
+	// SolverWorkers is the number of goroutines each computation of this
+	// node's location runs its restarts across, at least 1. SolverSeed
+	// seeds them: each computation draws from its own source derived from
+	// it, so that the node moves the same way for the same seed, unless it
+	// is 0.
+	SolverWorkers int
+	SolverSeed    int64
+	// solverCtx cancels computations of this node's location, if it is
+	// not nil.
+	solverCtx context.Context
+	// SolverTrace, if set, receives each iterate of the computations of
+	// this node's location, to see how it came to be where it is. Nodes
+	// with virtual positions are not traced.
+	SolverTrace TraceFn
+	// The class this node was created as.
+	Class *NodeClass
+	// Host is where the node sits in the physical network, as opposed to
+	// its Location.
+	Host Host
+	// The bytes of Data this node may upload and download each tick, 0 for
+	// no limit, and has uploaded and downloaded in all.
+	UploadCap   int
+	DownloadCap int
+	Uploaded    int
+	Downloaded  int
+	// Store holds the Data of this node, and limits how much it may hold.
+	// Virtual positions share the Store of their node.
+	Store Store
+	// The Data of the node's Store clustered at this virtual position.
+	assigned []*Data
 	// This node's known peers
 	peers PeerList
 	// The f(X) value for this node (lower = closer to its data)
@@ -42,110 +69,415 @@ type Node struct {
 	fxsq float64
 	// Number of data pieces that go into the f(X) calculation
 	nfx int
+	// The virtual positions this node presents instead of its Location,
+	// if any. See NewVirtualNode.
+	virtuals []*Node
+	// The node presenting this virtual position, if this is one.
+	parent *Node
+	// Ticks spent in StateLeave, and the pieces of Data handed off to peers
+	// during them.
+	leavingTicks int
+	handedOff    int
+	// Whether this node has left and is to be removed from the simulation,
+	// and the tick it was removed.
+	departed     bool
+	departedTick int
+	// The current tick, and the last time each peer was heard from.
+	now      int
+	liveness map[*Node]*peerLiveness
+	// The location last announced to peers, and the tick peers' locations
+	// were last requested.
+	announcedLocation V
+	lastPull          int
+	// The tick this node's session ends, if it is not 0.
+	sessionEnd int
+	// The tick this node was created, and the number of times it has tried
+	// to join.
+	createdTick  int
+	joinAttempts int
+	// Messages delivered and not yet handled, requests awaiting a response
+	// by Seq, and the Data offered in those requests.
+	inbox   []*Message
+	pending map[int]*Message
+	offered map[*Data]bool
+	// Offers that timed out by Seq, whose late acknowledgements are still
+	// honoured for as long again.
+	expiredOffers map[int]expiredOffer
+	// The peers that would not take each piece of Data handed off.
+	handOffRefused map[*Data][]*Node
+	// Data this node let go of, to be counted as lost if it turns out no
+	// other node holds it.
+	released []*Data
+	// Bytes of Data uploaded and downloaded this tick.
+	uploadedTick   int
+	downloadedTick int
+	// Computations of this node's location cut short by its SolverBudget
+	// since they were last counted, and seeded from its SolverSeed in all.
+	budgetExhausted int
+	nSolves         int
+	// The attack this node is part of, if it is an attacker.
+	sybil *SybilAttack
+	// The tick a peer was last verified, and the nodes that failed
+	// verification by this one.
+	lastVerify int
+	distrusted map[*Node]bool
+	// The tick a peer was last challenged, the Data last given to peers
+	// that may be challenged for, and the outcomes of challenges by peer.
+	lastChallenge int
+	given         []givenData
+	proofs        map[*Node]*proofStats
+	// The peer each piece of Data this node let go of went to, by address,
+	// and the addresses oldest first.
+	movedTo    map[string]*Node
+	movedOrder []string
 }
 
 // NewNodes begin at a random location if they have no data. Otherwise, they
 // begin at a predetermined location based on the data they possess.
 func NewNode(
-	dataCache []*Data,
-	myIdxs []int,
-	maxBSize int,
+	store Store,
 	waitActivity float64,
+	solverBudget Budget,
 	peerList PeerList) *Node {
 	n := &Node{
 		S:            State{id: StateJoin},
-		Data:         dataCache,
-		DataIndices:  myIdxs,
-		MaxBSize:     maxBSize,
+		Store:        store,
 		WaitActivity: waitActivity,
+		SolverBudget: solverBudget,
 		peers:        peerList,
 	}
-	n.computeLocationAndCurrentSize()
+	n.computeLocation()
 	return n
 }
 
-func (n *Node) getDataLocations() []V {
-	locs := make([]V, 0, len(n.DataIndices))
-	for _, idx := range n.DataIndices {
-		if n.Data[idx] == nil {
-			continue
+// held is the Data at this position: that clustered at it if it is a virtual
+// position, or else all the node stores.
+func (n *Node) held() []*Data {
+	if n.parent == nil {
+		return n.Store.List()
+	}
+	held := make([]*Data, 0, len(n.assigned))
+	for _, d := range n.assigned {
+		// Data let go of since it was clustered is no longer held.
+		if _, err := n.parent.Store.Get(d.Address); err == nil {
+			held = append(held, d)
 		}
-		locs = append(locs, n.Data[idx].Location)
+	}
+	return held
+}
+
+func (n *Node) getDataLocations() []V {
+	held := n.held()
+	locs := make([]V, 0, len(held))
+	for _, d := range held {
+		locs = append(locs, d.Location)
 	}
 	return locs
 }
 
-func (n *Node) computeLocationAndCurrentSize() {
-	bsz := 0
-	locs := make([]V, 0, len(n.DataIndices))
-	weights := make([]float64, 0, len(n.DataIndices))
-	hasData := false
-	for _, idx := range n.DataIndices {
-		if n.Data[idx] == nil {
-			continue
-		}
-		hasData = true
-		locs = append(locs, n.Data[idx].Location)
+func (n *Node) computeLocation() {
+	if n.parent != nil {
+		n.parent.computeLocation()
+		return
+	}
+	held := n.Store.List()
+	locs := make([]V, 0, len(held))
+	weights := make([]float64, 0, len(held))
+	for _, d := range held {
+		locs = append(locs, d.Location)
 		weights = append(weights, 1)
-		bsz += n.Data[idx].DataSize
-	}
-	if hasData {
-		var err error
-		var fx float64
-		var fxsq float64
-		var nfx int
-		n.Location, fx, fxsq, nfx, err = SolveNonEuclideanMultifacilityLocationMonteCarlo(
+	}
+	hasData := len(held) > 0
+	if len(n.virtuals) > 0 {
+		n.computeVirtualLocations(locs, weights, held)
+	} else if hasData {
+		loc, fx, fxsq, nfx, err := SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(
+			n.solverContext(),
 			locs,
 			weights,
 			0.1, 0.1,
-			2)
+			2,
+			n.SolverWorkers,
+			n.solverSeed(),
+			n.SolverBudget,
+			n.SolverTrace)
+		if errors.Is(err, ErrBudgetExhausted) {
+			// Settle for the best location found so far.
+			n.budgetExhausted++
+			err = nil
+		}
 		if err != nil {
-			// TODO: Yikes!
-			panic(err)
+			// Stay put rather than halt the simulation, unless this
+			// node has never had a location.
+			loc = n.Location
+			if loc.Equals(V{}) {
+				loc = locs[0]
+			}
+			fx, fxsq = geodesicDistances(loc, locs, weights)
+			nfx = len(locs)
 		}
+		n.Location = loc
 		n.fx = fx
 		n.fxsq = fxsq
 		n.nfx = nfx
 	} else {
-		fmt.Printf("RandomVector location: nIdx=%v\n", len(n.DataIndices))
-		n.Location = RandomVector()
+		n.Location = RandomVectorFrom(rand.New(rand.NewSource(n.solverSeed())))
 		n.fx = 0
 		n.fxsq = 0
 		n.nfx = 0
 	}
-	n.CurrentBSize = bsz
 }
 
-func (n *Node) ifWaitOrJoin(f func()) bool {
-	if n.S.id == StateWait || n.S.id == StateJoin {
-		f()
-		return true
+// solverContext bounds computations of this node's location.
+func (n *Node) solverContext() context.Context {
+	if n.solverCtx == nil {
+		return context.Background()
+	}
+	return n.solverCtx
+}
+
+// solverSeed seeds the next computation of this node's location, from its
+// SolverSeed unless that is 0.
+func (n *Node) solverSeed() int64 {
+	if n.SolverSeed == 0 {
+		return rand.Int63()
+	}
+	n.nSolves++
+	return deriveSeed(n.SolverSeed, n.nSolves)
+}
+
+// request sends a message expecting a response, which the node awaits until
+// the request times out.
+func (n *Node) request(c Coordinator, m *Message) {
+	c.Send(m)
+	p := n.physical()
+	if p.pending == nil {
+		p.pending = make(map[int]*Message)
+	}
+	p.pending[m.Seq] = m
+}
+
+// awaiting is whether the node awaits a response to a request of the kind.
+func (n *Node) awaiting(kind MessageKind) bool {
+	for _, m := range n.physical().pending {
+		if m.Kind == kind {
+			return true
+		}
 	}
 	return false
 }
 
-func (n *Node) ifWaitOrJoinBool(f func() bool) (executed bool, val bool) {
-	if n.S.id == StateWait || n.S.id == StateJoin {
-		return true, f()
+// expiredOffer is a MsgData request given up on at a tick.
+type expiredOffer struct {
+	m    *Message
+	tick int
+}
+
+// expireRequests gives up on requests that have not been answered within
+// timeout ticks, counting each as a failure of the peer asked. It returns the
+// number given up on.
+func (n *Node) expireRequests(now, timeout int) (expired int) {
+	for seq, e := range n.expiredOffers {
+		if now-e.tick >= timeout {
+			delete(n.expiredOffers, seq)
+		}
+	}
+	for seq, m := range n.pending {
+		// Requests still being transferred have yet to be sent.
+		if m.remaining > 0 || now-m.sentTick < timeout {
+			continue
+		}
+		delete(n.pending, seq)
+		if m.Kind == MsgData {
+			delete(n.offered, m.Data)
+			if m.HandOff {
+				n.refuseHandOff(m.Data, m.To.physical())
+			}
+			if n.expiredOffers == nil {
+				n.expiredOffers = make(map[int]expiredOffer)
+			}
+			n.expiredOffers[seq] = expiredOffer{m: m, tick: now}
+		}
+		m.From.peerFailed(m.To)
+		expired++
+	}
+	return
+}
+
+// handleMessages handles the messages delivered to the node, in the order
+// they arrived. Responses that arrive after their request expired are still
+// handled, but acknowledgements of Data only for a while after, and only
+// those matching an offer. It tallies the peer locations learned on request, the
+// verifications finished if v is not nil, and the proofs checked if ch is not
+// nil.
+func (n *Node) handleMessages(c Coordinator, v *Verification, ch *Challenges) (tally Counters) {
+	inbox := n.inbox
+	n.inbox = nil
+	for _, m := range inbox {
+		var req *Message
+		if !m.Kind.isRequest() {
+			req = n.pending[m.Seq]
+			delete(n.pending, m.Seq)
+		}
+		r := m.To
+		switch m.Kind {
+		case MsgHello:
+			// NODE INTERACTION: PEER HELLO
+			//
+			// May not be a mutual add.
+			if n.S.id == StateLeave {
+				continue
+			}
+			r.addPeerAt(m.From, m.Location)
+			c.Send(m.reply(MsgHelloAck))
+		case MsgHelloAck:
+			r.addPeerAt(m.From, m.Location)
+		case MsgPeerRequest:
+			// NODE INTERACTION: REQUEST PEER
+			c.Send(r.answerPeerRequest(m))
+		case MsgPeerResponse:
+			r.peerResponded(m)
+		case MsgData:
+			// NODE INTERACTION: EXCHANGE DATA
+			c.Send(r.exchangeDataReceive(m))
+		case MsgDataAck:
+			if req == nil {
+				if e, ok := n.expiredOffers[m.Seq]; ok {
+					req = e.m
+					delete(n.expiredOffers, m.Seq)
+				}
+			}
+			// Otherwise any node could have this one forget its Data,
+			// or push Data on it that is no closer to it.
+			if req == nil || req.Kind != MsgData || req.Data != m.Data || req.To.physical() != m.From.physical() {
+				// The sender gave up any swap all the same, so it is
+				// lost unless another node holds it.
+				if m.Accepted && m.Swap != nil {
+					n.released = append(n.released, m.Swap)
+				}
+				continue
+			}
+			r.exchangeDataAcknowledged(m)
+		case MsgLocation:
+			// NODE INTERACTION: LOCATION ANNOUNCEMENT
+			if r.peers.UpdatePeerLocation(m.From, m.Location) {
+				r.peerSeen(m.From)
+			}
+		case MsgLocationRequest:
+			// NODE INTERACTION: LOCATION REQUEST
+			c.Send(m.reply(MsgLocationResponse))
+		case MsgLocationResponse:
+			if r.peers.UpdatePeerLocation(m.From, m.Location) {
+				r.peerSeen(m.From)
+				tally.LocationsPulled++
+			}
+		case MsgClaimRequest:
+			// NODE INTERACTION: CLAIM REQUEST
+			c.Send(r.answerClaim(m))
+		case MsgClaimResponse:
+			if v != nil && r.claimAnswered(c, m, v) {
+				tally.VerificationsUnverifiable++
+			}
+		case MsgSampleRequest:
+			// NODE INTERACTION: SAMPLE REQUEST
+			c.Send(r.answerSample(m))
+		case MsgSampleResponse:
+			// A sample that arrives after its request expired was
+			// already counted against the peer.
+			if v == nil || req == nil {
+				continue
+			}
+			if r.sampleAnswered(req, m, v) {
+				tally.VerificationsPassed++
+			} else {
+				tally.VerificationsFailed++
+			}
+		case MsgChallenge:
+			// NODE INTERACTION: STORAGE CHALLENGE
+			c.Send(r.answerChallenge(m))
+		case MsgChallengeResponse:
+			if ch == nil || req == nil {
+				continue
+			}
+			switch r.challengeAnswered(req, m, ch) {
+			case proofPassed:
+				tally.ProofsPassed++
+			case proofFailed:
+				tally.ProofsFailed++
+			case proofMoved:
+				tally.ProofsMoved++
+			}
+		}
+	}
+	return
+}
+
+// addPeerAt adds a peer that was last heard from at loc.
+func (n *Node) addPeerAt(o *Node, loc V) {
+	// Virtual positions of the same node are not peers.
+	if o.physical() == n.physical() || n.distrusts(o) {
+		return
+	}
+	if n.peers.AddPeer(n.Location, o) {
+		n.peers.UpdatePeerLocation(o, loc)
+		n.peerSeen(o)
 	}
-	return false, false
 }
 
-func (n *Node) addPeer(o *Node) {
-	n.peers.AddPeer(n.Location, o)
+// introducePeerAt adds a peer that this node has only heard of, without
+// vouching that it is online.
+func (n *Node) introducePeerAt(o *Node, loc V) {
+	if o.physical() == n.physical() || n.distrusts(o) {
+		return
+	}
+	if n.peers.AddPeer(n.Location, o) {
+		n.peers.UpdatePeerLocation(o, loc)
+		if _, ok := n.liveness[o]; !ok {
+			n.peerSeen(o)
+		}
+	}
 }
 
-func (n *Node) requestPeer() {
+func (n *Node) requestPeer(c Coordinator) {
 	o := n.peers.GetRandomPeer()
 	if o == nil {
 		return
 	}
-	peer := o.getPeerFor(n)
-	if peer != nil {
-		// NODE INTERACTION: PEER HELLO
-		if o.ifWaitOrJoin(func() { o.addPeer(n) }) {
-			n.addPeer(peer)
+	n.request(c, &Message{
+		Kind:     MsgPeerRequest,
+		From:     n,
+		To:       o,
+		Location: n.Location,
+	})
+}
+
+// answerPeerRequest answers with a peer other than the requester, adding the
+// requester as a peer in turn if there was one to give.
+func (n *Node) answerPeerRequest(m *Message) *Message {
+	r := m.reply(MsgPeerResponse)
+	if a := n.physical().sybil; a != nil {
+		// Attackers only give away each other.
+		if peer := a.colluder(n); peer != nil {
+			n.addPeerAt(m.From, m.Location)
+			r.Peer = peer
+			r.PeerLocation = peer.Location
 		}
+		return r
+	}
+	peer := n.getPeerFor(m.From)
+	if peer != nil {
+		n.addPeerAt(m.From, m.Location)
+		r.Peer = peer
+		r.PeerLocation, _ = n.peers.PeerLocation(peer)
+	}
+	return r
+}
+
+func (n *Node) peerResponded(m *Message) {
+	n.peerSeen(m.From)
+	n.peers.UpdatePeerLocation(m.From, m.Location)
+	if m.Peer != nil {
+		n.introducePeerAt(m.Peer, m.PeerLocation)
 	}
 }
 
@@ -153,150 +485,236 @@ func (n *Node) getPeerFor(o *Node) *Node {
 	return n.peers.GetRandomPeerThatsNot(o)
 }
 
-func (n *Node) exchangeData() (s string) {
-	o, dataIdx := n.peers.RandomlyFindPeerCloserToData(n.Location, n.Data, n.DataIndices)
-	if o == nil && dataIdx < 0 {
+func (n *Node) exchangeData(c Coordinator) (s string) {
+	// Data already offered is not offered again until answered.
+	p := n.physical()
+	held := n.held()
+	if len(p.offered) > 0 {
+		kept := held[:0]
+		for _, d := range held {
+			if !p.offered[d] {
+				kept = append(kept, d)
+			}
+		}
+		held = kept
+	}
+	o, d := n.peers.RandomlyFindPeerCloserToData(n.Location, held)
+	if o == nil && d == nil {
 		s = "could not exchange data (no peers)"
 		return
 	}
-	// dataIdx is data to transfer if >= 0; otherwise return
+	// d is data to transfer if not nil; otherwise return
 	// if peer says "ok" then we forget our reference
-	if dataIdx < 0 {
+	if d == nil {
 		s = "could not exchange data (no closer data)"
 		return
 	}
-	exec, ok := o.ifWaitOrJoinBool(func() bool { return o.exchangeDataReceive(n.Data[dataIdx]) })
-	if !exec {
-		s = fmt.Sprintf("unsuccessfully exchanged data at index %d to peer %v", dataIdx, o.Location)
+	// Peers that fail challenges are given Data less often.
+	if rate := p.passRate(o); rate < 1 && rand.Float64() >= rate {
+		s = fmt.Sprintf("could not exchange data (peer %s fails challenges)", o)
 		return
 	}
-	if ok {
-		n.exchangeDataGive(dataIdx)
-		s = fmt.Sprintf("exchanged data at index %d to peer %v", dataIdx, o.Location)
-	}
-	// Exchange locations after data exchange
-	o.addPeer(n) // Not wrapped since exec succeeded before
-	n.addPeer(o)
+	n.offerData(c, o, d, false)
+	s = fmt.Sprintf("offered data %s to peer %s", d, o)
 	return
 }
 
-func (n *Node) exchangeDataReceive(d *Data) bool {
-	// Too big of data
-	if d.DataSize+n.CurrentBSize > n.MaxBSize {
-		return false
+// offerData sends the Data to the peer o, keeping it until o acknowledges
+// taking it.
+func (n *Node) offerData(c Coordinator, o *Node, d *Data, handOff bool) {
+	p := n.physical()
+	if p.offered == nil {
+		p.offered = make(map[*Data]bool)
 	}
-	availIdx := -1
-	for _, idx := range n.DataIndices {
-		if n.Data[idx] == nil {
-			availIdx = idx
-			break
-		}
+	p.offered[d] = true
+	_, used := p.Store.Used()
+	_, capacity := p.Store.Capacity()
+	n.request(c, &Message{
+		Kind:     MsgData,
+		From:     n,
+		To:       o,
+		Location: n.Location,
+		Data:     d,
+		BSize:    used,
+		MaxBSize: capacity,
+		HandOff:  handOff,
+	})
+}
+
+// exchangeDataReceive takes the offered Data if there is room for it, or
+// otherwise swaps it for Data of its own, unless it is handed off. A leaving
+// node takes nothing, and no node takes Data it is offering to another.
+func (n *Node) exchangeDataReceive(m *Message) *Message {
+	r := m.reply(MsgDataAck)
+	r.Data = m.Data
+	r.HandOff = m.HandOff
+	if n.physical().S.id == StateLeave {
+		return r
+	}
+	if a := n.physical().sybil; a != nil {
+		// Attackers take everything, and keep none of it.
+		a.capture(m)
+		r.Accepted = true
+	} else if n.physical().offered[m.Data] {
+		// It is already held, and on offer to another node: were that
+		// node to take it too, neither would be left holding it.
+	} else if n.receive(m.Data) {
+		r.Accepted = true
+	} else if !m.HandOff {
+		r.Swap = n.exchangeDataSwap(m)
+		r.Accepted = r.Swap != nil
+	}
+	if !m.HandOff {
+		// Exchange locations after data exchange
+		n.addPeerAt(m.From, m.Location)
+	}
+	r.Location = n.Location
+	return r
+}
+
+// receive stores the Data, if the node has the capacity for it. Data the node
+// already holds is not stored twice.
+func (n *Node) receive(d *Data) bool {
+	// Virtual positions share the capacity of their node.
+	n = n.physical()
+	if n.holds(d) {
+		return true
 	}
-	// Too much qty of data
-	if availIdx < 0 {
+	if !n.keep(d) {
 		return false
 	}
-	n.Data[availIdx] = d
-	n.computeLocationAndCurrentSize()
+	n.computeLocation()
 	return true
 }
 
-func (n *Node) exchangeDataGive(idx int) {
-	n.Data[idx] = nil
-	n.computeLocationAndCurrentSize()
+// exchangeDataSwap is for when this node cannot simply receive the offered
+// Data: it takes it in return for one of its own that sits closer to the
+// sender, which is returned to be sent back. Of those that reduce the
+// objective of both nodes, at the sender's location when it made the offer,
+// and keep both within the capacity of their stores, the one reducing the sum
+// the most is swapped. Recomputing the locations afterwards only reduces each
+// objective further.
+func (n *Node) exchangeDataSwap(m *Message) *Data {
+	d := m.Data
+	// Virtual positions share the capacity of their node.
+	to := n.physical()
+	senderDist := m.Location.GreatCircleDistance(d.Location)
+	receiverDist := n.Location.GreatCircleDistance(d.Location)
+	_, used := to.Store.Used()
+	_, capacity := to.Store.Capacity()
+	var swap *Data
+	maxGain := 0.0
+	for _, e := range n.held() {
+		senderGain := senderDist - m.Location.GreatCircleDistance(e.Location)
+		receiverGain := n.Location.GreatCircleDistance(e.Location) - receiverDist
+		if senderGain <= 0 || receiverGain <= 0 {
+			continue
+		}
+		if (m.MaxBSize >= 0 && m.BSize-d.DataSize+e.DataSize > m.MaxBSize) ||
+			(capacity >= 0 && used-e.DataSize+d.DataSize > capacity) {
+			continue
+		}
+		if gain := senderGain + receiverGain; swap == nil || gain > maxGain {
+			swap = e
+			maxGain = gain
+		}
+	}
+	if swap == nil {
+		return nil
+	}
+	to.forget(swap)
+	if !to.keep(d) {
+		// The store would not take it after all.
+		to.keep(swap)
+		return nil
+	}
+	to.movedData(m.From, swap.Address)
+	to.computeLocation()
+	return swap
 }
 
-func (n *Node) nextFreeDataIndex() int {
-	availIdx := -1
-	for _, idx := range n.DataIndices {
-		if n.Data[idx] == nil {
-			availIdx = idx
-			break
+// exchangeDataAcknowledged forgets the offered Data if the peer took it,
+// keeping whatever was swapped for it in its place. A leaving node's location
+// is not recomputed.
+func (n *Node) exchangeDataAcknowledged(m *Message) {
+	p := n.physical()
+	delete(p.offered, m.Data)
+	if m.HandOff {
+		n.peerSeen(m.From)
+		if !m.Accepted {
+			p.refuseHandOff(m.Data, m.From.physical())
+		}
+	} else {
+		n.addPeerAt(m.From, m.Location)
+	}
+	if !m.Accepted {
+		return
+	}
+	// The peer may have gone offline since taking it.
+	if p.holds(m.Data) {
+		p.forget(m.Data)
+		p.movedData(m.From, m.Data.Address)
+		p.released = append(p.released, m.Data)
+		if !m.HandOff {
+			p.gave(m.From, m.Data)
 		}
 	}
-	return availIdx
+	// The swap fits in the room the offered Data left, unless an earlier
+	// acknowledgement already took it.
+	if m.Swap != nil && !p.holds(m.Swap) && !p.keep(m.Swap) {
+		p.released = append(p.released, m.Swap)
+	}
+	if m.HandOff {
+		p.handedOff++
+	} else {
+		p.computeLocation()
+	}
 }
 
-func (n *Node) applyNewData(idx int) {
-	d := n.Data[idx]
-	// Too big of data -- remove it
-	if d.DataSize+n.CurrentBSize > n.MaxBSize {
-		n.Data[idx] = nil
+// keep puts the Data in the node's Store, returning false if it does not fit.
+func (n *Node) keep(d *Data) bool {
+	if n.Store.Put(d) != nil {
+		return false
 	}
-	n.computeLocationAndCurrentSize()
+	d.holders++
+	return true
 }
 
-type coordinator interface {
-	FindOtherArbitraryNode(*Node) *Node
+// forget deletes the Data from the node's Store.
+func (n *Node) forget(d *Data) {
+	if n.Store.Delete(d.Address) == nil {
+		d.holders--
+	}
 }
 
-func (n *Node) ApplyState(c coordinator) string {
-	switch n.S.id {
-	case StateJoin:
-		o := c.FindOtherArbitraryNode(n)
-		s := "did not find other arbitrary node"
-		if o != nil {
-			// Exchange location information as well.
-			// May not be a mutual add.
-			//
-			// NODE INTERACTION: PEER HELLO
-			if o.ifWaitOrJoin(func() { o.addPeer(n) }) {
-				s = "found other arbitrary node"
-				n.addPeer(o)
-			}
-		}
-		n.NextS = State{
-			id:        StateWait,
-			lastState: StateJoin,
-		}
-		return fmt.Sprintf("Node at %s joined and %s", n.Location, s)
-	case StateWait:
-		// Chance of the node spontaneously doing an action. We simply
-		// attempt to alternate through actions.
-		if rand.Float64() < n.WaitActivity {
-			if n.S.lastState == StateExchangeData {
-				n.NextS = State{
-					id:        StateAskPeer,
-					lastState: StateWait,
-				}
-				return fmt.Sprintf("Node at %s will attempt asking peer", n.Location)
-			} else {
-				n.NextS = State{
-					id:        StateExchangeData,
-					lastState: StateWait,
-				}
-				return fmt.Sprintf("Node at %s will attempt exchange", n.Location)
-			}
-		} else {
-			n.NextS = State{
-				id:        StateWait,
-				lastState: StateWait,
-			}
-			return fmt.Sprintf("Node at %s waited", n.Location)
-		}
-	case StateExchangeData:
-		// Try exchanging data with a neighbor closer
-		// to that data's location
-		//
-		// NODE INTERACTION: EXCHANGE DATA
-		s := n.exchangeData()
-		n.NextS = State{
-			id:        StateWait,
-			lastState: StateExchangeData,
-		}
-		return fmt.Sprintf("Node at %s %s", n.Location, s)
-	case StateAskPeer:
-		// Try asking for a peer
-		//
-		// NODE INTERACTION: REQUEST PEER
-		n.requestPeer()
-		n.NextS = State{
-			id:        StateWait,
-			lastState: StateAskPeer,
-		}
-		return fmt.Sprintf("Node at %s asked peer", n.Location)
+// holds is whether the node's Store holds the Data.
+func (n *Node) holds(d *Data) bool {
+	e, err := n.Store.Get(d.Address)
+	return err == nil && e == d
+}
+
+// Coordinator is what a node's states may ask of the simulation running it.
+type Coordinator interface {
+	// BootstrapNode returns nil if bootstrapping failed.
+	BootstrapNode(*Node) *Node
+	// StateHandler returns nil if no state has the identifier.
+	StateHandler(id int) StateHandler
+	// Send puts the message on the network, numbering it if it is a
+	// request.
+	Send(*Message)
+}
+
+func (n *Node) ApplyState(c Coordinator) string {
+	h := c.StateHandler(n.S.id)
+	if h == nil {
+		return fmt.Sprintf("Unknown action: %v", n.S)
+	}
+	next, summary := h.Apply(n, c)
+	n.NextS = State{
+		id:        next,
+		lastState: n.S.id,
 	}
-	return fmt.Sprintf("Unknown action: %v", n.S)
+	return summary
 }
 
 func (n *Node) AdvanceState() {
@@ -307,23 +725,32 @@ func (n *Node) CountLastState(m []int) {
 	m[n.NextS.lastState] += 1
 }
 
-func (n *Node) PeerLocations() []V {
-	return n.peers.Locations()
+// CountTransition increments m[from][to] for the transition just applied.
+func (n *Node) CountTransition(m [][]int) {
+	m[n.NextS.lastState][n.NextS.id] += 1
 }
 
-// removePeer is used by Tocker via Simulation
-func (n *Node) removePeer(o *Node) {
-	n.peers.RemovePeer(o)
+func (n *Node) PeerLocations() []V {
+	if len(n.virtuals) == 0 {
+		return n.peers.Locations()
+	}
+	var locs []V
+	for _, p := range n.virtuals {
+		locs = append(locs, p.peers.Locations()...)
+	}
+	return locs
 }
 
-type State struct {
-	id        int
-	lastState int
+// iterateOverPeersWith calls f with the node of every peer known by any of
+// this node's positions.
+func (n *Node) iterateOverPeersWith(f func(*Node)) {
+	for _, p := range n.positions() {
+		p.peers.IterateOverPeersWith(func(o *Node) {
+			if o == nil {
+				f(o)
+				return
+			}
+			f(o.physical())
+		})
+	}
 }
-
-const (
-	StateJoin int = iota
-	StateWait
-	StateExchangeData
-	StateAskPeer
-)
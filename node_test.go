@@ -0,0 +1,147 @@
+package scr
+
+import (
+	"testing"
+)
+
+func TestNodeCountsBudgetExhausted(t *testing.T) {
+	store := NewMemoryStore(-1, -1)
+	n := NewNode(store, 1, Budget{MaxIterations: 1}, NewMaximizePeerSpread(8))
+	// Data no existing location is optimal for, so the smooth search runs.
+	for _, loc := range []V{{0, 0.6, 0.8}, {0.6, 0, 0.8}, {0, -0.6, 0.8}, {-0.6, 0, 0.8}} {
+		if !n.keep(&Data{Address: Address(loc.String()), Location: loc}) {
+			t.Fatal("expected the store to take the Data")
+		}
+	}
+	n.computeLocation()
+	if n.budgetExhausted != 1 {
+		t.Fatalf("expected 1 computation cut short, got %d", n.budgetExhausted)
+	}
+	n.SolverBudget = Budget{}
+	n.computeLocation()
+	if n.budgetExhausted != 1 {
+		t.Fatalf("expected no more computations cut short, got %d", n.budgetExhausted)
+	}
+}
+
+func TestNodeExchangeDataSwap(t *testing.T) {
+	sender := V{0, 0, 1}
+	receiver := V{1, 0, 0}
+	// The Data offered is as far from the sender as the receiver is.
+	offeredAt := V{0.9, 0.3, 0}.Unit()
+	nearSender := V{0.1, 0, 0.9}.Unit()
+	// Closer to the sender than the Data offered, but also closer to the
+	// receiver.
+	nearReceiver := V{0.95, 0, 0.1}.Unit()
+	// Farther from the sender than the Data offered.
+	farFromSender := V{1, 0.3, -0.5}.Unit()
+	tests := []struct {
+		name string
+		// Where the receiver's own Data is, and the sizes of it and of
+		// the Data offered.
+		own         V
+		ownSize     int
+		offeredSize int
+		// The most bytes the receiver and the sender may hold.
+		maxBytes       int
+		senderMaxBytes int
+		handOff        bool
+		expectSwap     bool
+	}{
+		{"both closer", nearSender, 1, 1, -1, -1, false, true},
+		{"receiver not closer", nearReceiver, 1, 1, -1, -1, false, false},
+		{"sender not closer", farFromSender, 1, 1, -1, -1, false, false},
+		{"sender full", nearSender, 2, 1, -1, 1, false, false},
+		{"receiver full", nearSender, 1, 1, 1, -1, false, true},
+		{"receiver too small", nearSender, 1, 3, 2, -1, false, false},
+		{"hand off", nearSender, 1, 1, -1, -1, true, false},
+	}
+	for _, test := range tests {
+		// The receiver has room for only its own Data.
+		n := NewNode(NewMemoryStore(1, test.maxBytes), 1, Budget{}, NewMaximizePeerSpread(8))
+		own := &Data{Address: Address("own"), Location: test.own, DataSize: test.ownSize}
+		if !n.keep(own) {
+			t.Fatalf("%s: expected the store to take its own Data", test.name)
+		}
+		n.Location = receiver
+		from := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+		offered := &Data{Address: Address("offered"), Location: offeredAt, DataSize: test.offeredSize}
+		r := n.exchangeDataReceive(&Message{
+			Kind:     MsgData,
+			From:     from,
+			To:       n,
+			Location: sender,
+			Data:     offered,
+			BSize:    test.offeredSize,
+			MaxBSize: test.senderMaxBytes,
+			HandOff:  test.handOff,
+		})
+		if swapped := r.Swap == own; swapped != test.expectSwap || r.Accepted != test.expectSwap {
+			t.Fatalf("%s: expected swap %v, got swap %v accepted %v", test.name, test.expectSwap, r.Swap, r.Accepted)
+		}
+		if n.holds(offered) != test.expectSwap || n.holds(own) == test.expectSwap {
+			t.Fatalf("%s: expected the receiver to hold the offered Data %v and its own %v, got %v and %v",
+				test.name, test.expectSwap, !test.expectSwap, n.holds(offered), n.holds(own))
+		}
+		if test.expectSwap && (offered.holders != 1 || own.holders != 0) {
+			t.Fatalf("%s: expected the swap to move holders, got %d and %d", test.name, offered.holders, own.holders)
+		}
+	}
+}
+
+func TestNodeReleasesSwapOfUnmatchedAck(t *testing.T) {
+	s := newIdleSimulation(2, 0, 1)
+	n, o := s.NodeCache[0], s.NodeCache[1]
+	n.Store = NewMemoryStore(-1, -1)
+	swap := testData("pushed", n.Location)
+	n.inbox = []*Message{{
+		Kind:     MsgDataAck,
+		From:     o,
+		To:       n,
+		Accepted: true,
+		Data:     testData("never offered", o.Location),
+		Swap:     swap,
+	}}
+	n.handleMessages(s, nil, nil)
+	if n.holds(swap) || len(n.released) != 1 || n.released[0] != swap {
+		t.Fatalf("expected the swap of an ack for no offer released, got held %v and %d released", n.holds(swap), len(n.released))
+	}
+}
+
+func TestNodeSolverSeed(t *testing.T) {
+	locate := func(nWorkers int, seed int64, locs []V) []V {
+		n := NewNode(NewMemoryStore(-1, -1), 1, Budget{}, NewMaximizePeerSpread(8))
+		n.SolverWorkers = nWorkers
+		n.SolverSeed = seed
+		for _, loc := range locs {
+			if !n.keep(&Data{Address: Address(loc.String()), Location: loc}) {
+				t.Fatal("expected the store to take the Data")
+			}
+		}
+		// Each computation draws from a source of its own.
+		var moves []V
+		for i := 0; i < 3; i++ {
+			n.computeLocation()
+			moves = append(moves, n.Location)
+		}
+		return moves
+	}
+	locs := []V{{0, 0.6, 0.8}, {0.6, 0, 0.8}, {0, -0.6, 0.8}, {-0.6, 0, 0.8}}
+	for _, test := range []struct {
+		name string
+		locs []V
+	}{
+		{"no data", nil},
+		{"data", locs},
+	} {
+		expected := locate(1, 42, test.locs)
+		for _, nWorkers := range []int{1, 2, 4} {
+			actual := locate(nWorkers, 42, test.locs)
+			for i := range expected {
+				if !actual[i].Equals(expected[i]) {
+					t.Fatalf("%s: expected %s with %d workers, got %s", test.name, expected[i], nWorkers, actual[i])
+				}
+			}
+		}
+	}
+}
@@ -8,9 +8,13 @@ type PeerList interface {
 	Locations() []V // For visualization only
 	RemovePeer(o *Node)
 	AddPeer(loc V, o *Node) bool
+	// UpdatePeerLocation returns false if o is not a peer.
+	UpdatePeerLocation(o *Node, loc V) bool
+	// PeerLocation is where this list believes the peer o is.
+	PeerLocation(o *Node) (V, bool)
 	GetRandomPeer() *Node
 	GetRandomPeerThatsNot(o *Node) *Node
-	RandomlyFindPeerCloserToData(loc V, data []*Data, indices []int) (peer *Node, idx int)
+	RandomlyFindPeerCloserToData(loc V, data []*Data) (peer *Node, d *Data)
 	IterateOverPeersWith(func(*Node))
 }
 
@@ -39,6 +43,7 @@ func (p *basePeerList) Locations() []V {
 func (p *basePeerList) RemovePeer(o *Node) {
 	if i, ok := p.uniqueIdx[o]; ok {
 		p.peers[i] = p.peers[len(p.peers)-1]
+		p.uniqueIdx[p.peers[i]] = i
 		p.peers[len(p.peers)-1] = nil
 		p.peers = p.peers[:len(p.peers)-1]
 		p.peerLocations[i] = p.peerLocations[len(p.peerLocations)-1]
@@ -62,6 +67,21 @@ func (p *basePeerList) AddPeer(loc V, o *Node) bool {
 	return false
 }
 
+func (p *basePeerList) UpdatePeerLocation(o *Node, loc V) bool {
+	if i, ok := p.uniqueIdx[o]; ok {
+		p.peerLocations[i] = loc
+		return true
+	}
+	return false
+}
+
+func (p *basePeerList) PeerLocation(o *Node) (V, bool) {
+	if i, ok := p.uniqueIdx[o]; ok {
+		return p.peerLocations[i], true
+	}
+	return V{}, false
+}
+
 func (p *basePeerList) GetRandomPeer() *Node {
 	if len(p.peers) == 0 {
 		return nil
@@ -70,6 +90,9 @@ func (p *basePeerList) GetRandomPeer() *Node {
 }
 
 func (p *basePeerList) GetRandomPeerThatsNot(o *Node) *Node {
+	if len(p.peers) == 0 {
+		return nil
+	}
 	for i := 0; i < getPeerForMaxTries; i++ {
 		idx := rand.Intn(len(p.peers))
 		if p := p.peers[idx]; p != o {
@@ -83,24 +106,19 @@ func (p *basePeerList) length() int {
 	return len(p.peers)
 }
 
-func (p *basePeerList) RandomlyFindPeerCloserToData(loc V, data []*Data, indices []int) (peer *Node, idx int) {
+func (p *basePeerList) RandomlyFindPeerCloserToData(loc V, data []*Data) (peer *Node, d *Data) {
 	if len(p.peers) == 0 {
-		return nil, -1
+		return nil, nil
 	}
 	// Randomly begin asking peers
 	offset := rand.Intn(len(p.peers))
 	quit := false
 	i := offset
-	dataIdx := -1
 	for !quit {
 		// See if they're closer to an address.
-		for _, d := range indices {
-			data := data[d]
-			if data == nil {
-				continue
-			}
-			if loc.GreatCircleDistance(data.Location) > p.peerLocations[i].GreatCircleDistance(data.Location) {
-				dataIdx = d
+		for _, e := range data {
+			if loc.GreatCircleDistance(e.Location) > p.peerLocations[i].GreatCircleDistance(e.Location) {
+				d = e
 				quit = true
 				break
 			}
@@ -117,7 +135,7 @@ func (p *basePeerList) RandomlyFindPeerCloserToData(loc V, data []*Data, indices
 			quit = true
 		}
 	}
-	return p.peers[i], dataIdx
+	return p.peers[i], d
 }
 
 func (p *basePeerList) IterateOverPeersWith(f func(*Node)) {
@@ -267,6 +285,17 @@ func (p *maxSpreadThenClosestNeighbors) AddPeer(loc V, o *Node) bool {
 	return ok
 }
 
+func (p *maxSpreadThenClosestNeighbors) UpdatePeerLocation(o *Node, loc V) bool {
+	return p.M.UpdatePeerLocation(o, loc) || p.C.UpdatePeerLocation(o, loc)
+}
+
+func (p *maxSpreadThenClosestNeighbors) PeerLocation(o *Node) (V, bool) {
+	if loc, ok := p.M.PeerLocation(o); ok {
+		return loc, true
+	}
+	return p.C.PeerLocation(o)
+}
+
 func (p *maxSpreadThenClosestNeighbors) GetRandomPeer() *Node {
 	if p.M.length() > 0 && p.C.length() > 0 {
 		i := rand.Intn(2)
@@ -295,16 +324,16 @@ func (p *maxSpreadThenClosestNeighbors) GetRandomPeerThatsNot(o *Node) *Node {
 	return p.C.GetRandomPeerThatsNot(o)
 }
 
-func (p *maxSpreadThenClosestNeighbors) RandomlyFindPeerCloserToData(loc V, data []*Data, indices []int) (peer *Node, idx int) {
+func (p *maxSpreadThenClosestNeighbors) RandomlyFindPeerCloserToData(loc V, data []*Data) (peer *Node, d *Data) {
 	if p.M.length() > 0 && p.C.length() > 0 {
-		peer, idx = p.M.RandomlyFindPeerCloserToData(loc, data, indices)
-		if idx < 0 {
-			peer, idx = p.C.RandomlyFindPeerCloserToData(loc, data, indices)
+		peer, d = p.M.RandomlyFindPeerCloserToData(loc, data)
+		if d == nil {
+			peer, d = p.C.RandomlyFindPeerCloserToData(loc, data)
 		}
 	} else if p.M.length() > 0 {
-		peer, idx = p.M.RandomlyFindPeerCloserToData(loc, data, indices)
+		peer, d = p.M.RandomlyFindPeerCloserToData(loc, data)
 	} else {
-		peer, idx = p.C.RandomlyFindPeerCloserToData(loc, data, indices)
+		peer, d = p.C.RandomlyFindPeerCloserToData(loc, data)
 	}
 	return
 }
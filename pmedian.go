@@ -0,0 +1,149 @@
+package scr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	maxPMedianIter = 20
+)
+
+// SolveSphericalPMedian partitions the existing locations into at most p
+// clusters, and finds the NEMFL optimum of each as its center. It alternates
+// between assigning each existing location to its closest center and solving
+// for each cluster's center, until the assignment no longer changes.
+//
+// assignment is a parallel array of existingLocations, indexing into centers.
+// fx and fxsq are the sum over all clusters.
+//
+// The search of each cluster runs its restarts across nWorkers goroutines. The
+// initial centers and the search of each cluster draw from their own sources
+// seeded from seed, so the result is identical for a given seed, whatever the
+// number of workers.
+//
+// It is bounded by a context and a budget. The time is shared by all
+// clusters, and the iterations bound each search of each cluster. If either
+// runs out, the clusters so far are returned with an error wrapping
+// ErrBudgetExhausted.
+func SolveSphericalPMedian(
+	ctx context.Context,
+	existingLocations []V,
+	existingLocationWeights []float64,
+	p int,
+	nonsmoothTolerance, smoothTolerance float64,
+	nWorkers int,
+	seed int64,
+	budget Budget) (centers []V, assignment []int, fx, fxsq float64, err error) {
+	if len(existingLocations) == 0 || p < 1 {
+		return nil, nil, 0, 0, fmt.Errorf("No p-median solution")
+	}
+	ctx, cancel := budget.withContext(ctx)
+	defer cancel()
+	centers = initialPMedianCenters(rand.New(rand.NewSource(deriveSeed(seed, 0))), existingLocations, p)
+	assignment = make([]int, len(existingLocations))
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	for iter := 0; iter < maxPMedianIter; iter++ {
+		// Allocate
+		changed := false
+		for j, l := range existingLocations {
+			c := closestCenter(l, centers)
+			if c != assignment[j] {
+				assignment[j] = c
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// Locate
+		fx, fxsq = 0, 0
+		for c := range centers {
+			locs, weights := cluster(c, assignment, existingLocations, existingLocationWeights)
+			if len(locs) == 0 {
+				continue
+			}
+			var cfx, cfxsq float64
+			var cerr error
+			centers[c], cfx, cfxsq, _, cerr = SolveNonEuclideanMultifacilityLocationMonteCarloParallel(
+				ctx,
+				locs,
+				weights,
+				nonsmoothTolerance, smoothTolerance,
+				2,
+				nWorkers,
+				deriveSeed(seed, c+1),
+				Budget{MaxIterations: budget.MaxIterations})
+			if cerr != nil && !errors.Is(cerr, ErrBudgetExhausted) {
+				return nil, nil, 0, 0, cerr
+			} else if cerr != nil {
+				err = cerr
+			}
+			fx += cfx
+			fxsq += cfxsq
+		}
+		if err != nil {
+			break
+		}
+	}
+	// Centers moved after the last allocation, so recompute f(x).
+	fx, fxsq = 0, 0
+	for j, l := range existingLocations {
+		v := l.GreatCircleDistance(centers[assignment[j]]) * existingLocationWeights[j]
+		fx += v
+		fxsq += v * v
+	}
+	return
+}
+
+// initialPMedianCenters picks p distinct existing locations as the initial
+// centers: one drawn from r, then each time the one farthest from the centers
+// already picked, so that clusters far apart start with a center each. There
+// are fewer than p if there are fewer distinct existing locations.
+func initialPMedianCenters(r *rand.Rand, existingLocations []V, p int) []V {
+	centers := make([]V, 0, p)
+	centers = append(centers, existingLocations[r.Intn(len(existingLocations))])
+	for len(centers) < p {
+		farthest, max := -1, 0.0
+		for j, l := range existingLocations {
+			c := centers[closestCenter(l, centers)]
+			if isCoLocated(l, c) {
+				continue
+			}
+			if d := l.GreatCircleDistance(c); farthest < 0 || d > max {
+				farthest, max = j, d
+			}
+		}
+		if farthest < 0 {
+			break
+		}
+		centers = append(centers, existingLocations[farthest])
+	}
+	return centers
+}
+
+func closestCenter(l V, centers []V) int {
+	idx := 0
+	min := l.GreatCircleDistance(centers[0])
+	for c := 1; c < len(centers); c++ {
+		if d := l.GreatCircleDistance(centers[c]); d < min {
+			min = d
+			idx = c
+		}
+	}
+	return idx
+}
+
+func cluster(c int, assignment []int, existingLocations []V, existingLocationWeights []float64) (locs []V, weights []float64) {
+	for j, a := range assignment {
+		if a == c {
+			locs = append(locs, existingLocations[j])
+			weights = append(weights, existingLocationWeights[j])
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package scr
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSphericalPMedianSeparatesClusters(t *testing.T) {
+	existing := []V{
+		V{0, 0.1, 1}.Unit(),
+		V{0.1, 0, 1}.Unit(),
+		V{0, 0, 1},
+		V{0, 0.1, -1}.Unit(),
+		V{0.1, 0, -1}.Unit(),
+		V{0, 0, -1},
+	}
+	weights := []float64{1, 1, 1, 1, 1, 1}
+	centers, assignment, fx, _, err := SolveSphericalPMedian(
+		context.Background(),
+		existing,
+		weights,
+		2,
+		0.0001, 0.0001,
+		1,
+		1,
+		Budget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(centers) != 2 {
+		t.Fatalf("expected 2 centers, got %v", centers)
+	}
+	for j := range existing {
+		if assignment[j] != assignment[j/3*3] {
+			t.Fatalf("expected the north and south points in separate clusters, got %v", assignment)
+		}
+	}
+	if assignment[0] == assignment[3] {
+		t.Fatalf("expected the north and south points in separate clusters, got %v", assignment)
+	}
+	if !fWithinTolerance(fx, Objective(centers[assignment[0]], existing[:3], weights[:3])+Objective(centers[assignment[3]], existing[3:], weights[3:]), 0.0001) {
+		t.Fatalf("expected fx to sum the clusters, got %v", fx)
+	}
+}
+
+func TestSphericalPMedianDeterministicForSeed(t *testing.T) {
+	existing := make([]V, 30)
+	weights := make([]float64, len(existing))
+	for j := range existing {
+		existing[j] = RandomVector()
+		weights[j] = 1
+	}
+	centers, assignment, fx, _, err := SolveSphericalPMedian(context.Background(), existing, weights, 3, 0.0001, 0.0001, 1, 42, Budget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nWorkers := range []int{1, 2, 4} {
+		c, a, f, _, err := SolveSphericalPMedian(context.Background(), existing, weights, 3, 0.0001, 0.0001, nWorkers, 42, Budget{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, centers) || !reflect.DeepEqual(a, assignment) || f != fx {
+			t.Fatalf("expected %v %v %v for the same seed with %d workers, got %v %v %v", centers, assignment, fx, nWorkers, c, a, f)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package scr
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CoordinateFormat is how locations are written in a file of points.
+type CoordinateFormat int
+
+const (
+	// x,y,z coordinates, scaled onto the unit sphere when read.
+	CoordinatesXYZ CoordinateFormat = iota
+	// Latitude and longitude, in degrees.
+	CoordinatesLatLon
+)
+
+// ParseCoordinateFormat parses "xyz" or "latlon".
+func ParseCoordinateFormat(s string) (CoordinateFormat, error) {
+	switch s {
+	case "xyz":
+		return CoordinatesXYZ, nil
+	case "latlon":
+		return CoordinatesLatLon, nil
+	}
+	return 0, fmt.Errorf("unknown coordinates %q", s)
+}
+
+// ReadWeightedLocationsFile reads existing locations and their weights from
+// the named file, as "csv" or "json" according to format, or to the file's
+// extension if format is empty.
+func ReadWeightedLocationsFile(name, format string, c CoordinateFormat) (locs []V, weights []float64, err error) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+	if format != "csv" && format != "json" {
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if format == "json" {
+		return ReadWeightedLocationsJSON(f, c)
+	}
+	return ReadWeightedLocationsCSV(f, c)
+}
+
+// ReadWeightedLocations reads existing locations and their weights from CSV
+// records of the form:
+//
+//	x,y,z[,weight]
+//
+// See ReadWeightedLocationsCSV.
+func ReadWeightedLocations(r io.Reader) (locs []V, weights []float64, err error) {
+	return ReadWeightedLocationsCSV(r, CoordinatesXYZ)
+}
+
+// ReadWeightedLocationsCSV reads existing locations and their weights from CSV
+// records of the form:
+//
+//	x,y,z[,weight]
+//	lat,lon[,weight]
+//
+// depending on the coordinate format. A missing weight is 1. Lines beginning
+// with '#' are ignored, as is a first record with no numbers in it, which is
+// taken as a header.
+func ReadWeightedLocationsCSV(r io.Reader, c CoordinateFormat) (locs []V, weights []float64, err error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	nCoords := 3
+	if c == CoordinatesLatLon {
+		nCoords = 2
+	}
+	for i, rec := range records {
+		if i == 0 && isHeader(rec) {
+			continue
+		}
+		if len(rec) < nCoords || len(rec) > nCoords+1 {
+			return nil, nil, fmt.Errorf("record %d: expected %d or %d fields but got %d", i+1, nCoords, nCoords+1, len(rec))
+		}
+		f := make([]float64, len(rec))
+		for j, field := range rec {
+			f[j], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("record %d: %s", i+1, err)
+			}
+		}
+		var p weightedPoint
+		if c == CoordinatesLatLon {
+			p.Lat, p.Lon = &f[0], &f[1]
+		} else {
+			p.X, p.Y, p.Z = &f[0], &f[1], &f[2]
+		}
+		if len(rec) > nCoords {
+			p.Weight = &f[nCoords]
+		}
+		v, w, err := p.location(c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("record %d: %s", i+1, err)
+		}
+		locs = append(locs, v)
+		weights = append(weights, w)
+	}
+	if len(locs) == 0 {
+		return nil, nil, fmt.Errorf("no locations")
+	}
+	return
+}
+
+// isHeader is whether none of a record's fields are numbers.
+func isHeader(rec []string) bool {
+	for _, field := range rec {
+		if _, err := strconv.ParseFloat(field, 64); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadWeightedLocationsJSON reads existing locations and their weights from a
+// JSON array of objects of the form:
+//
+//	{"x": 0, "y": 0, "z": 1, "weight": 1}
+//	{"lat": 90, "lon": 0, "weight": 1}
+//
+// depending on the coordinate format. A missing weight is 1.
+func ReadWeightedLocationsJSON(r io.Reader, c CoordinateFormat) (locs []V, weights []float64, err error) {
+	var points []weightedPoint
+	if err = json.NewDecoder(r).Decode(&points); err != nil {
+		return nil, nil, err
+	}
+	for i, p := range points {
+		v, w, err := p.location(c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("point %d: %s", i, err)
+		}
+		locs = append(locs, v)
+		weights = append(weights, w)
+	}
+	if len(locs) == 0 {
+		return nil, nil, fmt.Errorf("no locations")
+	}
+	return
+}
+
+type weightedPoint struct {
+	X      *float64 `json:"x"`
+	Y      *float64 `json:"y"`
+	Z      *float64 `json:"z"`
+	Lat    *float64 `json:"lat"`
+	Lon    *float64 `json:"lon"`
+	Weight *float64 `json:"weight"`
+}
+
+// location returns the point's location and weight, which is 1 if missing.
+func (p weightedPoint) location(c CoordinateFormat) (v V, weight float64, err error) {
+	for _, f := range []*float64{p.X, p.Y, p.Z, p.Lat, p.Lon, p.Weight} {
+		if f != nil && (math.IsNaN(*f) || math.IsInf(*f, 0)) {
+			return V{}, 0, fmt.Errorf("%v is not a finite number", *f)
+		}
+	}
+	weight = 1
+	if p.Weight != nil {
+		weight = *p.Weight
+	}
+	if weight <= 0 {
+		return V{}, 0, fmt.Errorf("weight must be positive")
+	}
+	if c == CoordinatesLatLon {
+		if p.Lat == nil || p.Lon == nil {
+			return V{}, 0, fmt.Errorf("missing lat or lon")
+		}
+		if *p.Lat < -90 || *p.Lat > 90 {
+			return V{}, 0, fmt.Errorf("lat %v is out of range", *p.Lat)
+		}
+		return LatLonToV(*p.Lat, *p.Lon), weight, nil
+	}
+	if p.X == nil || p.Y == nil || p.Z == nil {
+		return V{}, 0, fmt.Errorf("missing x, y or z")
+	}
+	v = V{X: *p.X, Y: *p.Y, Z: *p.Z}
+	if norm := v.Norm(); norm == 0 {
+		return V{}, 0, fmt.Errorf("location is at the origin")
+	} else if math.IsInf(norm, 0) {
+		return V{}, 0, fmt.Errorf("location is too far from the origin")
+	}
+	return v.Unit(), weight, nil
+}
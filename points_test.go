@@ -0,0 +1,81 @@
+package scr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadWeightedLocationsCSV(t *testing.T) {
+	in := "# comment\nlat,lon,weight\n90,0\n0,90,2\n"
+	locs, weights, err := ReadWeightedLocationsCSV(strings.NewReader(in), CoordinatesLatLon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []V{V{0, 0, 1}, V{0, 1, 0}}
+	expectedWeights := []float64{1, 2}
+	if len(locs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, locs)
+	}
+	for i := range expected {
+		if !vWithinTolerance(locs[i], expected[i], 0.0001) || weights[i] != expectedWeights[i] {
+			t.Fatalf("expected %v weighted %v, got %v weighted %v", expected[i], expectedWeights[i], locs[i], weights[i])
+		}
+	}
+}
+
+func TestReadWeightedLocationsJSON(t *testing.T) {
+	in := `[{"x": 0, "y": 0, "z": 2}, {"x": 3, "y": 0, "z": 0, "weight": 0.5}]`
+	locs, weights, err := ReadWeightedLocationsJSON(strings.NewReader(in), CoordinatesXYZ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []V{V{0, 0, 1}, V{1, 0, 0}}
+	expectedWeights := []float64{1, 0.5}
+	if len(locs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, locs)
+	}
+	for i := range expected {
+		if !locs[i].Equals(expected[i]) || weights[i] != expectedWeights[i] {
+			t.Fatalf("expected %v weighted %v, got %v weighted %v", expected[i], expectedWeights[i], locs[i], weights[i])
+		}
+	}
+}
+
+func TestReadWeightedLocationsRejectsZeroWeight(t *testing.T) {
+	if _, _, err := ReadWeightedLocationsCSV(strings.NewReader("90,0,0\n"), CoordinatesLatLon); err == nil {
+		t.Fatalf("expected an error for a CSV weight of 0")
+	}
+	in := `[{"lat": 90, "lon": 0, "weight": 0}]`
+	if _, _, err := ReadWeightedLocationsJSON(strings.NewReader(in), CoordinatesLatLon); err == nil {
+		t.Fatalf("expected an error for a JSON weight of 0")
+	}
+}
+
+func TestReadWeightedLocationsCSVBadFirstRecord(t *testing.T) {
+	// Only a record with no numbers is a header; this one is a typo.
+	in := "90,0x\n0,90\n"
+	if _, _, err := ReadWeightedLocationsCSV(strings.NewReader(in), CoordinatesLatLon); err == nil {
+		t.Fatalf("expected an error for a malformed first record")
+	}
+}
+
+func TestReadWeightedLocationsRejectsNonFinite(t *testing.T) {
+	tests := []struct {
+		in string
+		c  CoordinateFormat
+	}{
+		{"NaN,0\n", CoordinatesLatLon},
+		{"0,Inf\n", CoordinatesLatLon},
+		{"90,0,NaN\n", CoordinatesLatLon},
+		{"90,0,+Inf\n", CoordinatesLatLon},
+		{"1,NaN,0\n", CoordinatesXYZ},
+		{"-Inf,0,0\n", CoordinatesXYZ},
+		{"1,0,0,NaN\n", CoordinatesXYZ},
+		{"1e300,1e300,1e300\n", CoordinatesXYZ},
+	}
+	for _, test := range tests {
+		if locs, _, err := ReadWeightedLocationsCSV(strings.NewReader(test.in), test.c); err == nil {
+			t.Fatalf("expected an error for %q, got %v", test.in, locs)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package scr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+)
+
+const (
+	// The most Data given away a node remembers to challenge for.
+	maxGivenData = 16
+	// The most Data let go of a node remembers the new holder of, to name
+	// when challenged for it.
+	maxMovedData = 256
+	nonceSize    = 16
+)
+
+// DataRetention is what is kept of the bytes of created Data, which storage
+// challenges need.
+type DataRetention int
+
+const (
+	// DiscardData keeps only the size, address and location of Data.
+	DiscardData DataRetention = iota
+	// RetainData keeps the bytes of every Data.
+	RetainData
+	// RegenerateData keeps a seed per Data, numbered as it is created, from
+	// which its bytes are generated again when needed.
+	RegenerateData
+)
+
+// regenerateData is the same size bytes for the same seed.
+func regenerateData(seed int64, size int) []byte {
+	b := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// proveStorage is H(nonce‖b).
+func proveStorage(nonce, b []byte) []byte {
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// Challenges has nodes check that the peers they gave Data to still store it.
+// A node asks such a peer for the hash of a random nonce followed by a random
+// range of the Data's bytes, which only a node holding the bytes can compute.
+// The expected answer is computed from the Data's bytes, as the giver would
+// have precomputed challenges before letting it go.
+//
+// A peer that no longer holds the Data, as Data moves on to nodes closer to
+// it, is not penalised if it names the node it gave the Data to, which is
+// challenged for it in its place from then on. A peer that cannot name one, or
+// answers with the wrong proof, fails. Peers are given Data less often the
+// more of their challenges they fail, and one that fails MaxFailures
+// challenges, and more than it passed, is distrusted: it is dropped from every
+// peer list of the challenger, and never added again.
+type Challenges struct {
+	// Interval is the ticks between a node challenging one of its peers.
+	Interval int
+	// RangeLength is the most bytes of the Data a proof covers.
+	RangeLength int
+	// MaxFailures is the failed challenges after which a peer that failed
+	// more than it passed is distrusted.
+	MaxFailures int
+}
+
+// givenData is Data given to a peer.
+type givenData struct {
+	peer *Node
+	d    *Data
+}
+
+// proofStats are the outcomes of the challenges of a peer.
+type proofStats struct {
+	passed int
+	failed int
+}
+
+type proofOutcome int
+
+const (
+	proofPassed proofOutcome = iota
+	proofFailed
+	proofMoved
+)
+
+// gave remembers that the peer took the Data, to challenge it later.
+func (n *Node) gave(o *Node, d *Data) {
+	if d.bytes == nil && d.seed == 0 {
+		return
+	}
+	n.given = append(n.given, givenData{peer: o, d: d})
+	if len(n.given) > maxGivenData {
+		n.given = n.given[1:]
+	}
+}
+
+// movedData remembers that the Data at addr went to the peer o.
+func (n *Node) movedData(o *Node, addr Address) {
+	if n.movedTo == nil {
+		n.movedTo = make(map[string]*Node)
+	}
+	k := string(addr)
+	if _, ok := n.movedTo[k]; !ok {
+		n.movedOrder = append(n.movedOrder, k)
+		if len(n.movedOrder) > maxMovedData {
+			delete(n.movedTo, n.movedOrder[0])
+			n.movedOrder = n.movedOrder[1:]
+		}
+	}
+	n.movedTo[k] = o
+}
+
+// challengePeer asks a random peer that was given Data for a proof that it
+// still stores it, once every interval ticks.
+func (n *Node) challengePeer(c Coordinator, now int, ch *Challenges) {
+	if n.lastChallenge == 0 {
+		// Spread the challenges of nodes over the interval.
+		n.lastChallenge = now - rand.Intn(ch.Interval)
+	}
+	if now-n.lastChallenge < ch.Interval || len(n.given) == 0 {
+		return
+	}
+	n.lastChallenge = now
+	g := n.given[rand.Intn(len(n.given))]
+	if n.distrusts(g.peer) {
+		return
+	}
+	b := g.d.Bytes()
+	length := ch.RangeLength
+	if length <= 0 || length > len(b) {
+		length = len(b)
+	}
+	offset := rand.Intn(len(b) - length + 1)
+	nonce := make([]byte, nonceSize)
+	_, _ = rand.Read(nonce)
+	// NODE INTERACTION: STORAGE CHALLENGE
+	n.request(c, &Message{
+		Kind:     MsgChallenge,
+		From:     n.randomPosition(),
+		To:       g.peer,
+		Location: n.Location,
+		Address:  g.d.Address,
+		Nonce:    nonce,
+		Offset:   offset,
+		Length:   length,
+		expected: proveStorage(nonce, b[offset:offset+length]),
+	})
+}
+
+// answerChallenge proves this node stores the Data challenged for, if its
+// Store still holds it, or otherwise names the peer it gave the Data to.
+func (n *Node) answerChallenge(m *Message) *Message {
+	r := m.reply(MsgChallengeResponse)
+	p := n.physical()
+	if p.sybil != nil {
+		// Attackers keep nothing, and claim it all moved on.
+		return r
+	}
+	b, err := p.Store.Read(m.Address)
+	if err != nil {
+		r.Peer = p.movedTo[string(m.Address)]
+		return r
+	}
+	if m.Offset < 0 || m.Length < 0 || m.Offset+m.Length > len(b) {
+		return r
+	}
+	r.Proof = proveStorage(m.Nonce, b[m.Offset:m.Offset+m.Length])
+	return r
+}
+
+// challengeAnswered checks the proof against the one expected by the
+// challenge req, distrusting the peer if it has failed too often.
+func (n *Node) challengeAnswered(req, m *Message, ch *Challenges) proofOutcome {
+	p := n.physical()
+	o := m.From.physical()
+	n.peerSeen(m.From)
+	if m.Proof == nil && m.Peer != nil {
+		// The Data has moved on, so challenge where it went instead.
+		p.followGiven(o, m.Peer, req.Address)
+		return proofMoved
+	}
+	if p.proofs == nil {
+		p.proofs = make(map[*Node]*proofStats)
+	}
+	st, ok := p.proofs[o]
+	if !ok {
+		st = &proofStats{}
+		p.proofs[o] = st
+	}
+	if m.Proof != nil && bytes.Equal(m.Proof, req.expected) {
+		st.passed++
+		return proofPassed
+	}
+	st.failed++
+	if st.failed >= ch.MaxFailures && st.failed > st.passed {
+		p.distrust(o)
+	}
+	return proofFailed
+}
+
+// passRate is the fraction of challenges the peer o passed, with a pass
+// counted in its favour so that a peer never challenged has a rate of 1.
+func (n *Node) passRate(o *Node) float64 {
+	st, ok := n.physical().proofs[o.physical()]
+	if !ok {
+		return 1
+	}
+	return float64(st.passed+1) / float64(st.passed+st.failed+1)
+}
+
+// followGiven challenges the peer to for the Data at addr given to o, in
+// place of o, unless the Data came back to this node.
+func (n *Node) followGiven(o, to *Node, addr Address) {
+	if to.physical() == n {
+		n.forgetGiven(o, addr)
+		return
+	}
+	for i, g := range n.given {
+		if g.peer.physical() == o && bytes.Equal(g.d.Address, addr) {
+			n.given[i].peer = to
+		}
+	}
+}
+
+// forgetGiven stops challenging the peer for the Data at addr.
+func (n *Node) forgetGiven(o *Node, addr Address) {
+	kept := n.given[:0]
+	for _, g := range n.given {
+		if g.peer.physical() != o || !bytes.Equal(g.d.Address, addr) {
+			kept = append(kept, g)
+		}
+	}
+	n.given = kept
+}
@@ -0,0 +1,129 @@
+package scr
+
+import (
+	"strings"
+	"testing"
+)
+
+// retainedData is Data whose bytes are kept, for challenges to prove.
+func retainedData(content string) *Data {
+	b := []byte(content)
+	d := NewData(b)
+	d.bytes = b
+	return d
+}
+
+// newChallenge has a giver that gave Data to a peer, and a third node the
+// peer may have given it on to. Challenges are only made when asked for.
+func newChallenge(t *testing.T) (s *Simulation, giver, peer, next *Node, d *Data) {
+	s = newIdleSimulation(3, 0, 1)
+	s.Challenges = &Challenges{RangeLength: 8, MaxFailures: 2}
+	giver, peer, next = s.NodeCache[0], s.NodeCache[1], s.NodeCache[2]
+	for _, n := range s.NodeCache {
+		n.Store = NewMemoryStore(-1, -1)
+	}
+	d = retainedData("the bytes a challenge proves are stored")
+	giver.addPeerAt(peer, peer.Location)
+	giver.gave(peer, d)
+	return
+}
+
+// challenge has the giver challenge the peer it gave Data to, and runs the
+// simulation until the proof is checked.
+func challenge(s *Simulation, giver *Node) {
+	giver.lastChallenge = -1
+	giver.challengePeer(s, s.TickN, &Challenges{Interval: 1, RangeLength: s.Challenges.RangeLength})
+	runTicks(s, s.TickN+1, s.TickN+3)
+}
+
+func TestChallenges(t *testing.T) {
+	tests := []struct {
+		name string
+		// What the peer holds, and where it says the Data went if it
+		// does not.
+		held         func(d *Data) *Data
+		movedToNext  bool
+		expectPassed int
+		expectFailed int
+		expectMoved  int
+	}{
+		{"stored", func(d *Data) *Data { return d }, false, 1, 0, 0},
+		{"wrong bytes", func(d *Data) *Data {
+			return &Data{Address: d.Address, Location: d.Location, DataSize: d.DataSize, bytes: make([]byte, d.DataSize)}
+		}, false, 0, 1, 0},
+		{"named move", nil, true, 0, 0, 1},
+		{"unexplained move", nil, false, 0, 1, 0},
+	}
+	for _, test := range tests {
+		s, giver, peer, next, d := newChallenge(t)
+		if test.held != nil && !peer.keep(test.held(d)) {
+			t.Fatalf("%s: expected the store to take the Data", test.name)
+		}
+		if test.movedToNext {
+			peer.movedData(next, d.Address)
+			next.keep(d)
+		}
+		challenge(s, giver)
+		if s.ProofsPassed != test.expectPassed || s.ProofsFailed != test.expectFailed || s.ProofsMoved != test.expectMoved {
+			t.Fatalf("%s: expected %d passed, %d failed and %d moved, got %d, %d and %d", test.name,
+				test.expectPassed, test.expectFailed, test.expectMoved, s.ProofsPassed, s.ProofsFailed, s.ProofsMoved)
+		}
+		if !test.movedToNext {
+			continue
+		}
+		// The Data is followed to where it went.
+		if len(giver.given) != 1 || giver.given[0].peer != next {
+			t.Fatalf("%s: expected the giver to challenge the next holder instead", test.name)
+		}
+		challenge(s, giver)
+		if s.ProofsPassed != 1 || s.ProofsFailed != 0 {
+			t.Fatalf("%s: expected the next holder to pass, got %d passed and %d failed", test.name, s.ProofsPassed, s.ProofsFailed)
+		}
+	}
+}
+
+func TestChallengesDistrustFailingPeer(t *testing.T) {
+	s, giver, peer, _, _ := newChallenge(t)
+	challenge(s, giver)
+	if giver.distrusts(peer) || giver.passRate(peer) != 0.5 {
+		t.Fatalf("expected one failure to halve the pass rate without distrust, got %v", giver.passRate(peer))
+	}
+	challenge(s, giver)
+	if !giver.distrusts(peer) || s.ProofsFailed != 2 {
+		t.Fatalf("expected the peer distrusted after %d failures, got %d", s.Challenges.MaxFailures, s.ProofsFailed)
+	}
+}
+
+func TestChallengesPassRateWeightsExchanges(t *testing.T) {
+	const tries = 2000
+	for _, test := range []struct {
+		name           string
+		passed, failed int
+		min, max       int
+	}{
+		{"never challenged", 0, 0, tries, tries},
+		{"always passes", 4, 0, tries, tries},
+		// A rate of (0+1)/(0+3+1).
+		{"fails", 0, 3, tries / 5, tries * 3 / 10},
+	} {
+		s, giver, peer, _, _ := newChallenge(t)
+		giver.proofs = map[*Node]*proofStats{peer: {passed: test.passed, failed: test.failed}}
+		d := testData("closer to the peer", V{1, 0, 0})
+		if !giver.keep(d) {
+			t.Fatalf("%s: expected the store to take the Data", test.name)
+		}
+		giver.Location = V{-1, 0, 0}
+		giver.peers.UpdatePeerLocation(peer, d.Location)
+		offered := 0
+		for i := 0; i < tries; i++ {
+			if strings.HasPrefix(giver.exchangeData(s), "offered") {
+				offered++
+			}
+			giver.offered = nil
+			giver.pending = nil
+		}
+		if offered < test.min || offered > test.max {
+			t.Fatalf("%s: expected between %d and %d offers, got %d", test.name, test.min, test.max, offered)
+		}
+	}
+}
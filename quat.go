@@ -112,11 +112,21 @@ func (q Q) String() string {
 
 // TODO: Needed?
 func RandomQuaternion() Q {
-	s := rand.Float64()
+	return randomQuaternion(rand.Float64)
+}
+
+// RandomQuaternionFrom is RandomQuaternion drawing from the given source
+// instead of the global one.
+func RandomQuaternionFrom(r *rand.Rand) Q {
+	return randomQuaternion(r.Float64)
+}
+
+func randomQuaternion(float64Fn func() float64) Q {
+	s := float64Fn()
 	sig1 := math.Sqrt(1 - s)
 	sig2 := math.Sqrt(s)
-	t1 := 2 * math.Pi * rand.Float64()
-	t2 := 2 * math.Pi * rand.Float64()
+	t1 := 2 * math.Pi * float64Fn()
+	t2 := 2 * math.Pi * float64Fn()
 	return Q{
 		R: math.Cos(t2) * sig2,
 		I: math.Sin(t1) * sig1,
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cjslep/scr"
+)
+
+const (
+	nMaxPeerSpreadDefault = 16
+	nThenAfterClosest     = 8
+)
+
+var listen = flag.String("listen", "127.0.0.1:7000", "TCP address to listen on")
+var advertise = flag.String("advertise", "", "Address peers dial this daemon at, empty for the listen address")
+var httpAddr = flag.String("http", "", "TCP address to serve the HTTP gateway on, empty for none")
+var bootstrap = flag.String("bootstrap", "", "Comma separated addresses of daemons to join through")
+var storeDir = flag.String("store_dir", "", "Directory to store data in, empty to store it in memory")
+var maxData = flag.Int("max_data", -1, "Most pieces of data stored, negative for no limit")
+var maxBytes = flag.Int("max_bytes", -1, "Most bytes of data stored, negative for no limit")
+var nInitData = flag.Int("n_init_data", 0, "Number of pieces of random data to create on start")
+var dataSize = flag.Int("data_size", 1024, "Bytes of each piece of random data created")
+var tick = flag.Duration("tick", 100*time.Millisecond, "Time between ticks")
+var waitActivity = flag.Float64("wait_activity", 0.5, "Chance each tick of moving on from waiting to the next action")
+var solverMaxIter = flag.Int("solver_max_iter", 0, "Most iterations the node may spend computing its location, 0 for the default of 1000")
+var solverTimeout = flag.Duration("solver_timeout", 0, "Most time the node may spend computing its location, 0 for no budget")
+var joinAttempts = flag.Int("join_attempts", 0, "Times the node introduces itself to a bootstrap daemon before giving up joining, 0 to try until it succeeds")
+var requestTimeout = flag.Int("request_timeout", 10, "Ticks the node waits for a response before counting a request as failed")
+var peerTimeout = flag.Int("peer_timeout", 100, "Ticks the node waits to hear from a failing peer before evicting it, 0 to disable")
+var peerMaxFailures = flag.Int("peer_max_failures", 5, "Failed interactions after which the node evicts a peer, 0 to disable")
+var locationPushThreshold = flag.Float64("location_push_threshold", 0.05, "Radians the node moves before announcing its location to peers, negative to disable")
+var locationPullInterval = flag.Int("location_pull_interval", 50, "Ticks between the node requesting its peers' locations, 0 to disable")
+var reportEvery = flag.Int("report_every", 50, "Ticks between status lines, 0 for none")
+var verbose = flag.Bool("v", false, "Log the state the node applies every tick")
+
+var peerClosest = flag.Bool("peer_closest", false, "Enable closest-peer network")
+var peerMaxThenClosest = flag.Bool("peer_max_spread_then_closest", false, "Enable closest-peer after max-spread-peer network")
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *peerClosest && *peerMaxThenClosest {
+		return fmt.Errorf("too many peer_* flags chosen")
+	}
+	var peers scr.PeerList
+	switch {
+	case *peerClosest:
+		peers = scr.NewClosestNeighbors(nMaxPeerSpreadDefault)
+	case *peerMaxThenClosest:
+		peers = scr.NewMaxSpreadThenClosestNeighbors(nMaxPeerSpreadDefault-nThenAfterClosest, nThenAfterClosest)
+	default:
+		peers = scr.NewMaximizePeerSpread(nMaxPeerSpreadDefault)
+	}
+	var store scr.Store = scr.NewMemoryStore(*maxData, *maxBytes)
+	if *storeDir != "" {
+		s, err := scr.NewDiskStore(*storeDir, *maxData, *maxBytes)
+		if err != nil {
+			return err
+		}
+		store = s
+	}
+	n := scr.NewNode(store, *waitActivity, scr.Budget{
+		MaxIterations: *solverMaxIter,
+		MaxDuration:   *solverTimeout,
+	}, peers)
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	var addrs []string
+	if *bootstrap != "" {
+		addrs = strings.Split(*bootstrap, ",")
+	}
+	d, err := scr.NewDaemon(n, ln, addrs)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	if *advertise != "" {
+		d.Addr = *advertise
+	}
+	d.States.Replace(scr.StateJoin, scr.JoinState{
+		MaxAttempts: *joinAttempts,
+		Join:        scr.StateJoin,
+		Wait:        scr.StateWait,
+	})
+	d.TickInterval = *tick
+	d.RequestTimeout = *requestTimeout
+	d.PeerTimeout = *peerTimeout
+	d.MaxPeerFailures = *peerMaxFailures
+	d.LocationPushThreshold = *locationPushThreshold
+	d.LocationPullInterval = *locationPullInterval
+	d.Log = os.Stdout
+	d.ReportEvery = *reportEvery
+	d.Verbose = *verbose
+	for i := 0; i < *nInitData; i++ {
+		b := make([]byte, *dataSize)
+		_, _ = rand.Read(b)
+		if _, err := d.Put(b); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *httpAddr == "" {
+		return d.Run(ctx)
+	}
+	hln, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	srv := &http.Server{Handler: scr.NewGateway(d)}
+	go srv.Serve(hln)
+	// The gateway serves until the node has left.
+	err = d.Run(ctx)
+	srv.Close()
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/cjslep/scr"
+)
+
+const (
+	solverNEMFL   = "nemfl"
+	solverNEMFLMC = "nemfl_mc"
+	solverMC      = "mc"
+)
+
+var in = flag.String("in", "", "File of weighted points to solve for")
+var format = flag.String("format", "", "Format of the file, 'csv' or 'json' (default: from the file extension)")
+var coords = flag.String("coords", "xyz", "Coordinates of the points, 'xyz' or 'latlon' (degrees)")
+var solver = flag.String("solver", solverNEMFL, fmt.Sprintf("Solver to use: '%s', '%s' or '%s'", solverNEMFL, solverNEMFLMC, solverMC))
+var nonsmoothTol = flag.Float64("nonsmooth_tol", 0.0001, "Tolerance of the nonsmooth solver")
+var smoothTol = flag.Float64("smooth_tol", 0.0001, "Tolerance of the smooth solver")
+var nMC = flag.Int("n_mc", 8, fmt.Sprintf("Number of restarts of the smooth search for '%s'", solverNEMFLMC))
+var nSamples = flag.Int("n_samples", 1000000, fmt.Sprintf("Number of random samples for '%s'", solverMC))
+var workers = flag.Int("workers", runtime.NumCPU(), "Number of goroutines for the Monte Carlo solvers")
+var seed = flag.Int64("seed", 1, "Master seed for the Monte Carlo solvers")
+var maxIter = flag.Int("max_iter", 0, "Most iterations of each smooth search, 0 for no budget")
+var timeout = flag.Duration("timeout", 0, "Most time to solve for, 0 for no budget")
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	locs, weights, err := readPoints()
+	if err != nil {
+		return err
+	}
+	var optimum scr.V
+	// The iterations of the NEMFL solvers, or the samples drawn by mc.
+	var iterations int
+	count := "iterations"
+	// Only nemfl detects degeneracy.
+	degeneracy := "n/a"
+	budget := scr.Budget{
+		MaxIterations: *maxIter,
+		MaxDuration:   *timeout,
+	}
+	start := time.Now()
+	switch *solver {
+	case solverNEMFL:
+		var optima []scr.V
+		var deg scr.Degeneracy
+		optima, deg, err = scr.SolveNonEuclideanMultifacilityLocationTrace(
+			context.Background(),
+			locs,
+			weights,
+			*nonsmoothTol, *smoothTol,
+			budget,
+			func(scr.Iterate) { iterations++ })
+		if len(optima) > 0 {
+			optimum = optima[0]
+		}
+		degeneracy = deg.String()
+	case solverNEMFLMC:
+		optimum, _, _, _, err = scr.SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(
+			context.Background(),
+			locs,
+			weights,
+			*nonsmoothTol, *smoothTol,
+			*nMC,
+			*workers,
+			*seed,
+			budget,
+			func(scr.Iterate) { iterations++ })
+	case solverMC:
+		optimum = scr.MonteCarloMinimizerParallel(locs, weights, *nSamples, *workers, *seed)
+		iterations = *nSamples
+		count = "samples"
+	default:
+		return fmt.Errorf("unknown solver %q", *solver)
+	}
+	dur := time.Since(start)
+	reason := "converged"
+	if errors.Is(err, scr.ErrBudgetExhausted) {
+		reason = err.Error()
+	} else if err != nil {
+		return err
+	}
+	lat, lon := optimum.LatLon()
+	fmt.Printf("solver=%s\n", *solver)
+	fmt.Printf("n=%d\n", len(locs))
+	fmt.Printf("optimum=%s\n", optimum)
+	fmt.Printf("lat=%v\n", lat)
+	fmt.Printf("lon=%v\n", lon)
+	fmt.Printf("objective=%v\n", scr.Objective(optimum, locs, weights))
+	fmt.Printf("%s=%d\n", count, iterations)
+	fmt.Printf("degeneracy=%s\n", degeneracy)
+	fmt.Printf("reason=%s\n", reason)
+	fmt.Printf("duration=%s\n", dur)
+	return nil
+}
+
+func readPoints() ([]scr.V, []float64, error) {
+	c, err := scr.ParseCoordinateFormat(*coords)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scr.ReadWeightedLocationsFile(*in, *format, c)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"runtime"
+
+	"github.com/cjslep/scr"
+)
+
+var in = flag.String("in", "", "File of weighted points to solve for")
+var format = flag.String("format", "", "Format of the file, 'csv' or 'json' (default: from the file extension)")
+var coords = flag.String("coords", "xyz", "Coordinates of the points, 'xyz' or 'latlon' (degrees)")
+var heatmapOut = flag.String("heatmap", "heatmap.png", "PNG file to render the f(x) heatmap and trajectory to")
+var convergenceOut = flag.String("convergence", "convergence.png", "PNG file to render the f(x) convergence plot to")
+var width = flag.Int("width", 720, "Width of the rendered images")
+var height = flag.Int("height", 360, "Height of the rendered images")
+var nonsmoothTol = flag.Float64("nonsmooth_tol", 0.0001, "Tolerance of the nonsmooth solver")
+var smoothTol = flag.Float64("smooth_tol", 0.0001, "Tolerance of the smooth solver")
+var nMC = flag.Int("n_mc", 0, "Number of restarts of the smooth search, as nodes solve for their location, or 0 for a single search")
+var workers = flag.Int("workers", runtime.NumCPU(), "Number of goroutines for the restarts")
+var seed = flag.Int64("seed", 1, "Master seed for the restarts")
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	c, err := scr.ParseCoordinateFormat(*coords)
+	if err != nil {
+		return err
+	}
+	locs, weights, err := scr.ReadWeightedLocationsFile(*in, *format, c)
+	if err != nil {
+		return err
+	}
+	var iterates []scr.Iterate
+	trace := func(it scr.Iterate) {
+		iterates = append(iterates, it)
+	}
+	var optima []scr.V
+	var deg scr.Degeneracy
+	if *nMC > 0 {
+		var optimum scr.V
+		optimum, _, _, _, err = scr.SolveNonEuclideanMultifacilityLocationMonteCarloParallelTrace(
+			context.Background(),
+			locs,
+			weights,
+			*nonsmoothTol, *smoothTol,
+			*nMC,
+			*workers,
+			*seed,
+			scr.Budget{},
+			trace)
+		optima = []scr.V{optimum}
+	} else {
+		optima, deg, err = scr.SolveNonEuclideanMultifacilityLocationTrace(
+			context.Background(),
+			locs,
+			weights,
+			*nonsmoothTol, *smoothTol,
+			scr.Budget{},
+			trace)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\n", "restart", "k", "smooth", "x", "y", "z", "alpha", "dknorm", "fx", "halvings")
+	for _, it := range iterates {
+		fmt.Printf("%v,%v,%v,%v,%v,%v,%v,%v,%v,%v\n", it.Restart, it.K, it.Smooth, it.Xk.X, it.Xk.Y, it.Xk.Z, it.Alphak, it.DkNorm, it.Fx, it.StepHalvings)
+	}
+	fmt.Fprintf(os.Stderr, "optima=%v degeneracy=%v\n", optima, deg)
+
+	if err := writePNG(*heatmapOut, renderHeatmap(locs, weights, iterates, optima, *width, *height)); err != nil {
+		return err
+	}
+	return writePNG(*convergenceOut, renderConvergence(iterates, *width, *height))
+}
+
+func writePNG(name string, img image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/cjslep/scr"
+)
+
+var (
+	colorWhite   = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	colorBlack   = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+	colorGreen   = color.RGBA{0x00, 0xFF, 0x00, 0xFF}
+	colorRed     = color.RGBA{0xFF, 0x00, 0x00, 0xFF}
+	colorGrey    = color.RGBA{0x88, 0x88, 0x88, 0xFF}
+	colorMagenta = color.RGBA{0xFF, 0x00, 0xFF, 0xFF}
+)
+
+// toPixel maps a point on the unit sphere to the equirectangular projection.
+func toPixel(v scr.V, w, h int) (x, y int) {
+	lon := math.Atan2(v.Y, v.X)
+	lat := math.Asin(math.Max(-1, math.Min(1, v.Z)))
+	x = int((lon + math.Pi) / (2 * math.Pi) * float64(w))
+	y = int((math.Pi/2 - lat) / math.Pi * float64(h))
+	return clamp(x, 0, w-1), clamp(y, 0, h-1)
+}
+
+// fromPixel maps the center of a pixel of the equirectangular projection to
+// a point on the unit sphere.
+func fromPixel(x, y, w, h int) scr.V {
+	lon := (float64(x)+0.5)/float64(w)*2*math.Pi - math.Pi
+	lat := math.Pi/2 - (float64(y)+0.5)/float64(h)*math.Pi
+	return scr.V{
+		X: math.Cos(lat) * math.Cos(lon),
+		Y: math.Cos(lat) * math.Sin(lon),
+		Z: math.Sin(lat),
+	}
+}
+
+func clamp(i, min, max int) int {
+	if i < min {
+		return min
+	} else if i > max {
+		return max
+	}
+	return i
+}
+
+// heat maps t in [0, 1] from blue (low) through green to red (high).
+func heat(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	r := math.Max(0, 2*t-1)
+	b := math.Max(0, 1-2*t)
+	g := 1 - r - b
+	return color.RGBA{uint8(255 * r), uint8(255 * g), uint8(255 * b), 0xFF}
+}
+
+func renderHeatmap(locs []scr.V, weights []float64, iterates []scr.Iterate, optima []scr.V, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	fx := make([]float64, w*h)
+	min := math.Inf(1)
+	max := math.Inf(-1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			f := scr.Objective(fromPixel(x, y, w, h), locs, weights)
+			fx[y*w+x] = f
+			min = math.Min(min, f)
+			max = math.Max(max, f)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t := 0.0
+			if max > min {
+				t = (fx[y*w+x] - min) / (max - min)
+			}
+			img.SetRGBA(x, y, heat(t))
+		}
+	}
+	for _, l := range locs {
+		x, y := toPixel(l, w, h)
+		drawDot(img, x, y, 2, colorWhite)
+	}
+	// The trajectory of each restart of the smooth solver, and the
+	// existing locations the nonsmooth solver examined.
+	prev := make(map[int][2]int)
+	for _, it := range iterates {
+		x, y := toPixel(it.Xk, w, h)
+		if !it.Smooth {
+			drawDot(img, x, y, 3, colorGrey)
+			continue
+		}
+		// Don't draw lines that wrap around the projection.
+		if p, ok := prev[it.Restart]; ok && abs(x-p[0]) < w/2 {
+			drawLine(img, p[0], p[1], x, y, colorBlack)
+		}
+		drawDot(img, x, y, 1, colorBlack)
+		prev[it.Restart] = [2]int{x, y}
+	}
+	for _, o := range optima {
+		x, y := toPixel(o, w, h)
+		drawDot(img, x, y, 3, colorMagenta)
+	}
+	return img
+}
+
+func renderConvergence(iterates []scr.Iterate, w, h int) *image.RGBA {
+	const margin = 20
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, colorWhite)
+		}
+	}
+	drawLine(img, margin, margin, margin, h-margin, colorBlack)
+	drawLine(img, margin, h-margin, w-margin, h-margin, colorBlack)
+	// Each restart of the smooth solver is plotted from the left.
+	smooth := make(map[int][]scr.Iterate)
+	var restarts []int
+	n := 0
+	min := math.Inf(1)
+	max := math.Inf(-1)
+	for _, it := range iterates {
+		if !it.Smooth {
+			continue
+		}
+		if _, ok := smooth[it.Restart]; !ok {
+			restarts = append(restarts, it.Restart)
+		}
+		smooth[it.Restart] = append(smooth[it.Restart], it)
+		if l := len(smooth[it.Restart]); l > n {
+			n = l
+		}
+		min = math.Min(min, it.Fx)
+		max = math.Max(max, it.Fx)
+	}
+	if n == 0 {
+		return img
+	}
+	plotX := func(i int) int {
+		if n == 1 {
+			return margin
+		}
+		return margin + i*(w-2*margin)/(n-1)
+	}
+	plotY := func(f float64) int {
+		if max == min {
+			return h - margin
+		}
+		return h - margin - int((f-min)/(max-min)*float64(h-2*margin))
+	}
+	for _, r := range restarts {
+		its := smooth[r]
+		px, py := plotX(0), plotY(its[0].Fx)
+		for i, it := range its {
+			x, y := plotX(i), plotY(it.Fx)
+			drawLine(img, px, py, x, y, colorRed)
+			// Mark iterates that needed step-halving.
+			if it.StepHalvings > 0 {
+				drawDot(img, x, y, 1, colorGreen)
+			}
+			px, py = x, y
+		}
+	}
+	return img
+}
+
+func drawDot(img *image.RGBA, x, y, r int, c color.RGBA) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			img.SetRGBA(x+dx, y+dy, c)
+		}
+	}
+}
+
+// drawLine is Bresenham's line algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx := abs(x1 - x0)
+	dy := -abs(y1 - y0)
+	sx := 1
+	if x0 > x1 {
+		sx = -1
+	}
+	sy := 1
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -1,6 +1,7 @@
 package scr
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -10,7 +11,8 @@ import (
 )
 
 const (
-	nTickMilli = 16
+	nTickMilli                 = 16
+	defaultSolverMaxIterations = 1000
 )
 
 // isHopHistIter determines if an iteration is interesting enough to
@@ -39,35 +41,93 @@ type DataGrowthChanceFactoryFn func() func() float64
 type PeerListFactoryFn func() func() PeerList
 
 type Simulation struct {
-	DataCache        []*Data // Preallocated, global slice
-	DataAllocdToNode []bool  // Same len as DataCache
-	NDataCacheFree   int     // Number of DataAllocd To Node with "false" value.
-	NodeCache        []*Node // Preallocated size for the lifetime of the simulation
-	Tockers          []Tocker
-
-	NodeInitialDataFactoryFn     NodeInitialDataFactoryFn
-	AllocateNDataToNodeFactoryFn AllocateNDataToNodeFactoryFn
-	CreateDataFactoryFn          CreateDataFactoryFn
-	NodeMaxBSizeFactoryFn        NodeMaxBSizeFactoryFn
-	WaitActivityFactoryFn        WaitActivityFactoryFn
-	DataGrowthChanceFactoryFn    DataGrowthChanceFactoryFn
-	PeerListFactoryFn            PeerListFactoryFn
-
-	TickN         int
-	Log           *os.File
-	NodeFile      *os.File
-	NodeStateFile *os.File
-	FxFile        *os.File
-	vizOnly       bool
-	doneCh        chan bool
-	ackDoneCh     chan bool
-	pauseCh       chan bool
-	playCh        chan bool
-	mu            *sync.RWMutex
+	// Number of Data slots not yet allocated to a node. Each node's Store
+	// holds as many pieces of Data as it was allocated slots.
+	NDataFree int
+	NodeCache []*Node // Preallocated size for the lifetime of the simulation
+	Tockers   []Tocker
+	// NewStore creates the Store of each node. See SetStoreFactory.
+	NewStore StoreFactoryFn
+
+	CreateDataFactoryFn       CreateDataFactoryFn
+	DataGrowthChanceFactoryFn DataGrowthChanceFactoryFn
+	// Classes of the nodes created, in proportion to their weights.
+	Classes []*NodeClass
+	// SolverBudget bounds each node's computations of its location. One
+	// without MaxIterations is given defaultSolverMaxIterations, so that a
+	// pathological node cannot stall a tick.
+	SolverBudget Budget
+	// SolverWorkers and SolverSeed are given to each node, the seed of each
+	// derived from SolverSeed in the order the nodes are created. See
+	// SetSolver.
+	SolverWorkers int
+	SolverSeed    int64
+	nNodeSeeds    int
+	// Number of virtual positions each node presents, if more than one.
+	VirtualPositions int
+	// States is the node state machine. It may be added to before Run.
+	// Nodes are created in StateJoin and made to leave in StateLeave, so a
+	// registry replacing it keeps those identifiers.
+	States *StateRegistry
+	// NodeKeys gives each node an ed25519 key pair to derive its ID from,
+	// instead of numbering them.
+	NodeKeys  bool
+	nNodeIDs  int
+	nodesByID map[NodeID]*Node
+	// The node followed in node.txt.
+	nodeFileID NodeID
+	// Bootstrap finds the first node a joining node is introduced to.
+	Bootstrap Bootstrap
+	// Totals for the whole simulation, and for each of the Classes.
+	Counters
+	ClassCounters []Counters
+	// A peer is evicted after failing MaxPeerFailures interactions, or after
+	// failing and not being heard from for PeerTimeout ticks.
+	PeerTimeout     int
+	MaxPeerFailures int
+	// A node announces its location to its peers after moving more than
+	// LocationPushThreshold radians, and requests theirs every
+	// LocationPullInterval ticks. Negative and 0 respectively disable them.
+	LocationPushThreshold float64
+	LocationPullInterval  int
+	// Verification has nodes check the locations their peers claim, if it
+	// is not nil.
+	Verification *Verification
+	// DataRetention is what is kept of the bytes of created Data, and
+	// Challenges has nodes check that peers still store the Data given
+	// them, if it is not nil. Challenges need the bytes, so the retention
+	// is given when the simulation is created, before any Data is.
+	DataRetention DataRetention
+	Challenges    *Challenges
+	// The number of Data whose bytes have been regenerable.
+	nDataSeeds int64
+	// Network carries the messages nodes interact by.
+	Network *Network
+	// Space is the physical network nodes are placed in. See
+	// SetLatencySpace.
+	Space LatencySpace
+
+	TickN    int
+	Log      *os.File
+	NodeFile *os.File
+	// metrics are for all nodes, followed by each of the Classes if there
+	// is more than one.
+	metrics   []*metricFiles
+	vizOnly   bool
+	doneCh    chan bool
+	ackDoneCh chan bool
+	pauseCh   chan bool
+	playCh    chan bool
+	mu        *sync.RWMutex
+	// ctx is cancelled on Quit, cutting short the computations of node
+	// locations in the tick under way.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	redraw func(i, fx, nfx int, avg, stddev float64, dur, durLockless time.Duration)
 }
 
+// NewSimulation has a single class of nodes.
 func NewSimulation(
 	nStartNodes int,
 	nMaxData int,
@@ -80,62 +140,201 @@ func NewSimulation(
 	waitActivityFactoryFn WaitActivityFactoryFn,
 	dataGrowthChanceFactoryFn DataGrowthChanceFactoryFn,
 	peerListFactoryFn PeerListFactoryFn,
+	solverBudget Budget,
+	virtualPositions int,
+	nodeKeys bool,
+	dataRetention DataRetention,
 	vizOnly bool) *Simulation {
+	return NewSimulationWithClasses(
+		nStartNodes,
+		nMaxData,
+		nMaxNode,
+		tockers,
+		createDataFactoryFn,
+		dataGrowthChanceFactoryFn,
+		[]*NodeClass{
+			{
+				Name:                         "default",
+				Weight:                       1,
+				NodeInitialDataFactoryFn:     nodeInitialDataFactoryFn,
+				AllocateNDataToNodeFactoryFn: allocateNDataToNodeFactoryFn,
+				NodeMaxBSizeFactoryFn:        nodeMaxBSizeFactoryFn,
+				WaitActivityFactoryFn:        waitActivityFactoryFn,
+				PeerListFactoryFn:            peerListFactoryFn,
+			},
+		},
+		solverBudget,
+		virtualPositions,
+		nodeKeys,
+		dataRetention,
+		vizOnly)
+}
+
+// NewSimulationWithClasses has nodes of each of the classes, in proportion to
+// their weights.
+func NewSimulationWithClasses(
+	nStartNodes int,
+	nMaxData int,
+	nMaxNode int,
+	tockers []Tocker,
+	createDataFactoryFn CreateDataFactoryFn,
+	dataGrowthChanceFactoryFn DataGrowthChanceFactoryFn,
+	classes []*NodeClass,
+	solverBudget Budget,
+	virtualPositions int,
+	nodeKeys bool,
+	dataRetention DataRetention,
+	vizOnly bool) *Simulation {
+	if solverBudget.MaxIterations == 0 {
+		solverBudget.MaxIterations = defaultSolverMaxIterations
+	}
 	s := &Simulation{
-		DataCache:                    make([]*Data, nMaxData),
-		DataAllocdToNode:             make([]bool, nMaxData),
-		NDataCacheFree:               nMaxData,
-		NodeCache:                    make([]*Node, nMaxNode),
-		Tockers:                      tockers,
-		NodeInitialDataFactoryFn:     nodeInitialDataFactoryFn,
-		AllocateNDataToNodeFactoryFn: allocateNDataToNodeFactoryFn,
-		CreateDataFactoryFn:          createDataFactoryFn,
-		NodeMaxBSizeFactoryFn:        nodeMaxBSizeFactoryFn,
-		WaitActivityFactoryFn:        waitActivityFactoryFn,
-		DataGrowthChanceFactoryFn:    dataGrowthChanceFactoryFn,
-		PeerListFactoryFn:            peerListFactoryFn,
-		TickN:                        0,
-		vizOnly:                      vizOnly,
-		doneCh:                       make(chan bool),
-		ackDoneCh:                    make(chan bool),
-		pauseCh:                      make(chan bool),
-		playCh:                       make(chan bool),
-		mu:                           &sync.RWMutex{},
+		NDataFree:                 nMaxData,
+		NewStore:                  MemoryStoreFactory,
+		NodeCache:                 make([]*Node, nMaxNode),
+		Tockers:                   tockers,
+		CreateDataFactoryFn:       createDataFactoryFn,
+		DataGrowthChanceFactoryFn: dataGrowthChanceFactoryFn,
+		Classes:                   classes,
+		SolverBudget:              solverBudget,
+		VirtualPositions:          virtualPositions,
+		States:                    DefaultStateRegistry(),
+		NodeKeys:                  nodeKeys,
+		DataRetention:             dataRetention,
+		Bootstrap:                 ArbitraryBootstrap{},
+		nodesByID:                 make(map[NodeID]*Node, nMaxNode),
+		ClassCounters:             make([]Counters, len(classes)),
+		PeerTimeout:               defaultPeerTimeout,
+		MaxPeerFailures:           defaultMaxPeerFailures,
+		LocationPushThreshold:     defaultLocationPushThreshold,
+		LocationPullInterval:      defaultLocationPullInterval,
+		Network:                   NewNetwork(ConstantLatency(defaultLatency), 0, 0, defaultRequestTimeout),
+		Space:                     NewSyntheticGeography(defaultRegions, defaultRegionSpread),
+		TickN:                     0,
+		vizOnly:                   vizOnly,
+		doneCh:                    make(chan bool),
+		ackDoneCh:                 make(chan bool),
+		pauseCh:                   make(chan bool),
+		playCh:                    make(chan bool),
+		mu:                        &sync.RWMutex{},
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	for i := 0; i < nStartNodes && i < len(s.NodeCache); i++ {
-		s.NodeCache[i] = s.createNode()
+		s.NodeCache[i] = s.createNode(s.randomClass())
 	}
 	return s
 }
 
+// SetLatencySpace places the nodes, including those already created, in the
+// space.
+func (s *Simulation) SetLatencySpace(space LatencySpace) {
+	s.Space = space
+	for _, n := range s.NodeCache {
+		if n != nil {
+			n.Host = space.NewHost()
+		}
+	}
+}
+
+// SetStoreFactory has nodes, including those already created, store their
+// Data in Stores created by f, of the same capacity.
+func (s *Simulation) SetStoreFactory(f StoreFactoryFn) {
+	s.NewStore = f
+	for _, n := range s.NodeCache {
+		if n == nil {
+			continue
+		}
+		store := f(n.Store.Capacity())
+		for _, d := range n.Store.List() {
+			if err := store.Put(d); err != nil {
+				panic(err)
+			}
+		}
+		n.Store = store
+	}
+}
+
+// SetSolver has nodes, including those already created, compute their
+// locations across nWorkers goroutines, from seeds derived from seed unless it
+// is 0. Nodes already created recompute their locations from their new seeds.
+func (s *Simulation) SetSolver(nWorkers int, seed int64) {
+	s.SolverWorkers = nWorkers
+	s.SolverSeed = seed
+	s.nNodeSeeds = 0
+	for _, n := range s.NodeCache {
+		if n != nil {
+			s.seedSolver(n)
+		}
+	}
+}
+
+// seedSolver gives the node the simulation's solver workers and the next seed
+// derived from its SolverSeed, recomputing its location from that seed.
+func (s *Simulation) seedSolver(n *Node) {
+	n.SolverWorkers = s.SolverWorkers
+	if s.SolverSeed == 0 {
+		return
+	}
+	s.nNodeSeeds++
+	n.SolverSeed = deriveSeed(s.SolverSeed, s.nNodeSeeds)
+	n.nSolves = 0
+	n.computeLocation()
+}
+
 func (s *Simulation) SetRedraw(f func(i, fx, nfx int, avg, stddev float64, dur, durLockless time.Duration)) {
 	s.redraw = f
 }
 
 // NewNodeJoins is for Tockers to use
 func (s *Simulation) NewNodeJoins() {
+	s.NewNodeOfClassJoins(s.randomClass())
+}
+
+// NewNodeOfClassJoins is for Tockers to use
+func (s *Simulation) NewNodeOfClassJoins(c *NodeClass) {
 	for i := 0; i < len(s.NodeCache); i++ {
 		if s.NodeCache[i] != nil {
 			continue
 		}
-		s.NodeCache[i] = s.createNode()
+		s.NodeCache[i] = s.createNode(c)
 		return
 	}
 }
 
-// ExistingNodeLeaves is for Tockers to use
+// ExistingNodeLeaves is for Tockers to use. The node goes offline at once,
+// losing all of its Data. Its peers only find out when it stops responding.
 func (s *Simulation) ExistingNodeLeaves() {
-	var d *Node
 	for i := len(s.NodeCache) - 1; i >= 0; i-- {
 		if s.NodeCache[i] == nil {
 			continue
 		}
-		if d == nil {
-			d = s.NodeCache[i]
-			s.NodeCache[i] = nil
-		} else {
-			s.NodeCache[i].removePeer(d)
+		s.nodeLeaves(s.NodeCache[i], false)
+		return
+	}
+}
+
+// ExistingNodeLeavesGracefully is for Tockers to use. The node enters
+// StateLeave, handing its Data off to peers over the following ticks before
+// going offline.
+func (s *Simulation) ExistingNodeLeavesGracefully() {
+	for i := len(s.NodeCache) - 1; i >= 0; i-- {
+		n := s.NodeCache[i]
+		if n == nil || n.S.id == StateLeave {
+			continue
 		}
+		s.nodeLeaves(n, true)
+		return
+	}
+}
+
+func (s *Simulation) nodeLeaves(n *Node, gracefully bool) {
+	if !gracefully {
+		s.removeNode(n)
+		return
+	}
+	n.S = State{
+		id:        StateLeave,
+		lastState: n.S.id,
 	}
 }
 
@@ -151,50 +350,88 @@ func (s *Simulation) GenerateLocalData() {
 		if rand.Float64() >= chance {
 			continue
 		}
-		idx := n.nextFreeDataIndex()
-		if idx < 0 {
+		// Data too big for the node is never stored.
+		d := s.createData(createDataFn)
+		if !n.keep(d) {
 			continue
 		}
-		s.createData(createDataFn, idx)
-		n.applyNewData(idx)
+		n.computeLocation()
+		s.recordCreated(n, d)
 	}
 }
 
-func (s *Simulation) createNode() *Node {
-	nodeInitDataFn := s.NodeInitialDataFactoryFn()
-	allocateNDataToNodeFn := s.AllocateNDataToNodeFactoryFn()
+func (s *Simulation) createNode(c *NodeClass) *Node {
+	nodeInitDataFn := c.NodeInitialDataFactoryFn()
+	allocateNDataToNodeFn := c.AllocateNDataToNodeFactoryFn()
 	createDataFn := s.CreateDataFactoryFn()
-	nodeMaxBSizeFn := s.NodeMaxBSizeFactoryFn()
-	waitActivityFn := s.WaitActivityFactoryFn()
-	peerListFn := s.PeerListFactoryFn()
+	nodeMaxBSizeFn := c.NodeMaxBSizeFactoryFn()
+	waitActivityFn := c.WaitActivityFactoryFn()
+	peerListFn := c.PeerListFactoryFn()
 
 	// not concurrent safe
-	dcSize := allocateNDataToNodeFn(s.NDataCacheFree)
-	s.NDataCacheFree -= dcSize
-	indices := make([]int, 0, dcSize)
-	for idx, allocd := range s.DataAllocdToNode {
-		if !allocd {
-			indices = append(indices, idx)
-			s.DataAllocdToNode[idx] = true
-		}
-		if len(indices) >= dcSize {
-			break
-		}
-	}
+	dcSize := allocateNDataToNodeFn(s.NDataFree)
+	s.NDataFree -= dcSize
 	size := 0
+	var created []*Data
 	nInitData := nodeInitDataFn(dcSize)
-	for j := 0; j < nInitData && j < len(indices); j++ {
-		size += s.createData(createDataFn, indices[j]).DataSize
+	for j := 0; j < nInitData && j < dcSize; j++ {
+		d := s.createData(createDataFn)
+		created = append(created, d)
+		size += d.DataSize
+	}
+	// A class giving a node no room is not giving it unlimited room.
+	maxBytes := nodeMaxBSizeFn(size)
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	store := s.NewStore(dcSize, maxBytes)
+	kept := created[:0]
+	for _, d := range created {
+		if store.Put(d) == nil {
+			d.holders++
+			kept = append(kept, d)
+		}
 	}
 	// TODO: Log
-	return NewNode(
-		s.DataCache,
-		indices,
-		nodeMaxBSizeFn(size),
-		waitActivityFn(),
-		peerListFn())
+	var n *Node
+	if s.VirtualPositions > 1 {
+		peerLists := make([]PeerList, s.VirtualPositions)
+		for i := range peerLists {
+			peerLists[i] = peerListFn()
+		}
+		n = NewVirtualNode(
+			store,
+			waitActivityFn(),
+			s.SolverBudget,
+			peerLists)
+	} else {
+		n = NewNode(
+			store,
+			waitActivityFn(),
+			s.SolverBudget,
+			peerListFn())
+	}
+	s.assignIdentity(n)
+	n.solverCtx = s.ctx
+	s.seedSolver(n)
+	n.Host = s.Space.NewHost()
+	n.UploadCap = c.UploadCap
+	n.DownloadCap = c.DownloadCap
+	n.createdTick = s.TickN
+	n.Class = c
+	if c.SessionLengthFactoryFn != nil {
+		if l := c.SessionLengthFactoryFn()(); l > 0 {
+			n.sessionEnd = s.TickN + l
+		}
+	}
+	for _, d := range kept {
+		s.recordCreated(n, d)
+	}
+	return n
 }
 
+// removeNode takes the node offline and frees its data slots, counting any
+// Data still in its Store as lost unless it is held or carried elsewhere.
 func (s *Simulation) removeNode(r *Node) {
 	idxR := -1
 	for idx, n := range s.NodeCache {
@@ -203,20 +440,71 @@ func (s *Simulation) removeNode(r *Node) {
 			break
 		}
 	}
-	for _, dataIdx := range s.NodeCache[idxR].DataIndices {
-		s.removeData(dataIdx)
-		s.DataAllocdToNode[dataIdx] = false
+	for _, d := range r.Store.List() {
+		r.forget(d)
+		s.settleData(r, d)
 	}
+	for _, d := range r.released {
+		s.settleData(r, d)
+	}
+	r.released = nil
+	slots, _ := r.Store.Capacity()
+	s.NDataFree += slots
+	s.count(r, func(c *Counters) {
+		c.NodesDeparted++
+		c.DataHandedOff += r.handedOff
+	})
 	s.NodeCache[idxR] = nil
+	delete(s.nodesByID, r.ID)
+	// Peers may still reference the node.
+	r.departed = true
+	r.departedTick = s.TickN
+	for _, p := range r.virtuals {
+		p.assigned = nil
+	}
+}
+
+// createData is held by no node until one keeps it.
+func (s *Simulation) createData(createDataFn CreateDataFn) *Data {
+	b := createDataFn()
+	var seed int64
+	if s.DataRetention == RegenerateData {
+		s.nDataSeeds++
+		seed = s.nDataSeeds
+		b = regenerateData(seed, len(b))
+	}
+	d := NewData(b)
+	switch s.DataRetention {
+	case RetainData:
+		d.bytes = b
+	case RegenerateData:
+		d.seed = seed
+	}
+	return d
 }
 
-func (s *Simulation) createData(createDataFn CreateDataFn, idx int) *Data {
-	s.DataCache[idx] = NewData(createDataFn())
-	return s.DataCache[idx]
+func (s *Simulation) recordCreated(n *Node, d *Data) {
+	d.createdTick = s.TickN
+	s.count(n, func(c *Counters) {
+		c.DataCreated++
+		c.DataCreatedBytes += d.DataSize
+	})
 }
 
-func (s *Simulation) removeData(idx int) {
-	s.DataCache[idx] = nil
+func (s *Simulation) recordLost(n *Node, d *Data) {
+	s.count(n, func(c *Counters) {
+		c.DataLost++
+		c.DataLostBytes += d.DataSize
+		c.lostTicks += s.TickN - d.createdTick
+	})
+}
+
+// settleData counts the Data as lost if no node holds it and no message in
+// transit carries it.
+func (s *Simulation) settleData(n *Node, d *Data) {
+	if d != nil && d.holders <= 0 && !s.Network.carries(d) {
+		s.recordLost(n, d)
+	}
 }
 
 func (s *Simulation) RLock() {
@@ -228,6 +516,7 @@ func (s *Simulation) RUnlock() {
 }
 
 func (s *Simulation) Quit() {
+	s.cancel()
 	s.doneCh <- true
 	<-s.ackDoneCh
 }
@@ -251,16 +540,13 @@ func (s *Simulation) Run() {
 		if err != nil {
 			panic(err)
 		}
-		s.NodeStateFile, err = os.OpenFile("states.txt", os.O_RDWR|os.O_CREATE, 0755)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Fprintf(s.NodeStateFile, "%s,%s,%s,%s,%s\n", "iter", "join", "wait", "xData", "askPeer")
-		s.FxFile, err = os.OpenFile("fx.txt", os.O_RDWR|os.O_CREATE, 0755)
-		if err != nil {
-			panic(err)
+		names := s.States.Names()
+		s.metrics = []*metricFiles{openMetricFiles("", names)}
+		if len(s.Classes) > 1 {
+			for _, c := range s.Classes {
+				s.metrics = append(s.metrics, openMetricFiles("_"+c.Name, names))
+			}
 		}
-		fmt.Fprintf(s.FxFile, "%s,%s,%s,%s,%s,%s\n", "iter", "fx", "fx^2", "n", "avg", "stddev")
 	}
 	go func() {
 		defer func() { s.ackDoneCh <- true }()
@@ -269,8 +555,9 @@ func (s *Simulation) Run() {
 		if !s.vizOnly {
 			defer s.Log.Close()
 			defer s.NodeFile.Close()
-			defer s.NodeStateFile.Close()
-			defer s.FxFile.Close()
+			for _, m := range s.metrics {
+				defer m.close()
+			}
 		}
 		i := 0
 		for {
@@ -289,6 +576,7 @@ func (s *Simulation) Run() {
 				startPostLock := time.Now()
 				if !s.vizOnly && isHopHistIter(i) {
 					s.computeHopHist(i)
+					s.computeStretch(i)
 				}
 				s.tick(i)
 				s.tock(i)
@@ -300,9 +588,8 @@ func (s *Simulation) Run() {
 					stddev = fxsq/float64(nfx) - (avg * avg)
 				}
 				if !s.vizOnly {
-					s.writeNodeStateFile(i)
 					s.writeNodeFile(i)
-					s.writeFxFile(i, fx, fxsq, nfx, avg, stddev)
+					s.writeMetrics(i)
 				}
 				s.mu.Unlock()
 				f := time.Now()
@@ -315,6 +602,52 @@ func (s *Simulation) Run() {
 
 // tick progresses node states.
 func (s *Simulation) tick(i int) {
+	s.TickN = i
+	for _, n := range s.NodeCache {
+		if n == nil {
+			continue
+		}
+		n.uploadedTick = 0
+		n.downloadedTick = 0
+		evicted := n.evictStalePeers(i, s.PeerTimeout, s.MaxPeerFailures)
+		expired := n.expireRequests(i, s.Network.RequestTimeout)
+		s.count(n, func(c *Counters) {
+			c.PeersEvicted += evicted
+			c.RequestsTimedOut += expired
+		})
+	}
+	undelivered := s.Network.progress(i)
+	undelivered = append(undelivered, s.deliverMessages(i)...)
+	for _, n := range s.NodeCache {
+		if n == nil {
+			continue
+		}
+		t := n.handleMessages(s, s.Verification, s.Challenges)
+		s.count(n, func(c *Counters) {
+			c.LocationsPulled += t.LocationsPulled
+			c.VerificationsPassed += t.VerificationsPassed
+			c.VerificationsFailed += t.VerificationsFailed
+			c.VerificationsUnverifiable += t.VerificationsUnverifiable
+			c.ProofsPassed += t.ProofsPassed
+			c.ProofsFailed += t.ProofsFailed
+			c.ProofsMoved += t.ProofsMoved
+		})
+	}
+	// Data is only known to be lost once every delivered message has been
+	// handled.
+	for _, m := range undelivered {
+		s.settleData(m.To.physical(), m.Data)
+		s.settleData(m.To.physical(), m.Swap)
+	}
+	for _, n := range s.NodeCache {
+		if n == nil {
+			continue
+		}
+		for _, d := range n.released {
+			s.settleData(n, d)
+		}
+		n.released = nil
+	}
 	for _, n := range s.NodeCache {
 		if n == nil {
 			continue
@@ -323,13 +656,62 @@ func (s *Simulation) tick(i int) {
 		if len(summary) > 0 && !s.vizOnly {
 			fmt.Fprintf(s.Log, "%d: %s\n", i, summary)
 		}
+		s.countJoin(n, i)
+	}
+	for _, n := range s.NodeCache {
+		if n == nil || n.departed {
+			continue
+		}
+		pushed := 0
+		if s.LocationPushThreshold >= 0 {
+			pushed = n.announceLocation(s, s.LocationPushThreshold)
+		}
+		if s.LocationPullInterval > 0 {
+			n.pullPeerLocations(s, i, s.LocationPullInterval)
+		}
+		if s.Verification != nil && s.Verification.Interval > 0 {
+			n.verifyPeer(s, i, s.Verification)
+		}
+		if s.Challenges != nil && s.Challenges.Interval > 0 {
+			n.challengePeer(s, i, s.Challenges)
+		}
+		s.count(n, func(c *Counters) { c.LocationsPushed += pushed })
 	}
 	for _, n := range s.NodeCache {
 		if n == nil {
 			continue
 		}
+		s.count(n, func(c *Counters) {
+			c.BytesUploaded += n.uploadedTick
+			c.BytesDownloaded += n.downloadedTick
+			c.SolverBudgetExhausted += n.budgetExhausted
+		})
+		n.budgetExhausted = 0
 		n.AdvanceState()
 	}
+	for _, n := range s.NodeCache {
+		if n == nil || !n.departed {
+			continue
+		}
+		s.removeNode(n)
+	}
+}
+
+// countJoin counts the outcome of the node's attempts to join, if it just
+// finished joining.
+func (s *Simulation) countJoin(n *Node, i int) {
+	if n.S.id != StateJoin || n.NextS.id == StateJoin {
+		return
+	}
+	s.count(n, func(c *Counters) { c.JoinAttempts += n.joinAttempts })
+	if n.hasPeers() {
+		s.count(n, func(c *Counters) {
+			c.JoinsSucceeded++
+			c.joinTicks += i - n.createdTick
+		})
+	} else {
+		s.count(n, func(c *Counters) { c.JoinsFailed++ })
+	}
 }
 
 // tock applies events to the ecosystem: nodes coming online or offline.
@@ -339,61 +721,66 @@ func (s *Simulation) tock(i int) {
 	}
 }
 
+// writeNodeFile follows a node by its ID, moving on to the first online
+// node once it is gone.
 func (s *Simulation) writeNodeFile(i int) {
-	n := s.NodeCache[0]
+	n := s.NodeByID(s.nodeFileID)
+	if n == nil {
+		for _, o := range s.NodeCache {
+			if o != nil {
+				n = o
+				break
+			}
+		}
+		if n == nil {
+			return
+		}
+		s.nodeFileID = n.ID
+	}
 	locs := n.getDataLocations()
-	fmt.Fprintf(s.NodeFile, "%v,%v,%d,%v\n", i, n.Location, len(locs), locs)
+	fmt.Fprintf(s.NodeFile, "%v,%v,%v,%d,%v\n", i, n.ID, n.Location, len(locs), locs)
 }
 
-func (s *Simulation) writeNodeStateFile(i int) {
-	m := []int{
-		/*StateJoin=*/ 0,
-		/*StateWait=*/ 0,
-		/*StateExchangeData=*/ 0,
-		/*StateAskPeer=*/ 0,
+// writeMetrics writes the metric files for all nodes, then for each class.
+func (s *Simulation) writeMetrics(i int) {
+	nStates := s.States.Len()
+	s.metrics[0].write(i, s.nodesOfClass(nil), &s.Counters, nStates)
+	for j, m := range s.metrics[1:] {
+		m.write(i, s.nodesOfClass(s.metricsClass(j+1)), &s.ClassCounters[j], nStates)
 	}
-	for _, n := range s.NodeCache {
-		if n == nil {
-			continue
-		}
-		n.CountLastState(m)
-	}
-	fmt.Fprintf(s.NodeStateFile, "%d,%d,%d,%d,%d\n",
-		i,
-		m[StateJoin],
-		m[StateWait],
-		m[StateExchangeData],
-		m[StateAskPeer])
 }
 
 func (s *Simulation) computeFxStatistics() (fx float64, fxsq float64, nfx int) {
+	return computeFxStatistics(s.nodesOfClass(nil))
+}
+
+// HeldData is every distinct Data held by a node.
+func (s *Simulation) HeldData() []*Data {
+	var data []*Data
+	seen := make(map[*Data]bool)
 	for _, n := range s.NodeCache {
 		if n == nil {
 			continue
 		}
-		fx += n.fx
-		fxsq += n.fxsq
-		nfx += n.nfx
+		for _, d := range n.Store.List() {
+			if !seen[d] {
+				seen[d] = true
+				data = append(data, d)
+			}
+		}
 	}
-	return
-}
-
-func (s *Simulation) writeFxFile(i int, fx, fxsq float64, nfx int, avg, stddev float64) {
-	fmt.Fprintf(s.FxFile, "%v,%v,%v,%v,%v,%v\n", i, fx, fxsq, nfx, avg, stddev)
+	return data
 }
 
 func (s *Simulation) computeHopHist(i int) {
-	m := make(map[*Data]map[*Node]int, len(s.DataCache))
+	data := s.HeldData()
+	m := make(map[*Data]map[*Node]int, len(data))
 	// Seed m with no-hop nodes
 	for _, n := range s.NodeCache {
 		if n == nil {
 			continue
 		}
-		for _, di := range n.DataIndices {
-			d := s.DataCache[di]
-			if d == nil {
-				continue
-			}
+		for _, d := range n.Store.List() {
 			m[d] = map[*Node]int{
 				n: 0,
 			}
@@ -405,16 +792,13 @@ func (s *Simulation) computeHopHist(i int) {
 	for i := 0; i < len(s.NodeCache); i++ {
 		// Repeatedly build up hops
 		stillHopping := false
-		for _, d := range s.DataCache {
-			if d == nil {
-				continue
-			}
+		for _, d := range data {
 			nodeMap := m[d]
 			for _, n := range s.NodeCache {
 				if n == nil {
 					continue
 				}
-				n.peers.IterateOverPeersWith(func(p *Node) {
+				n.iterateOverPeersWith(func(p *Node) {
 					if p == nil {
 						return
 					}
@@ -432,64 +816,66 @@ func (s *Simulation) computeHopHist(i int) {
 			break
 		}
 	}
-	// Build up disjoint counts
-	disj := make(map[*Data]int, len(s.DataCache))
-	for _, d := range s.DataCache {
-		if d == nil {
-			continue
-		}
-		nodeMap := m[d]
-		for _, n := range s.NodeCache {
-			if n == nil {
-				continue
-			}
-			if _, ok := nodeMap[n]; !ok {
-				if c, ok := disj[d]; ok {
-					disj[d] = c + 1
-				} else {
-					disj[d] = 1
-				}
-			}
-		}
-	}
-	// Compute histograph
-	hopsHist := make([]int, len(s.NodeCache))
-	disjHist := make([]int, len(s.NodeCache))
-	for _, nm := range m {
-		for _, hops := range nm {
-			hopsHist[hops] += 1
-		}
-	}
-	for _, nDisj := range disj {
-		disjHist[nDisj] += 1
-	}
-	// Output to file
-	histF, err := os.OpenFile(fmt.Sprintf("hist_%d.txt", i), os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		panic(err)
-	}
-	defer histF.Close()
-	fmt.Fprintf(histF, "%s,%s\n", "hops", "count")
-	for i, v := range hopsHist {
-		fmt.Fprintf(histF, "%d,%d\n", i, v)
+	for j, mf := range s.metrics {
+		mf.writeHopHist(i, data, m, s.nodesOfClass(s.metricsClass(j)), len(s.NodeCache))
 	}
-	disjF, err := os.OpenFile(fmt.Sprintf("disj_%d.txt", i), os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		panic(err)
+}
+
+// metricsClass is the class of the j'th of the metrics, or nil for all nodes.
+func (s *Simulation) metricsClass(j int) *NodeClass {
+	if j == 0 {
+		return nil
 	}
-	defer disjF.Close()
-	fmt.Fprintf(disjF, "%s,%s\n", "disjoint", "count")
-	for i, v := range disjHist {
-		fmt.Fprintf(disjF, "%d,%d\n", i, v)
+	return s.Classes[j-1]
+}
+
+var _ Coordinator = &Simulation{}
+
+func (s *Simulation) BootstrapNode(joining *Node) *Node {
+	return s.Bootstrap.BootstrapNode(s, joining)
+}
+
+// FindOtherArbitraryNode returns nil if there is no other online node.
+func (s *Simulation) FindOtherArbitraryNode(notMe *Node) *Node {
+	offset := rand.Intn(len(s.NodeCache))
+	for i := range s.NodeCache {
+		n := s.NodeCache[(offset+i)%len(s.NodeCache)]
+		if n != nil && n != notMe {
+			return n
+		}
 	}
+	return nil
 }
 
-var _ coordinator = &Simulation{}
+func (s *Simulation) StateHandler(id int) StateHandler {
+	return s.States.Handler(id)
+}
 
-func (s *Simulation) FindOtherArbitraryNode(notMe *Node) (n *Node) {
-	for n == nil || n == notMe {
-		offset := rand.Intn(len(s.NodeCache))
-		n = s.NodeCache[offset]
+func (s *Simulation) Send(m *Message) {
+	s.count(m.From, func(c *Counters) { c.MessagesSent++ })
+	if !s.Network.send(m, s.TickN) {
+		s.count(m.From, func(c *Counters) { c.MessagesDropped++ })
+		// Settled with the sender's released Data, as a message
+		// delivered this tick may still be handled.
+		p := m.From.physical()
+		for _, d := range []*Data{m.Data, m.Swap} {
+			if d != nil {
+				p.released = append(p.released, d)
+			}
+		}
+	}
+}
+
+// deliverMessages puts the messages arriving this tick in the inboxes of
+// their receivers, returning those for nodes that have gone offline.
+func (s *Simulation) deliverMessages(i int) (undelivered []*Message) {
+	for _, m := range s.Network.due(i) {
+		to := m.To.physical()
+		if to.departed {
+			undelivered = append(undelivered, m)
+			continue
+		}
+		to.inbox = append(to.inbox, m)
 	}
 	return
 }
@@ -0,0 +1,188 @@
+package scr
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testClass has nodes each holding nDataPerNode pieces of Data of random
+// bytes, with room for as many again.
+func testClass(name string, nDataPerNode int) *NodeClass {
+	return &NodeClass{
+		Name:                         name,
+		Weight:                       1,
+		NodeInitialDataFactoryFn:     func() func(int) int { return func(int) int { return nDataPerNode } },
+		AllocateNDataToNodeFactoryFn: func() func(int) int { return func(int) int { return 2 * nDataPerNode } },
+		NodeMaxBSizeFactoryFn:        func() func(int) int { return func(size int) int { return 2 * size } },
+		WaitActivityFactoryFn:        func() func() float64 { return func() float64 { return 1 } },
+		PeerListFactoryFn:            func() func() PeerList { return func() PeerList { return NewMaximizePeerSpread(8) } },
+	}
+}
+
+// newTestSimulation has nNodes nodes of each of the classes, or of a single
+// class if there are none, each holding nDataPerNode pieces of Data.
+func newTestSimulation(nNodes, nDataPerNode int, retention DataRetention, classNames ...string) *Simulation {
+	if len(classNames) == 0 {
+		classNames = []string{"default"}
+	}
+	classes := make([]*NodeClass, len(classNames))
+	for j, name := range classNames {
+		classes[j] = testClass(name, nDataPerNode)
+	}
+	s := NewSimulationWithClasses(
+		0,
+		2*nNodes*len(classes)*nDataPerNode,
+		nNodes*len(classes),
+		nil,
+		func() CreateDataFn {
+			return func() []byte {
+				b := make([]byte, 64)
+				_, _ = rand.Read(b)
+				return b
+			}
+		},
+		func() func() float64 { return func() float64 { return 0 } },
+		classes,
+		Budget{},
+		1,
+		false,
+		retention,
+		true)
+	for _, c := range classes {
+		for j := 0; j < nNodes; j++ {
+			s.NewNodeOfClassJoins(c)
+		}
+	}
+	return s
+}
+
+// runTicks runs the simulation from tick from until tick to, as Run does
+// without its output.
+func runTicks(s *Simulation, from, to int) {
+	for i := from; i < to; i++ {
+		s.tick(i)
+		s.tock(i)
+	}
+}
+
+func TestSimulationRetainsCreatedData(t *testing.T) {
+	for _, r := range []DataRetention{RetainData, RegenerateData} {
+		s := newTestSimulation(4, 5, r)
+		held := s.HeldData()
+		if len(held) != 20 || s.DataCreated != len(held) {
+			t.Fatalf("expected 20 Data created and held, got %d created and %d held", s.DataCreated, len(held))
+		}
+		for _, d := range held {
+			if b := d.Bytes(); b == nil || !bytes.Equal(DataToAddress(b), d.Address) {
+				t.Fatalf("expected the bytes of %s to be kept, got %x", d, b)
+			}
+		}
+	}
+}
+
+func TestSimulationWritesTickFilesPerClass(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	s := newTestSimulation(4, 3, DiscardData, "server", "client")
+	names := s.States.Names()
+	s.metrics = []*metricFiles{openMetricFiles("", names), openMetricFiles("_server", names), openMetricFiles("_client", names)}
+	defer func() {
+		for _, m := range s.metrics {
+			m.close()
+		}
+	}()
+	runTicks(s, 0, 20)
+	s.computeHopHist(20)
+	s.computeStretch(20)
+	for _, name := range []string{"hist", "disj", "stretch"} {
+		for _, suffix := range []string{"", "_server", "_client"} {
+			file := filepath.Join(dir, name+suffix+"_20.txt")
+			if _, err := os.Stat(file); err != nil {
+				t.Fatalf("expected %s to be written: %v", file, err)
+			}
+		}
+	}
+	// Each class's histogram counts only its own nodes' hops to each Data.
+	count := func(file string) (total int) {
+		b, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n")[1:] {
+			var hops, n int
+			if _, err := fmt.Sscanf(line, "%d,%d", &hops, &n); err != nil {
+				t.Fatal(err)
+			}
+			total += n
+		}
+		return
+	}
+	if all, server, client := count("hist_20.txt"), count("hist_server_20.txt"), count("hist_client_20.txt"); all != server+client || server == 0 || client == 0 {
+		t.Fatalf("expected the classes' hops to add up to all of them, got %d and %d of %d", server, client, all)
+	}
+}
+
+func TestSimulationSetSolver(t *testing.T) {
+	s := newTestSimulation(4, 3, DiscardData)
+	s.SetSolver(2, 42)
+	seeds := make(map[int64]bool)
+	for i, n := range s.NodeCache {
+		if n.SolverWorkers != 2 || n.SolverSeed != deriveSeed(42, i+1) {
+			t.Fatalf("expected node %d to solve with 2 workers and seed %d, got %d and %d",
+				i, deriveSeed(42, i+1), n.SolverWorkers, n.SolverSeed)
+		}
+		seeds[n.SolverSeed] = true
+	}
+	if len(seeds) != len(s.NodeCache) {
+		t.Fatalf("expected a seed for each node, got %d", len(seeds))
+	}
+	// Nodes created later are given the next seeds.
+	s.NodeCache = append(s.NodeCache, nil)
+	s.NDataFree += 6
+	s.NewNodeJoins()
+	if n := s.NodeCache[4]; n.SolverWorkers != 2 || n.SolverSeed != deriveSeed(42, 5) {
+		t.Fatalf("expected the new node to solve with 2 workers and seed %d, got %d and %d",
+			deriveSeed(42, 5), n.SolverWorkers, n.SolverSeed)
+	}
+}
+
+func TestSimulationBoundsSolver(t *testing.T) {
+	s := newTestSimulation(2, 5, DiscardData)
+	for i, n := range s.NodeCache {
+		if n.SolverBudget.MaxIterations != defaultSolverMaxIterations {
+			t.Fatalf("expected node %d to solve within %d iterations, got %d",
+				i, defaultSolverMaxIterations, n.SolverBudget.MaxIterations)
+		}
+	}
+	// Data no existing location is optimal for, so that its location is
+	// searched for rather than found in closed form.
+	n := s.NodeCache[0]
+	n.Store = NewMemoryStore(-1, -1)
+	for i, loc := range []V{{1, 0, 1}, {-0.5, 0.866, 1}, {-0.5, -0.866, 1}} {
+		if !n.keep(testData(fmt.Sprintf("d%d", i), loc)) {
+			t.Fatal("expected the store to take the Data")
+		}
+	}
+	n.computeLocation()
+	if n.budgetExhausted != 0 {
+		t.Fatalf("expected the solve to converge within its budget, got %d cut short", n.budgetExhausted)
+	}
+	// Quitting cuts short the solves of the tick under way.
+	s.cancel()
+	n.computeLocation()
+	if n.budgetExhausted != 1 {
+		t.Fatalf("expected the cancelled solve to be cut short, got %d", n.budgetExhausted)
+	}
+}
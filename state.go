@@ -0,0 +1,241 @@
+package scr
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+type State struct {
+	id        int
+	lastState int
+}
+
+// ID is the registry identifier of this state.
+func (s State) ID() int {
+	return s.id
+}
+
+// LastState is the registry identifier of the state applied before this one.
+func (s State) LastState() int {
+	return s.lastState
+}
+
+// The states registered by DefaultStateRegistry, in order. Handlers are
+// given the identifiers of the states they move on to, rather than assuming
+// these.
+const (
+	StateJoin int = iota
+	StateWait
+	StateExchangeData
+	StateAskPeer
+	StateLeave
+)
+
+// StateHandler is one state of a node's state machine.
+type StateHandler interface {
+	// Name is used in output file headers.
+	Name() string
+	// Apply performs the state's action for the node, returning the
+	// identifier of the state to apply next tick and a summary for the log.
+	Apply(n *Node, c Coordinator) (next int, summary string)
+}
+
+// StateRegistry maps state identifiers to their handlers. Identifiers are
+// assigned in order of registration.
+type StateRegistry struct {
+	handlers []StateHandler
+}
+
+func NewStateRegistry() *StateRegistry {
+	return &StateRegistry{}
+}
+
+// DefaultStateRegistry has the states StateJoin, StateWait,
+// StateExchangeData, StateAskPeer and StateLeave. A node waits between
+// actions, which it alternates through, until it is made to leave.
+func DefaultStateRegistry() *StateRegistry {
+	r := NewStateRegistry()
+	join := r.Register(JoinState{})
+	wait := r.Register(&WaitState{})
+	xData := r.Register(ExchangeDataState{Wait: wait})
+	askPeer := r.Register(AskPeerState{Wait: wait})
+	leave := r.Register(LeaveState{})
+	r.Replace(join, JoinState{
+		MaxAttempts: defaultMaxJoinAttempts,
+		Join:        join,
+		Wait:        wait,
+	})
+	r.Replace(wait, &WaitState{
+		Wait:    wait,
+		Actions: []int{xData, askPeer},
+	})
+	r.Replace(leave, LeaveState{
+		HandOffsPerTick: defaultHandOffsPerTick,
+		MaxTicks:        defaultMaxLeaveTicks,
+		Leave:           leave,
+	})
+	return r
+}
+
+// Register adds a state, returning its identifier.
+func (r *StateRegistry) Register(h StateHandler) int {
+	r.handlers = append(r.handlers, h)
+	return len(r.handlers) - 1
+}
+
+// Replace changes the handler of an already registered state.
+func (r *StateRegistry) Replace(id int, h StateHandler) {
+	r.handlers[id] = h
+}
+
+// Handler returns nil if no state has the identifier.
+func (r *StateRegistry) Handler(id int) StateHandler {
+	if id < 0 || id >= len(r.handlers) {
+		return nil
+	}
+	return r.handlers[id]
+}
+
+func (r *StateRegistry) Len() int {
+	return len(r.handlers)
+}
+
+// Names are in order of identifier.
+func (r *StateRegistry) Names() []string {
+	names := make([]string, len(r.handlers))
+	for i, h := range r.handlers {
+		names[i] = h.Name()
+	}
+	return names
+}
+
+var _ StateHandler = JoinState{}
+
+// JoinState introduces each of a node's positions to a node found by
+// bootstrapping, and waits once any position has a peer. Until then it
+// introduces itself again whenever no introduction awaits an answer, until it
+// has made MaxAttempts. A MaxAttempts of 0 tries until it succeeds.
+type JoinState struct {
+	MaxAttempts int
+	// Join and Wait are the identifiers of this state and of the state
+	// waited in once joined.
+	Join int
+	Wait int
+}
+
+func (JoinState) Name() string {
+	return "join"
+}
+
+func (j JoinState) Apply(n *Node, c Coordinator) (int, string) {
+	if n.hasPeers() {
+		return j.Wait, fmt.Sprintf("Node %s joined and found bootstrap node", n)
+	} else if n.awaiting(MsgHello) {
+		return j.Join, fmt.Sprintf("Node %s is waiting to hear from bootstrap node", n)
+	} else if j.MaxAttempts > 0 && n.joinAttempts >= j.MaxAttempts {
+		return j.Wait, fmt.Sprintf("Node %s did not find bootstrap node and gave up joining", n)
+	}
+	n.joinAttempts++
+	introduced := false
+	for _, p := range n.positions() {
+		o := c.BootstrapNode(n)
+		if o == nil {
+			continue
+		}
+		// Exchange location information as well.
+		//
+		// NODE INTERACTION: PEER HELLO
+		p.request(c, &Message{
+			Kind:     MsgHello,
+			From:     p,
+			To:       o.randomPosition(),
+			Location: p.Location,
+		})
+		introduced = true
+	}
+	if introduced {
+		return j.Join, fmt.Sprintf("Node %s introduced itself to bootstrap node", n)
+	}
+	return j.Join, fmt.Sprintf("Node %s did not find bootstrap node and will retry joining", n)
+}
+
+var _ StateHandler = &WaitState{}
+
+// WaitState is the transition policy between actions: with a chance of the
+// node's WaitActivity it moves on to the action following the last one it
+// applied, otherwise it keeps waiting.
+type WaitState struct {
+	// Wait is the identifier of this state.
+	Wait int
+	// Actions are the states cycled through, in order.
+	Actions []int
+}
+
+func (w *WaitState) Name() string {
+	return "wait"
+}
+
+func (w *WaitState) Apply(n *Node, c Coordinator) (int, string) {
+	// Chance of the node spontaneously doing an action. We simply
+	// attempt to alternate through actions.
+	if len(w.Actions) == 0 || rand.Float64() >= n.WaitActivity {
+		return w.Wait, fmt.Sprintf("Node %s waited", n)
+	}
+	next := w.Actions[0]
+	for i, a := range w.Actions {
+		if a == n.S.lastState {
+			next = w.Actions[(i+1)%len(w.Actions)]
+			break
+		}
+	}
+	name := fmt.Sprintf("%d", next)
+	if h := c.StateHandler(next); h != nil {
+		name = h.Name()
+	}
+	return next, fmt.Sprintf("Node %s will attempt %s", n, name)
+}
+
+var _ StateHandler = ExchangeDataState{}
+
+// ExchangeDataState offers data to a neighbor closer to that data's
+// location, then waits.
+type ExchangeDataState struct {
+	// Wait is the identifier of the state waited in afterwards.
+	Wait int
+}
+
+func (ExchangeDataState) Name() string {
+	return "xData"
+}
+
+func (x ExchangeDataState) Apply(n *Node, c Coordinator) (int, string) {
+	// NODE INTERACTION: EXCHANGE DATA
+	s := ""
+	for i, p := range n.positions() {
+		if i > 0 {
+			s += "; "
+		}
+		s += p.exchangeData(c)
+	}
+	return x.Wait, fmt.Sprintf("Node %s %s", n, s)
+}
+
+var _ StateHandler = AskPeerState{}
+
+// AskPeerState tries asking a peer for a peer, then waits.
+type AskPeerState struct {
+	// Wait is the identifier of the state waited in afterwards.
+	Wait int
+}
+
+func (AskPeerState) Name() string {
+	return "askPeer"
+}
+
+func (a AskPeerState) Apply(n *Node, c Coordinator) (int, string) {
+	// NODE INTERACTION: REQUEST PEER
+	for _, p := range n.positions() {
+		p.requestPeer(c)
+	}
+	return a.Wait, fmt.Sprintf("Node %s asked peer", n)
+}
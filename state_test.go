@@ -0,0 +1,91 @@
+package scr
+
+import (
+	"reflect"
+	"testing"
+)
+
+// restState counts the nodes it is applied to, then has them wait.
+type restState struct {
+	Wait    int
+	applied *int
+}
+
+func (restState) Name() string {
+	return "rest"
+}
+
+func (r restState) Apply(n *Node, c Coordinator) (int, string) {
+	*r.applied++
+	return r.Wait, ""
+}
+
+func TestStateRegistry(t *testing.T) {
+	r := NewStateRegistry()
+	if id := r.Register(AskPeerState{}); id != 0 {
+		t.Fatalf("expected the first state to be 0, got %d", id)
+	}
+	if id := r.Register(ExchangeDataState{}); id != 1 {
+		t.Fatalf("expected the second state to be 1, got %d", id)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("expected 2 states, got %d", r.Len())
+	}
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"askPeer", "xData"}) {
+		t.Fatalf("expected the names in order of identifier, got %v", names)
+	}
+	r.Replace(1, &WaitState{})
+	if _, ok := r.Handler(1).(*WaitState); !ok {
+		t.Fatalf("expected the replaced handler, got %T", r.Handler(1))
+	}
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"askPeer", "wait"}) {
+		t.Fatalf("expected the replaced name, got %v", names)
+	}
+	for _, id := range []int{-1, 2} {
+		if h := r.Handler(id); h != nil {
+			t.Fatalf("expected no handler for %d, got %T", id, h)
+		}
+	}
+}
+
+func TestStateRegistryInAnotherOrder(t *testing.T) {
+	s := newIdleSimulation(2, 0, 1)
+	r := NewStateRegistry()
+	wait := r.Register(&WaitState{})
+	askPeer := r.Register(AskPeerState{Wait: wait})
+	join := r.Register(JoinState{})
+	r.Replace(join, JoinState{Join: join, Wait: wait})
+	r.Replace(wait, &WaitState{Wait: wait, Actions: []int{askPeer}})
+	s.States = r
+	n, o := s.NodeCache[0], s.NodeCache[1]
+	n.WaitActivity = 1
+	n.S = State{id: join}
+	n.addPeerAt(o, o.Location)
+	for _, expected := range []int{wait, askPeer, wait, askPeer} {
+		n.ApplyState(s)
+		if n.NextS.id != expected {
+			t.Fatalf("expected to move from %d to %d, got %d", n.S.id, expected, n.NextS.id)
+		}
+		n.AdvanceState()
+	}
+}
+
+func TestDefaultStateRegistryAddedTo(t *testing.T) {
+	s := newTestSimulation(2, 1, DiscardData)
+	applied := 0
+	rest := s.States.Register(restState{Wait: StateWait, applied: &applied})
+	if names := s.States.Names(); names[rest] != "rest" || len(names) != StateLeave+2 {
+		t.Fatalf("expected the added state after the default ones, got %v", names)
+	}
+	s.States.Handler(StateWait).(*WaitState).Actions = []int{rest}
+	n := s.NodeCache[0]
+	n.WaitActivity = 1
+	n.S = State{id: StateWait}
+	for i := 0; i < 4; i++ {
+		n.ApplyState(s)
+		n.AdvanceState()
+	}
+	if applied != 2 || n.S.id != StateWait {
+		t.Fatalf("expected the added state applied twice between waits, got %d ending in %d", applied, n.S.id)
+	}
+}
@@ -0,0 +1,250 @@
+package scr
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrStoreFull is returned by Store.Put when the Data does not fit.
+var ErrStoreFull = errors.New("store full")
+
+// ErrNotStored is returned by a Store for an address it does not hold.
+var ErrNotStored = errors.New("not stored")
+
+// Store holds the Data of a node, within its capacity.
+type Store interface {
+	// Put stores the Data, or returns ErrStoreFull if it would exceed the
+	// capacity. Putting Data already stored does nothing.
+	Put(d *Data) error
+	// Get is the Data stored at the address.
+	Get(addr Address) (*Data, error)
+	// Read is the content of the Data stored at the address, which is
+	// empty if its bytes were discarded.
+	Read(addr Address) ([]byte, error)
+	Delete(addr Address) error
+	// List is the Data stored, in the order it was put.
+	List() []*Data
+	// Capacity is the most pieces and bytes of Data the store holds,
+	// negative for no limit, and Used is those it holds.
+	Capacity() (items, bytes int)
+	Used() (items, bytes int)
+}
+
+// StoreFactoryFn creates a Store holding at most maxItems pieces and maxBytes
+// bytes of Data.
+type StoreFactoryFn func(maxItems, maxBytes int) Store
+
+// MemoryStoreFactory creates a MemoryStore.
+func MemoryStoreFactory(maxItems, maxBytes int) Store {
+	return NewMemoryStore(maxItems, maxBytes)
+}
+
+// DiskStoreFactory creates a DiskStore for each node in its own directory
+// under dir, numbered in the order they are created. It panics if a directory
+// cannot be used, like the simulation does when its output files cannot be
+// created, or if it is not empty, as Data left by an earlier run was never
+// created by this one.
+func DiskStoreFactory(dir string) StoreFactoryFn {
+	n := 0
+	return func(maxItems, maxBytes int) Store {
+		n++
+		sub := filepath.Join(dir, fmt.Sprint(n))
+		if entries, err := os.ReadDir(sub); err == nil && len(entries) > 0 {
+			panic(fmt.Errorf("%s is not empty", sub))
+		}
+		s, err := NewDiskStore(sub, maxItems, maxBytes)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+// storeIndex is the Data a store holds, by address, in the order it was put.
+type storeIndex struct {
+	maxItems int
+	maxBytes int
+	byAddr   map[string]*Data
+	order    []*Data
+	bytes    int
+}
+
+func newStoreIndex(maxItems, maxBytes int) storeIndex {
+	return storeIndex{
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		byAddr:   make(map[string]*Data),
+	}
+}
+
+// fits is whether the Data would fit, if it is not already held.
+func (x *storeIndex) fits(d *Data) bool {
+	if x.maxItems >= 0 && len(x.order)+1 > x.maxItems {
+		return false
+	}
+	return x.maxBytes < 0 || x.bytes+d.DataSize <= x.maxBytes
+}
+
+func (x *storeIndex) add(d *Data) {
+	x.byAddr[string(d.Address)] = d
+	x.order = append(x.order, d)
+	x.bytes += d.DataSize
+}
+
+func (x *storeIndex) remove(d *Data) {
+	delete(x.byAddr, string(d.Address))
+	for i, e := range x.order {
+		if e == d {
+			x.order = append(x.order[:i], x.order[i+1:]...)
+			break
+		}
+	}
+	x.bytes -= d.DataSize
+}
+
+func (x *storeIndex) Get(addr Address) (*Data, error) {
+	if d, ok := x.byAddr[string(addr)]; ok {
+		return d, nil
+	}
+	return nil, ErrNotStored
+}
+
+func (x *storeIndex) List() []*Data {
+	return append([]*Data(nil), x.order...)
+}
+
+func (x *storeIndex) Capacity() (items, bytes int) {
+	return x.maxItems, x.maxBytes
+}
+
+func (x *storeIndex) Used() (items, bytes int) {
+	return len(x.order), x.bytes
+}
+
+var _ Store = &MemoryStore{}
+
+// MemoryStore holds Data in memory, with whatever of its bytes were kept.
+type MemoryStore struct {
+	storeIndex
+}
+
+func NewMemoryStore(maxItems, maxBytes int) *MemoryStore {
+	return &MemoryStore{newStoreIndex(maxItems, maxBytes)}
+}
+
+func (m *MemoryStore) Put(d *Data) error {
+	if _, ok := m.byAddr[string(d.Address)]; ok {
+		return nil
+	}
+	if !m.fits(d) {
+		return ErrStoreFull
+	}
+	m.add(d)
+	return nil
+}
+
+func (m *MemoryStore) Read(addr Address) ([]byte, error) {
+	d, err := m.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.Bytes(), nil
+}
+
+func (m *MemoryStore) Delete(addr Address) error {
+	d, err := m.Get(addr)
+	if err != nil {
+		return err
+	}
+	m.remove(d)
+	return nil
+}
+
+var _ Store = &DiskStore{}
+
+// DiskStore writes the bytes of each piece of Data to its own file in a
+// directory, named by its address in hex.
+type DiskStore struct {
+	storeIndex
+	Dir string
+}
+
+// NewDiskStore creates the directory if need be, and holds the Data already in
+// it whose content matches its name.
+func NewDiskStore(dir string, maxItems, maxBytes int) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{
+		storeIndex: newStoreIndex(maxItems, maxBytes),
+		Dir:        dir,
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		d := NewData(b)
+		if hex.EncodeToString(d.Address) != e.Name() {
+			continue
+		}
+		if !s.fits(d) {
+			return nil, fmt.Errorf("%s: %w", dir, ErrStoreFull)
+		}
+		s.add(d)
+	}
+	return s, nil
+}
+
+func (s *DiskStore) path(addr Address) string {
+	return filepath.Join(s.Dir, hex.EncodeToString(addr))
+}
+
+func (s *DiskStore) Put(d *Data) error {
+	if _, ok := s.byAddr[string(d.Address)]; ok {
+		return nil
+	}
+	if !s.fits(d) {
+		return ErrStoreFull
+	}
+	// Write then rename, so a crash never leaves a partial file under the
+	// address.
+	tmp := s.path(d.Address) + ".tmp"
+	if err := os.WriteFile(tmp, d.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(d.Address)); err != nil {
+		return err
+	}
+	s.add(d)
+	return nil
+}
+
+func (s *DiskStore) Read(addr Address) ([]byte, error) {
+	if _, err := s.Get(addr); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.path(addr))
+}
+
+func (s *DiskStore) Delete(addr Address) error {
+	d, err := s.Get(addr)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(addr)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.remove(d)
+	return nil
+}
@@ -0,0 +1,156 @@
+package scr
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// storeData is Data that keeps its bytes, to be written to a DiskStore.
+func storeData(s string) *Data {
+	d := NewData([]byte(s))
+	d.bytes = []byte(s)
+	return d
+}
+
+// eachStore runs f against an empty MemoryStore and DiskStore of the
+// capacity.
+func eachStore(t *testing.T, maxItems, maxBytes int, f func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryStore(maxItems, maxBytes))
+	})
+	t.Run("disk", func(t *testing.T) {
+		s, err := NewDiskStore(t.TempDir(), maxItems, maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(t, s)
+	})
+}
+
+func TestStoreFullItems(t *testing.T) {
+	eachStore(t, 1, -1, func(t *testing.T, s Store) {
+		if err := s.Put(storeData("a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(storeData("b")); !errors.Is(err, ErrStoreFull) {
+			t.Fatalf("expected %v, got %v", ErrStoreFull, err)
+		}
+	})
+}
+
+func TestStoreFullBytes(t *testing.T) {
+	eachStore(t, -1, 5, func(t *testing.T, s Store) {
+		if err := s.Put(storeData("abc")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(storeData("def")); !errors.Is(err, ErrStoreFull) {
+			t.Fatalf("expected %v, got %v", ErrStoreFull, err)
+		}
+		if err := s.Put(storeData("gh")); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStorePutIdempotent(t *testing.T) {
+	eachStore(t, 1, -1, func(t *testing.T, s Store) {
+		d := storeData("abc")
+		for i := 0; i < 2; i++ {
+			// The second Put would not fit were it not already stored.
+			if err := s.Put(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if items, bytes := s.Used(); items != 1 || bytes != 3 {
+			t.Fatalf("expected 1 item of 3 bytes, got %d of %d", items, bytes)
+		}
+	})
+}
+
+func TestStoreDelete(t *testing.T) {
+	eachStore(t, -1, -1, func(t *testing.T, s Store) {
+		d := storeData("abc")
+		if err := s.Put(d); err != nil {
+			t.Fatal(err)
+		}
+		b, err := s.Read(d.Address)
+		if err != nil || string(b) != "abc" {
+			t.Fatalf("expected abc, got %q, %v", b, err)
+		}
+		if err := s.Delete(d.Address); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(d.Address); !errors.Is(err, ErrNotStored) {
+			t.Fatalf("expected %v, got %v", ErrNotStored, err)
+		}
+		if err := s.Delete(d.Address); !errors.Is(err, ErrNotStored) {
+			t.Fatalf("expected %v, got %v", ErrNotStored, err)
+		}
+		if items, bytes := s.Used(); items != 0 || bytes != 0 {
+			t.Fatalf("expected nothing used, got %d items of %d bytes", items, bytes)
+		}
+	})
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := storeData("abc"), storeData("defg")
+	for _, d := range []*Data{a, b} {
+		if err := s.Put(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Neither a file not named by its content nor one left mid-Put is
+	// held.
+	misnamed := storeData("misnamed")
+	partial := storeData("partial")
+	if err := os.WriteFile(filepath.Join(dir, "0123abcd"), []byte("misnamed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, hex.EncodeToString(partial.Address)+".tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewDiskStore(dir, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, bytes := s.Used(); items != 2 || bytes != 7 {
+		t.Fatalf("expected 2 items of 7 bytes, got %d of %d", items, bytes)
+	}
+	for _, d := range []*Data{a, b} {
+		got, err := s.Read(d.Address)
+		if err != nil || string(got) != string(d.Bytes()) {
+			t.Fatalf("expected %q, got %q, %v", d.Bytes(), got, err)
+		}
+	}
+	for _, d := range []*Data{misnamed, partial} {
+		if _, err := s.Get(d.Address); !errors.Is(err, ErrNotStored) {
+			t.Fatalf("expected %v, got %v", ErrNotStored, err)
+		}
+	}
+}
+
+func TestDiskStoreFactoryRefusesNonEmpty(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2", "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := DiskStoreFactory(dir)
+	f(-1, -1)
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a non-empty directory")
+		}
+	}()
+	f(-1, -1)
+}
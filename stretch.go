@@ -0,0 +1,155 @@
+package scr
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	stretchLookups = 1000
+)
+
+// peerListStrategy names the kind of peer list, for output files.
+func peerListStrategy(p PeerList) string {
+	switch p.(type) {
+	case *maximizePeerSpread:
+		return "maxSpread"
+	case *closestNeighbors:
+		return "closest"
+	case *maxSpreadThenClosestNeighbors:
+		return "maxSpreadThenClosest"
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// lookup routes greedily from the position towards the location: each hop
+// goes to the online peer believed to be closest to it, until no peer is
+// believed to be closer than the current position. It returns the positions
+// visited, beginning with the first.
+func lookup(from *Node, loc V, maxHops int) []*Node {
+	path := []*Node{from}
+	at := from
+	for len(path) <= maxHops {
+		var next *Node
+		minDist := at.Location.GreatCircleDistance(loc)
+		at.peers.IterateOverPeersWith(func(o *Node) {
+			// Departed peers are skipped, as if they did not answer.
+			if o == nil || o.physical().departed {
+				return
+			}
+			l, ok := at.peers.PeerLocation(o)
+			if !ok {
+				return
+			}
+			if dist := l.GreatCircleDistance(loc); dist < minDist {
+				next = o
+				minDist = dist
+			}
+		})
+		if next == nil {
+			break
+		}
+		path = append(path, next)
+		at = next
+	}
+	return path
+}
+
+// pathLatency sums the latency of each hop of the path.
+func (s *Simulation) pathLatency(path []*Node) (ms float64) {
+	for j := 1; j < len(path); j++ {
+		ms += s.Space.Latency(path[j-1].physical().Host, path[j].physical().Host)
+	}
+	return
+}
+
+// stretchStats are of the lookups from nodes with one kind of peer list.
+type stretchStats struct {
+	lookups       int
+	found         int
+	hops          int
+	pathLatency   float64
+	directLatency float64
+	stretches     []float64
+}
+
+// computeStretch looks up Data held by random nodes from other random nodes,
+// and writes how much longer, in latency, the path to the Data found is than
+// going directly to the node holding it. Lookups are grouped by the kind of
+// peer list of the node they start from, and made from the nodes of each
+// class in turn for the files of that class.
+func (s *Simulation) computeStretch(i int) {
+	all := s.nodesOfClass(nil)
+	for j, mf := range s.metrics {
+		s.writeStretch(i, mf, s.nodesOfClass(s.metricsClass(j)), all)
+	}
+}
+
+// writeStretch writes the stretch of lookups from the nodes in from to Data
+// held by any of the nodes in to.
+func (s *Simulation) writeStretch(i int, mf *metricFiles, from, to []*Node) {
+	if len(from) == 0 || len(to) < 2 {
+		return
+	}
+	stats := make(map[string]*stretchStats)
+	for j := 0; j < stretchLookups; j++ {
+		src := from[rand.Intn(len(from))].randomPosition()
+		dst := to[rand.Intn(len(to))]
+		if src.physical() == dst {
+			continue
+		}
+		locs := dst.getDataLocations()
+		if len(locs) == 0 {
+			continue
+		}
+		direct := s.Space.Latency(src.physical().Host, dst.Host)
+		if direct <= 0 {
+			continue
+		}
+		name := peerListStrategy(src.peers)
+		st, ok := stats[name]
+		if !ok {
+			st = &stretchStats{}
+			stats[name] = st
+		}
+		st.lookups++
+		loc := locs[rand.Intn(len(locs))]
+		path := lookup(src, loc, len(s.NodeCache))
+		if path[len(path)-1].physical() != dst {
+			continue
+		}
+		ms := s.pathLatency(path)
+		st.found++
+		st.hops += len(path) - 1
+		st.pathLatency += ms
+		st.directLatency += direct
+		st.stretches = append(st.stretches, ms/direct)
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Output to file
+	f := mf.createTickFile("stretch", i, "strategy,lookups,found,meanHops,meanPathLatency,meanDirectLatency,meanStretch,medianStretch,p90Stretch")
+	defer f.Close()
+	for _, name := range names {
+		st := stats[name]
+		var meanHops, meanPath, meanDirect, mean, median, p90 float64
+		if st.found > 0 {
+			n := float64(st.found)
+			meanHops = float64(st.hops) / n
+			meanPath = st.pathLatency / n
+			meanDirect = st.directLatency / n
+			sort.Float64s(st.stretches)
+			for _, x := range st.stretches {
+				mean += x
+			}
+			mean /= n
+			median = st.stretches[len(st.stretches)/2]
+			p90 = st.stretches[len(st.stretches)*9/10]
+		}
+		fmt.Fprintf(f, "%s,%d,%d,%v,%v,%v,%v,%v,%v\n", name, st.lookups, st.found, meanHops, meanPath, meanDirect, mean, median, p90)
+	}
+}
@@ -0,0 +1,65 @@
+package scr
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSimulationWritesStretch(t *testing.T) {
+	m, err := ReadLatencyMatrix(strings.NewReader(testLatencyMatrix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newIdleSimulation(3, 0, 1)
+	s.SetLatencySpace(m)
+	src, mid, dst := s.NodeCache[0], s.NodeCache[1], s.NodeCache[2]
+	for h, n := range []*Node{src, mid, dst} {
+		n.Host = Host(h)
+	}
+	// The Data is only reached through mid.
+	loc := V{0, 0, 1}
+	dst.Store = NewMemoryStore(-1, -1)
+	if !dst.keep(testData("d", loc)) {
+		t.Fatal("expected the store to take the Data")
+	}
+	src.Location = V{1, 0, 0}
+	mid.Location = V{1, 0, 1}.Unit()
+	dst.Location = loc
+	src.addPeerAt(mid, mid.Location)
+	mid.addPeerAt(dst, dst.Location)
+	path := lookup(src, loc, len(s.NodeCache))
+	if len(path) != 3 || path[1] != mid || path[2] != dst {
+		t.Fatalf("expected the lookup to go through mid to dst, got %v", path)
+	}
+	if ms := s.pathLatency(path); ms != 20 {
+		t.Fatalf("expected a path latency of 20ms, got %v", ms)
+	}
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	s.writeStretch(0, &metricFiles{}, []*Node{src}, []*Node{src, dst})
+	b, err := os.ReadFile("stretch_0.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line for the one kind of peer list, got %q", lines)
+	}
+	fields := strings.Split(lines[1], ",")
+	if fields[0] != "maxSpread" || fields[1] != fields[2] {
+		t.Fatalf("expected every lookup from maxSpread found, got %q", lines[1])
+	}
+	// Hops, path and direct latency, and the mean, median and 90th
+	// percentile stretch.
+	if expected := []string{"2", "20", "5", "4", "4", "4"}; strings.Join(fields[3:], ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, fields[3:])
+	}
+}
@@ -0,0 +1,221 @@
+package scr
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+const (
+	defaultSybilReportEvery = 100
+	sybilLookups            = 1000
+	maxSybilClaims          = 64
+)
+
+var _ Tocker = &SybilAttack{}
+
+// SybilAttack is a Tocker that injects many colluding nodes around a target
+// location, to eclipse it. The attackers stay where they are placed instead of
+// moving to their data, accept Data and then drop it, answer requests for peers
+// only with each other, and claim to hold the last Data they captured. Asked to
+// prove they store Data, they say it moved on without naming where.
+//
+// It periodically writes sybil.txt with the fraction of lookups into the target
+// region that reach an attacker, of the region's Data the attackers have
+// captured, and of honest nodes that distrust an attacker, grouped by the kind
+// of peer list of the honest nodes.
+type SybilAttack struct {
+	// At is the iteration the attackers join.
+	At int
+	// N attackers are placed within Radius radians of Target.
+	N      int
+	Target V
+	Radius float64
+	// PeerListFactoryFn is of the attackers. If nil, it is that of the
+	// simulation's first class.
+	PeerListFactoryFn PeerListFactoryFn
+	// ReportEvery is the number of iterations between reports.
+	ReportEvery int
+
+	class     *NodeClass
+	attackers []*Node
+	// Data captured in the target region, by the kind of peer list of the
+	// node that gave it away.
+	captured map[string]int
+	claims   []Address
+	f        *os.File
+}
+
+func (a *SybilAttack) Tock(s *Simulation, i int) {
+	if i == a.At {
+		a.inject(s)
+	}
+	every := a.ReportEvery
+	if every <= 0 {
+		every = defaultSybilReportEvery
+	}
+	if i > a.At && (i-a.At)%every == 0 && !s.vizOnly {
+		a.report(s, i)
+	}
+}
+
+func (a *SybilAttack) inject(s *Simulation) {
+	peerListFn := a.PeerListFactoryFn
+	if peerListFn == nil {
+		peerListFn = s.Classes[0].PeerListFactoryFn
+	}
+	a.class = &NodeClass{
+		Name:                         "sybil",
+		NodeInitialDataFactoryFn:     func() func(int) int { return func(int) int { return 0 } },
+		AllocateNDataToNodeFactoryFn: func() func(int) int { return func(int) int { return 0 } },
+		NodeMaxBSizeFactoryFn:        func() func(int) int { return func(int) int { return math.MaxInt32 } },
+		WaitActivityFactoryFn:        func() func() float64 { return func() float64 { return 1 } },
+		PeerListFactoryFn:            peerListFn,
+	}
+	a.captured = make(map[string]int)
+	for j := 0; j < a.N; j++ {
+		idx := -1
+		for k, n := range s.NodeCache {
+			if n == nil {
+				idx = k
+				break
+			}
+		}
+		if idx < 0 {
+			return
+		}
+		n := s.createNode(a.class)
+		n.sybil = a
+		for _, p := range n.positions() {
+			p.Location = a.randomLocation()
+		}
+		n.Location = n.positions()[0].Location
+		s.NodeCache[idx] = n
+		a.attackers = append(a.attackers, n)
+	}
+}
+
+// randomLocation is uniformly within the target region.
+func (a *SybilAttack) randomLocation() V {
+	u := RandomVector()
+	t := u.Sub(a.Target.MulScalar(u.Dot(a.Target)))
+	if t.Norm() == 0 {
+		return a.Target
+	}
+	// The area within an angle grows with 1-cos of it.
+	c := 1 - rand.Float64()*(1-math.Cos(a.Radius))
+	angle := math.Acos(c)
+	return a.Target.MulScalar(math.Cos(angle)).Add(t.Unit().MulScalar(math.Sin(angle)))
+}
+
+func (a *SybilAttack) inRegion(loc V) bool {
+	return a.Target.GreatCircleDistance(loc) <= a.Radius
+}
+
+// capture drops the Data an attacker was given, keeping its address to claim.
+func (a *SybilAttack) capture(m *Message) {
+	if a.inRegion(m.Data.Location) {
+		a.captured[m.From.physical().strategy()]++
+	}
+	a.claims = append(a.claims, m.Data.Address)
+	if len(a.claims) > maxSybilClaims {
+		a.claims = a.claims[1:]
+	}
+}
+
+// colluder is a random online attacker other than the node, or nil.
+func (a *SybilAttack) colluder(not *Node) *Node {
+	var others []*Node
+	for _, o := range a.attackers {
+		if o != not.physical() && !o.departed {
+			others = append(others, o)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return others[rand.Intn(len(others))].randomPosition()
+}
+
+// strategy is the kind of peer list of the node.
+func (n *Node) strategy() string {
+	return peerListStrategy(n.positions()[0].peers)
+}
+
+// report writes, for each kind of peer list, the lookups into the target
+// region from honest nodes that reached an attacker, and the Data in the
+// region captured by the attackers out of all that is held or was captured,
+// and the honest nodes that have caught an attacker.
+func (a *SybilAttack) report(s *Simulation, i int) {
+	if a.f == nil {
+		a.f = createMetricFile("sybil", "", "iter,strategy,lookups,capturedLookups,lookupFraction,regionData,capturedData,dataFraction,nodes,distrusting")
+	}
+	var honest []*Node
+	for _, n := range s.NodeCache {
+		if n != nil && n.sybil == nil {
+			honest = append(honest, n)
+		}
+	}
+	if len(honest) == 0 {
+		return
+	}
+	lookups := make(map[string]int)
+	capturedLookups := make(map[string]int)
+	for j := 0; j < sybilLookups; j++ {
+		src := honest[rand.Intn(len(honest))].randomPosition()
+		name := peerListStrategy(src.peers)
+		lookups[name]++
+		for _, p := range lookup(src, a.randomLocation(), len(s.NodeCache))[1:] {
+			if p.physical().sybil != nil {
+				capturedLookups[name]++
+				break
+			}
+		}
+	}
+	held := make(map[string]int)
+	seen := make(map[*Data]bool)
+	for _, n := range honest {
+		for _, d := range n.Store.List() {
+			if !seen[d] && a.inRegion(d.Location) {
+				seen[d] = true
+				held[n.strategy()]++
+			}
+		}
+	}
+	nodes := make(map[string]int)
+	distrusting := make(map[string]int)
+	for _, n := range honest {
+		nodes[n.strategy()]++
+		for _, o := range a.attackers {
+			if n.distrusts(o) {
+				distrusting[n.strategy()]++
+				break
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for _, m := range []map[string]int{lookups, held, a.captured} {
+		for name := range m {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		var lookupFraction float64
+		var dataFraction float64
+		if lookups[name] > 0 {
+			lookupFraction = float64(capturedLookups[name]) / float64(lookups[name])
+		}
+		regionData := held[name] + a.captured[name]
+		if regionData > 0 {
+			dataFraction = float64(a.captured[name]) / float64(regionData)
+		}
+		fmt.Fprintf(a.f, "%v,%v,%v,%v,%v,%v,%v,%v,%v,%v\n", i, name, lookups[name], capturedLookups[name], lookupFraction, regionData, a.captured[name], dataFraction, nodes[name], distrusting[name])
+	}
+}
@@ -0,0 +1,49 @@
+package scr
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSybilAttackRandomLocationInRegion(t *testing.T) {
+	for _, radius := range []float64{0.01, 0.1, 1} {
+		a := &SybilAttack{Target: RandomVector(), Radius: radius}
+		for i := 0; i < 1000; i++ {
+			if loc := a.randomLocation(); !a.inRegion(loc) || math.Abs(loc.Norm()-1) > 1e-9 {
+				t.Fatalf("expected a unit vector within %v of %s, got %s at %v", radius, a.Target, loc, a.Target.GreatCircleDistance(loc))
+			}
+		}
+	}
+}
+
+func TestSybilAttackersOnlyGiveColluders(t *testing.T) {
+	s := newIdleSimulation(4, 1, 1)
+	s.NodeCache = append(s.NodeCache, make([]*Node, 3)...)
+	honest := append([]*Node(nil), s.NodeCache[:4]...)
+	a := &SybilAttack{N: 3, Target: V{0, 0, 1}, Radius: 0.1}
+	a.inject(s)
+	if len(a.attackers) != 3 {
+		t.Fatalf("expected 3 attackers, got %d", len(a.attackers))
+	}
+	for _, attacker := range a.attackers {
+		if !a.inRegion(attacker.Location) || attacker.sybil != a {
+			t.Fatalf("expected the attacker in the target region, got %s", attacker.Location)
+		}
+		// Attackers know honest peers too, but do not give them away.
+		for _, o := range honest {
+			attacker.addPeerAt(o, o.Location)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		attacker := a.attackers[i%len(a.attackers)]
+		r := attacker.answerPeerRequest(&Message{
+			Kind:     MsgPeerRequest,
+			From:     honest[0],
+			To:       attacker,
+			Location: honest[0].Location,
+		})
+		if r.Peer == nil || r.Peer.physical().sybil != a || r.Peer.physical() == attacker {
+			t.Fatalf("expected another attacker, got %v", r.Peer)
+		}
+	}
+}
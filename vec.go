@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"math/rand"
 )
 
 // V is a vector
@@ -94,6 +95,38 @@ func (v V) RawBytes() []byte {
 	return buf[:]
 }
 
+// VFromRawBytes is the vector of RawBytes.
+func VFromRawBytes(b []byte) (V, error) {
+	if len(b) != 24 {
+		return V{}, fmt.Errorf("raw vector is %d bytes, not 24", len(b))
+	}
+	return V{
+		X: math.Float64frombits(binary.BigEndian.Uint64(b[:8])),
+		Y: math.Float64frombits(binary.BigEndian.Uint64(b[8:16])),
+		Z: math.Float64frombits(binary.BigEndian.Uint64(b[16:])),
+	}, nil
+}
+
+// LatLonToV is the point on the unit sphere at the given latitude and
+// longitude, in degrees.
+func LatLonToV(lat, lon float64) V {
+	latR := lat * math.Pi / 180
+	lonR := lon * math.Pi / 180
+	return V{
+		X: math.Cos(latR) * math.Cos(lonR),
+		Y: math.Cos(latR) * math.Sin(lonR),
+		Z: math.Sin(latR),
+	}
+}
+
+// LatLon is the latitude and longitude of a point on the unit sphere, in
+// degrees.
+func (v V) LatLon() (lat, lon float64) {
+	lat = math.Asin(math.Max(-1, math.Min(1, v.Z))) * 180 / math.Pi
+	lon = math.Atan2(v.Y, v.X) * 180 / math.Pi
+	return
+}
+
 func (v V) Project() (x, y float64) {
 	x = v.X / (1 - v.Z)
 	y = v.Y / (1 - v.Z)
@@ -137,6 +170,16 @@ func (v V) Equals(o V) bool {
 // Random unit vector on a sphere
 // TODO: needed?
 func RandomVector() V {
+	return randomVector(rand.Float64)
+}
+
+// RandomVectorFrom is RandomVector drawing from the given source instead of
+// the global one.
+func RandomVectorFrom(r *rand.Rand) V {
+	return randomVector(r.Float64)
+}
+
+func randomVector(float64Fn func() float64) V {
 	v := V{X: 1}
-	return v.Rotate(RandomQuaternion())
+	return v.Rotate(randomQuaternion(float64Fn))
 }
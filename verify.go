@@ -0,0 +1,181 @@
+package scr
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+)
+
+const (
+	// The fraction of a sample a verified peer must produce.
+	minSampleProduced = 0.5
+)
+
+// Verification has nodes check that the Location a peer claims is plausible
+// for the Data it claims to hold. A node asks a peer for the addresses of the
+// Data at its position, picks a random sample of them and asks for that Data.
+// The peer fails if it does not produce every piece sampled, or if its claimed
+// Location is a much worse facility for the sample than the one recomputed
+// from it. A node that fails is distrusted: it is dropped from every peer list
+// of the verifier, and never added again.
+type Verification struct {
+	// Interval is the ticks between a node verifying one of its peers.
+	Interval int
+	// Sample is the number of claimed addresses asked for, at least 1.
+	Sample int
+	// Tolerance is how much farther, in mean radians, the claimed Location
+	// may be from the sampled Data than the recomputed location is.
+	Tolerance float64
+}
+
+// verifyPeer asks a random peer of a random position for its claim, once
+// every interval ticks.
+func (n *Node) verifyPeer(c Coordinator, now int, v *Verification) {
+	if n.lastVerify == 0 {
+		// Spread the verifications of nodes over the interval.
+		n.lastVerify = now - rand.Intn(v.Interval)
+	}
+	if now-n.lastVerify < v.Interval {
+		return
+	}
+	n.lastVerify = now
+	p := n.randomPosition()
+	o := p.peers.GetRandomPeer()
+	if o == nil {
+		return
+	}
+	// NODE INTERACTION: CLAIM REQUEST
+	p.request(c, &Message{
+		Kind:     MsgClaimRequest,
+		From:     p,
+		To:       o,
+		Location: p.Location,
+	})
+}
+
+// answerClaim answers with the addresses of the Data at this position.
+func (n *Node) answerClaim(m *Message) *Message {
+	r := m.reply(MsgClaimResponse)
+	if a := n.physical().sybil; a != nil {
+		// Attackers claim what they captured.
+		r.Addresses = append([]Address(nil), a.claims...)
+		return r
+	}
+	for _, d := range n.held() {
+		r.Addresses = append(r.Addresses, d.Address)
+	}
+	return r
+}
+
+// claimAnswered asks for a sample of the claimed Data. A claim of no Data
+// cannot be checked, as such a node is free to be anywhere.
+func (n *Node) claimAnswered(c Coordinator, m *Message, v *Verification) (unverifiable bool) {
+	n.peerSeen(m.From)
+	if len(m.Addresses) == 0 {
+		return true
+	}
+	// A sample of nothing would pass or fail the peer on nothing.
+	size := v.Sample
+	if size < 1 {
+		size = 1
+	}
+	sample := make([]Address, 0, size)
+	for _, j := range rand.Perm(len(m.Addresses)) {
+		if len(sample) >= size {
+			break
+		}
+		sample = append(sample, m.Addresses[j])
+	}
+	// NODE INTERACTION: SAMPLE REQUEST
+	n.request(c, &Message{
+		Kind:      MsgSampleRequest,
+		From:      n,
+		To:        m.From,
+		Location:  n.Location,
+		Addresses: sample,
+	})
+	return false
+}
+
+// answerSample answers with the Data asked for that this position holds.
+func (n *Node) answerSample(m *Message) *Message {
+	r := m.reply(MsgSampleResponse)
+	if n.physical().sybil != nil {
+		// Captured Data was dropped.
+		return r
+	}
+	for _, addr := range m.Addresses {
+		if d, err := n.physical().Store.Get(addr); err == nil {
+			r.Sample = append(r.Sample, d)
+		}
+	}
+	return r
+}
+
+// sampleAnswered checks the sample against the request for it, distrusting
+// the peer if it fails. Honest peers may have exchanged away some of the Data
+// between the claim and the sample, so only minSampleProduced of it need be
+// produced.
+func (n *Node) sampleAnswered(req, m *Message, v *Verification) (passed bool) {
+	locs := make([]V, 0, len(req.Addresses))
+	for _, addr := range req.Addresses {
+		for _, d := range m.Sample {
+			if bytes.Equal(d.Address, addr) {
+				// The address alone says where the Data belongs.
+				locs = append(locs, AddressToPosition(addr))
+				break
+			}
+		}
+	}
+	produced := float64(len(locs)) / float64(len(req.Addresses))
+	if produced >= minSampleProduced && n.plausible(m.Location, locs, v.Tolerance) {
+		n.peerSeen(m.From)
+		return true
+	}
+	n.physical().distrust(m.From)
+	return false
+}
+
+// plausible is whether claim is within tolerance of being as good a location
+// for the Data at locs as the one this node computes for them.
+func (n *Node) plausible(claim V, locs []V, tolerance float64) bool {
+	weights := make([]float64, len(locs))
+	for j := range weights {
+		weights[j] = 1
+	}
+	_, fx, _, _, err := SolveNonEuclideanMultifacilityLocationMonteCarloParallel(
+		n.physical().solverContext(),
+		locs,
+		weights,
+		0.1, 0.1,
+		2,
+		n.physical().SolverWorkers,
+		n.physical().solverSeed(),
+		n.physical().SolverBudget)
+	if err != nil && !errors.Is(err, ErrBudgetExhausted) {
+		// Give the benefit of the doubt.
+		return true
+	}
+	claimFx, _ := geodesicDistances(claim, locs, weights)
+	return (claimFx-fx)/float64(len(locs)) <= tolerance
+}
+
+// distrust drops every position of the node from the peer lists of this
+// node's positions, and refuses to add it again.
+func (n *Node) distrust(o *Node) {
+	o = o.physical()
+	if n.distrusted == nil {
+		n.distrusted = make(map[*Node]bool)
+	}
+	n.distrusted[o] = true
+	for _, p := range n.positions() {
+		for _, q := range o.positions() {
+			p.peers.RemovePeer(q)
+		}
+	}
+}
+
+// distrusts is whether the node failed a verification by this node.
+func (n *Node) distrusts(o *Node) bool {
+	return n.physical().distrusted[o.physical()]
+}
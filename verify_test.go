@@ -0,0 +1,58 @@
+package scr
+
+import (
+	"testing"
+)
+
+// verifyClaim has the verifier ask the peer for its claim, and runs the
+// simulation until the verification is done.
+func verifyClaim(s *Simulation, verifier, peer *Node) {
+	verifier.addPeerAt(peer, peer.Location)
+	verifier.request(s, &Message{
+		Kind:     MsgClaimRequest,
+		From:     verifier,
+		To:       peer,
+		Location: verifier.Location,
+	})
+	// The claim, the request for a sample and the sample each take a tick.
+	runTicks(s, 1, 5)
+}
+
+func TestVerificationPassesHonestPeer(t *testing.T) {
+	for _, sample := range []int{-1, 0, 1, 3} {
+		// The peer is where its only Data is, however much of it is
+		// sampled.
+		s := newIdleSimulation(2, 1, 1)
+		s.Verification = &Verification{Sample: sample, Tolerance: 0.25}
+		verifier, peer := s.NodeCache[0], s.NodeCache[1]
+		verifyClaim(s, verifier, peer)
+		if s.VerificationsPassed != 1 || s.VerificationsFailed != 0 || verifier.distrusts(peer) {
+			t.Fatalf("sample %d: expected the honest peer to pass, got %d passed and %d failed",
+				sample, s.VerificationsPassed, s.VerificationsFailed)
+		}
+	}
+}
+
+func TestVerificationDistrustsPeerClaimingCapturedData(t *testing.T) {
+	s := newIdleSimulation(3, 5, 1)
+	s.Verification = &Verification{Sample: 3, Tolerance: 0.25}
+	verifier, attacker, victim := s.NodeCache[0], s.NodeCache[1], s.NodeCache[2]
+	// The attacker claims Data it was given and dropped.
+	a := &SybilAttack{}
+	for _, d := range victim.Store.List() {
+		a.claims = append(a.claims, d.Address)
+	}
+	attacker.sybil = a
+	attacker.Location = victim.Location
+	verifyClaim(s, verifier, attacker)
+	if s.VerificationsPassed != 0 || s.VerificationsFailed != 1 || !verifier.distrusts(attacker) {
+		t.Fatalf("expected the attacker to fail, got %d passed and %d failed", s.VerificationsPassed, s.VerificationsFailed)
+	}
+	if verifier.hasPeers() {
+		t.Fatal("expected the attacker dropped from the verifier's peers")
+	}
+	verifier.addPeerAt(attacker, attacker.Location)
+	if verifier.hasPeers() {
+		t.Fatal("expected the attacker never to be added again")
+	}
+}
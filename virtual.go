@@ -0,0 +1,107 @@
+package scr
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// NewVirtualNode creates a node that splits its data into one cluster per
+// peer list, and presents a virtual position at the center of each cluster
+// instead of a single compromise Location. Each virtual position knows its own
+// peers, and exchanges only the data in its cluster.
+//
+// The virtual positions share the node's state and Store. Its
+// Location is that of the first virtual position, and its f(X) is summed over
+// all of them.
+func NewVirtualNode(
+	store Store,
+	waitActivity float64,
+	solverBudget Budget,
+	peerLists []PeerList) *Node {
+	n := &Node{
+		S:            State{id: StateJoin},
+		Store:        store,
+		WaitActivity: waitActivity,
+		SolverBudget: solverBudget,
+		virtuals:     make([]*Node, len(peerLists)),
+	}
+	for i, pl := range peerLists {
+		n.virtuals[i] = &Node{
+			peers:  pl,
+			parent: n,
+		}
+	}
+	n.computeLocation()
+	return n
+}
+
+// physical is the node presenting this position.
+func (n *Node) physical() *Node {
+	if n.parent != nil {
+		return n.parent
+	}
+	return n
+}
+
+// positions are the virtual positions of this node, or the node itself if it
+// has none.
+func (n *Node) positions() []*Node {
+	if len(n.virtuals) > 0 {
+		return n.virtuals
+	}
+	return []*Node{n}
+}
+
+func (n *Node) randomPosition() *Node {
+	if len(n.virtuals) > 0 {
+		return n.virtuals[rand.Intn(len(n.virtuals))]
+	}
+	return n
+}
+
+// computeVirtualLocations clusters the data and moves each virtual position
+// to the center of a cluster. Virtual positions without a cluster stay where
+// they are.
+func (n *Node) computeVirtualLocations(locs []V, weights []float64, data []*Data) {
+	for _, p := range n.virtuals {
+		p.assigned = p.assigned[:0]
+		if p.Location.Equals(V{}) {
+			p.Location = RandomVector()
+		}
+	}
+	n.Location = n.virtuals[0].Location
+	n.fx = 0
+	n.fxsq = 0
+	n.nfx = 0
+	if len(locs) == 0 {
+		return
+	}
+	centers, assignment, fx, fxsq, err := SolveSphericalPMedian(
+		n.solverContext(),
+		locs,
+		weights,
+		len(n.virtuals),
+		0.1, 0.1,
+		n.SolverWorkers,
+		n.solverSeed(),
+		n.SolverBudget)
+	if errors.Is(err, ErrBudgetExhausted) {
+		n.budgetExhausted++
+	} else if err != nil {
+		// Keep all data on the first position rather than halt the
+		// simulation.
+		centers = []V{n.virtuals[0].Location}
+		assignment = make([]int, len(locs))
+		fx, fxsq = geodesicDistances(centers[0], locs, weights)
+	}
+	for c, center := range centers {
+		n.virtuals[c].Location = center
+	}
+	for j, c := range assignment {
+		n.virtuals[c].assigned = append(n.virtuals[c].assigned, data[j])
+	}
+	n.Location = n.virtuals[0].Location
+	n.fx = fx
+	n.fxsq = fxsq
+	n.nfx = len(locs)
+}
@@ -0,0 +1,440 @@
+package scr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+//go:generate go test -run TestWireSpec -update_wire_spec
+
+// WireVersion is the version of the wire format written, and the only one
+// read.
+const WireVersion = 1
+
+const (
+	// The most bytes of a frame following its length.
+	maxFrameBytes = 64 << 20
+	// The most bytes of Data a frame carries, leaving room for the rest of
+	// the frame.
+	maxWireData = maxFrameBytes - 1<<20
+)
+
+var (
+	errWireVersion    = errors.New("unsupported wire version")
+	errWireKind       = errors.New("unsupported message kind")
+	errMalformedFrame = errors.New("malformed frame")
+	errFrameTooLarge  = errors.New("frame too large")
+)
+
+// wireHeader begins every frame, and is followed by the body of its kind.
+type wireHeader struct {
+	Version  uint8  `doc:"The version of the wire format, which is WireVersion."`
+	Kind     uint8  `doc:"The kind of message, which decides the body."`
+	Seq      uint64 `doc:"Identifies a request, and is repeated in its response."`
+	From     string `doc:"The address the sender listens on, which it is known by."`
+	Location V      `doc:"Where the sender was when it sent the message."`
+}
+
+type wirePeerResponse struct {
+	Peer         string `doc:"The address of the peer given, empty if there was none."`
+	PeerLocation V      `doc:"Where the responder believes the peer is."`
+}
+
+type wireData struct {
+	HandOff  bool   `doc:"Whether the sender is leaving, and takes nothing in return."`
+	BSize    int64  `doc:"The bytes of Data the sender stores."`
+	MaxBSize int64  `doc:"The most bytes of Data the sender may store, negative for no limit."`
+	Data     []byte `doc:"The content of the Data offered."`
+}
+
+type wireDataAck struct {
+	HandOff  bool    `doc:"Repeated from the data message."`
+	Accepted bool    `doc:"Whether the receiver now holds the Data."`
+	Address  Address `doc:"The address of the Data acknowledged."`
+	Swap     []byte  `doc:"The content of the Data given in return, empty if there is none."`
+}
+
+type wireFetch struct {
+	Address Address `doc:"The address of the Data asked for."`
+}
+
+// wireFetchResponse ends with the Data, so that it can be streamed.
+type wireFetchResponse struct {
+	Peer         string `doc:"If the responder does not hold the Data, the address of its peer closest to it, empty if it has none."`
+	PeerLocation V      `doc:"Where the responder believes the peer is."`
+	Data         []byte `doc:"The content of the Data, empty if the responder does not hold it."`
+}
+
+// wireKinds are the kinds of message spoken, with their bodies, nil for none.
+var wireKinds = []struct {
+	kind MessageKind
+	body interface{}
+	doc  string
+}{
+	{MsgHello, nil, "Introduces the sender, which the receiver adds as a peer. Answered by helloAck."},
+	{MsgHelloAck, nil, "Answers hello, and the sender of hello adds the receiver as a peer."},
+	{MsgPeerRequest, nil, "Asks for one of the receiver's peers. Answered by peerResponse."},
+	{MsgPeerResponse, wirePeerResponse{}, "Answers peerRequest with a peer other than the requester."},
+	{MsgData, wireData{}, "Offers the receiver Data closer to it than to the sender. Answered by dataAck."},
+	{MsgDataAck, wireDataAck{}, "Answers data. The sender of data forgets the Data if it was accepted, and keeps any swap in its place."},
+	{MsgLocation, nil, "Tells a peer the sender has moved. Not answered."},
+	{MsgLocationRequest, nil, "Asks for the receiver's location. Answered by locationResponse."},
+	{MsgLocationResponse, nil, "Answers locationRequest with the location in the header."},
+	{MsgFetch, wireFetch{}, "Asks for Data by address, for a client. Answered by fetchResponse on the same connection."},
+	{MsgFetchResponse, wireFetchResponse{}, "Answers fetch with the Data, or with where to ask next."},
+}
+
+// wireTypes are the encodings of the types of fields.
+var wireTypes = []struct {
+	t    reflect.Type
+	name string
+	doc  string
+}{
+	{reflect.TypeOf(false), "bool", "1 byte, 0 for false or 1 for true."},
+	{reflect.TypeOf(uint8(0)), "u8", "1 byte."},
+	{reflect.TypeOf(uint64(0)), "u64", "8 bytes, big-endian."},
+	{reflect.TypeOf(int64(0)), "i64", "8 bytes, big-endian two's complement."},
+	{reflect.TypeOf(""), "string", "A u16 length then that many bytes of UTF-8."},
+	{reflect.TypeOf(V{}), "location", "24 bytes: X, Y then Z as big-endian IEEE 754 doubles, which must be finite. See V.RawBytes."},
+	{reflect.TypeOf(Address(nil)), "address", "A u8 length then that many bytes."},
+	{reflect.TypeOf([]byte(nil)), "payload", fmt.Sprintf("A u32 length of at most %d MiB, then that many bytes.", maxWireData>>20)},
+}
+
+func wireBody(kind MessageKind) (body interface{}, ok bool) {
+	for _, k := range wireKinds {
+		if k.kind == kind {
+			return k.body, true
+		}
+	}
+	return nil, false
+}
+
+// encodeFrame is the header and body, preceded by their length as a u32.
+func encodeFrame(h wireHeader, body interface{}) ([]byte, error) {
+	h.Version = WireVersion
+	b, err := appendWire(make([]byte, 4, 64), reflect.ValueOf(h))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if b, err = appendWire(b, reflect.ValueOf(body)); err != nil {
+			return nil, err
+		}
+	}
+	if len(b)-4 > maxFrameBytes {
+		return nil, errFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b, nil
+}
+
+// readFrame reads a frame's length, and the frame following it.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameBytes {
+		return nil, errFrameTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readFrameHeader reads a frame's length and header from r, returning a reader
+// of the rest of the frame from r, to read a body too large to hold at once.
+func readFrameHeader(r io.Reader) (h wireHeader, rest *wireReader, err error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return h, nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameBytes {
+		return h, nil, errFrameTooLarge
+	}
+	rest = &wireReader{r: r, left: int(n)}
+	if err := readWire(rest, reflect.ValueOf(&h).Elem()); err != nil {
+		return h, nil, err
+	}
+	if h.Version != WireVersion {
+		return h, nil, fmt.Errorf("%w: %d", errWireVersion, h.Version)
+	}
+	return h, rest, nil
+}
+
+// readFetchResponse reads a fetchResponse frame from r as far as its Data,
+// returning the response without it and the length of the Data, which is
+// left to be read from r.
+func readFetchResponse(r io.Reader) (f wireFetchResponse, dataLen int, err error) {
+	h, rest, err := readFrameHeader(r)
+	if err != nil {
+		return f, 0, err
+	}
+	if MessageKind(h.Kind) != MsgFetchResponse {
+		return f, 0, fmt.Errorf("%w: %s answering fetch", errWireKind, MessageKind(h.Kind))
+	}
+	v := reflect.ValueOf(&f).Elem()
+	if err := readWireFields(rest, v, v.NumField()-1); err != nil {
+		return f, 0, err
+	}
+	if dataLen, err = rest.length(4); err != nil {
+		return f, 0, err
+	}
+	if dataLen != rest.left {
+		return f, 0, fmt.Errorf("%w: data of %d bytes in %d", errMalformedFrame, dataLen, rest.left)
+	}
+	return f, dataLen, nil
+}
+
+// decodeFrame decodes a frame read by readFrame. The body is nil for kinds
+// without one.
+func decodeFrame(b []byte) (h wireHeader, body interface{}, err error) {
+	if len(b) == 0 {
+		return h, nil, errMalformedFrame
+	}
+	// Later versions may lay out the rest of the frame differently.
+	if b[0] != WireVersion {
+		return h, nil, fmt.Errorf("%w: %d", errWireVersion, b[0])
+	}
+	r := &wireReader{b: b}
+	if err := readWire(r, reflect.ValueOf(&h).Elem()); err != nil {
+		return h, nil, err
+	}
+	kind, ok := wireBody(MessageKind(h.Kind))
+	if !ok {
+		return h, nil, fmt.Errorf("%w: %d", errWireKind, h.Kind)
+	}
+	if kind != nil {
+		v := reflect.New(reflect.TypeOf(kind)).Elem()
+		if err := readWire(r, v); err != nil {
+			return h, nil, err
+		}
+		body = v.Interface()
+	}
+	if len(r.b) > 0 {
+		return h, nil, fmt.Errorf("%w: %d trailing bytes", errMalformedFrame, len(r.b))
+	}
+	return h, body, nil
+}
+
+// appendWire appends the fields of the struct v, in order.
+func appendWire(b []byte, v reflect.Value) ([]byte, error) {
+	for i := 0; i < v.NumField(); i++ {
+		switch x := v.Field(i).Interface().(type) {
+		case bool:
+			if x {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		case uint8:
+			b = append(b, x)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, x)
+		case int64:
+			b = binary.BigEndian.AppendUint64(b, uint64(x))
+		case string:
+			if len(x) > math.MaxUint16 {
+				return nil, fmt.Errorf("%s is %d bytes, more than a string holds", v.Type().Field(i).Name, len(x))
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(len(x)))
+			b = append(b, x...)
+		case V:
+			b = append(b, x.RawBytes()...)
+		case Address:
+			if len(x) > math.MaxUint8 {
+				return nil, fmt.Errorf("%s is %d bytes, more than an address holds", v.Type().Field(i).Name, len(x))
+			}
+			b = append(b, uint8(len(x)))
+			b = append(b, x...)
+		case []byte:
+			if len(x) > maxWireData {
+				return nil, fmt.Errorf("%s: %w", v.Type().Field(i).Name, errFrameTooLarge)
+			}
+			b = binary.BigEndian.AppendUint32(b, uint32(len(x)))
+			b = append(b, x...)
+		default:
+			panic(fmt.Sprintf("no wire encoding for %s", v.Field(i).Type()))
+		}
+	}
+	return b, nil
+}
+
+// wireReader consumes the bytes of a frame from b, or if r is set, the left
+// bytes of a frame still to be read from r.
+type wireReader struct {
+	b    []byte
+	r    io.Reader
+	left int
+}
+
+func (r *wireReader) next(n int) ([]byte, error) {
+	if r.r != nil {
+		if n > r.left {
+			return nil, fmt.Errorf("%w: truncated", errMalformedFrame)
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(r.r, p); err != nil {
+			return nil, err
+		}
+		r.left -= n
+		return p, nil
+	}
+	if n > len(r.b) {
+		return nil, fmt.Errorf("%w: truncated", errMalformedFrame)
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p, nil
+}
+
+// length reads a length of size bytes.
+func (r *wireReader) length(size int) (int, error) {
+	p, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(p[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(p)), nil
+	}
+	return int(binary.BigEndian.Uint32(p)), nil
+}
+
+// readWire sets the fields of the struct v, in order.
+func readWire(r *wireReader, v reflect.Value) error {
+	return readWireFields(r, v, v.NumField())
+}
+
+// readWireFields sets the first n fields of the struct v, in order.
+func readWireFields(r *wireReader, v reflect.Value, n int) error {
+	for i := 0; i < n; i++ {
+		f := v.Field(i)
+		var err error
+		var p []byte
+		switch f.Interface().(type) {
+		case bool:
+			if p, err = r.next(1); err == nil {
+				if p[0] > 1 {
+					return fmt.Errorf("%w: bool %d", errMalformedFrame, p[0])
+				}
+				f.SetBool(p[0] == 1)
+			}
+		case uint8:
+			if p, err = r.next(1); err == nil {
+				f.SetUint(uint64(p[0]))
+			}
+		case uint64:
+			if p, err = r.next(8); err == nil {
+				f.SetUint(binary.BigEndian.Uint64(p))
+			}
+		case int64:
+			if p, err = r.next(8); err == nil {
+				f.SetInt(int64(binary.BigEndian.Uint64(p)))
+			}
+		case string:
+			if p, err = r.lengthPrefixed(2); err == nil {
+				f.SetString(string(p))
+			}
+		case V:
+			if p, err = r.next(24); err == nil {
+				x, _ := VFromRawBytes(p)
+				for _, c := range []float64{x.X, x.Y, x.Z} {
+					if math.IsNaN(c) || math.IsInf(c, 0) {
+						return fmt.Errorf("%w: location %s", errMalformedFrame, x)
+					}
+				}
+				f.Set(reflect.ValueOf(x))
+			}
+		case Address:
+			if p, err = r.lengthPrefixed(1); err == nil {
+				f.Set(reflect.ValueOf(Address(bytes.Clone(p))))
+			}
+		case []byte:
+			var n int
+			if n, err = r.length(4); err == nil && n > maxWireData {
+				return fmt.Errorf("%s: %w", v.Type().Field(i).Name, errFrameTooLarge)
+			}
+			if err == nil {
+				if p, err = r.next(n); err == nil {
+					f.SetBytes(bytes.Clone(p))
+				}
+			}
+		default:
+			panic(fmt.Sprintf("no wire encoding for %s", f.Type()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lengthPrefixed reads a length of size bytes, and that many bytes.
+func (r *wireReader) lengthPrefixed(size int) ([]byte, error) {
+	n, err := r.length(size)
+	if err != nil {
+		return nil, err
+	}
+	return r.next(n)
+}
+
+// writeWireSpec writes the specification of the wire format, in Markdown,
+// from the types encoded.
+func writeWireSpec(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# scr wire format, version %d\n\n", WireVersion)
+	b.WriteString("<!-- Generated from wire.go by go generate. Do not edit. -->\n\n")
+	b.WriteString("Daemons send each other messages over TCP as frames. A connection carries\n")
+	b.WriteString("any number of frames, each a u32 big-endian length followed by that many\n")
+	fmt.Fprintf(&b, "bytes, at most %d MiB. The bytes are a header, then the body of the\n", maxFrameBytes>>20)
+	b.WriteString("message's kind, which may be empty. A frame with trailing bytes, an unknown\n")
+	b.WriteString("kind or a version other than this one is rejected.\n\n")
+
+	b.WriteString("## Types\n\n")
+	b.WriteString("| Type | Encoding |\n|---|---|\n")
+	for _, t := range wireTypes {
+		fmt.Fprintf(&b, "| %s | %s |\n", t.name, t.doc)
+	}
+
+	b.WriteString("\n## Header\n\n")
+	writeWireFields(&b, reflect.TypeOf(wireHeader{}))
+
+	b.WriteString("\n## Messages\n")
+	for _, k := range wireKinds {
+		fmt.Fprintf(&b, "\n### %d: %s\n\n%s\n\n", int(k.kind), k.kind, k.doc)
+		if k.body == nil {
+			b.WriteString("The body is empty.\n")
+			continue
+		}
+		writeWireFields(&b, reflect.TypeOf(k.body))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeWireFields(b *strings.Builder, t reflect.Type) {
+	b.WriteString("| Field | Type | Description |\n|---|---|---|\n")
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := ""
+		for _, wt := range wireTypes {
+			if wt.t == f.Type {
+				name = wt.name
+			}
+		}
+		fmt.Fprintf(b, "| %s | %s | %s |\n", f.Name, name, f.Tag.Get("doc"))
+	}
+}
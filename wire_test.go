@@ -0,0 +1,188 @@
+package scr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var updateWireSpec = flag.Bool("update_wire_spec", false, "Write WIRE.md from the wire types")
+
+// wireFrames are a frame of each kind spoken, without their length.
+func wireFrames(t testing.TB) [][]byte {
+	h := wireHeader{
+		Seq:      7,
+		From:     "127.0.0.1:7000",
+		Location: V{0, 0, 1},
+	}
+	var frames [][]byte
+	for _, k := range wireKinds {
+		h.Kind = uint8(k.kind)
+		body := k.body
+		switch k.kind {
+		case MsgPeerResponse:
+			body = wirePeerResponse{Peer: "127.0.0.1:7001", PeerLocation: V{1, 0, 0}}
+		case MsgData:
+			body = wireData{BSize: 10, MaxBSize: -1, Data: []byte("data")}
+		case MsgDataAck:
+			body = wireDataAck{Accepted: true, Address: DataToAddress([]byte("data")), Swap: []byte("swap")}
+		}
+		b, err := encodeFrame(h, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, b[4:])
+	}
+	return frames
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	for i, b := range wireFrames(t) {
+		h, body, err := decodeFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := wireKinds[i]
+		if MessageKind(h.Kind) != k.kind || h.Version != WireVersion || h.Seq != 7 || h.From != "127.0.0.1:7000" || !h.Location.Equals(V{0, 0, 1}) {
+			t.Fatalf("expected %s header, got %+v", k.kind, h)
+		}
+		if (body == nil) != (k.body == nil) {
+			t.Fatalf("expected %s body %T, got %T", k.kind, k.body, body)
+		}
+	}
+	_, body, err := decodeFrame(wireFrames(t)[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := wireDataAck{Accepted: true, Address: DataToAddress([]byte("data")), Swap: []byte("swap")}
+	if !reflect.DeepEqual(body, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, body)
+	}
+}
+
+func TestWireDecodeRejects(t *testing.T) {
+	hello := wireFrames(t)[0]
+	nan, _ := encodeFrame(wireHeader{Kind: uint8(MsgHello)}, nil)
+	copy(nan[len(nan)-24:], V{math.NaN(), 0, 0}.RawBytes())
+	// Data more than the encoder sends, though within a frame.
+	oversize, _ := encodeFrame(wireHeader{Kind: uint8(MsgData)}, wireData{})
+	binary.BigEndian.PutUint32(oversize[len(oversize)-4:], maxWireData+1)
+	oversize = append(oversize, make([]byte, maxWireData+1)...)
+	tests := []struct {
+		name     string
+		b        []byte
+		expected error
+	}{
+		{"empty", nil, errMalformedFrame},
+		{"version", append([]byte{WireVersion + 1}, hello[1:]...), errWireVersion},
+		{"kind", append([]byte{WireVersion, uint8(MsgChallenge)}, hello[2:]...), errWireKind},
+		{"truncated", hello[:len(hello)-1], errMalformedFrame},
+		{"trailing", append(append([]byte(nil), hello...), 0), errMalformedFrame},
+		{"location", nan[4:], errMalformedFrame},
+		{"oversize data", oversize[4:], errFrameTooLarge},
+	}
+	for _, test := range tests {
+		if _, _, err := decodeFrame(test.b); !errors.Is(err, test.expected) {
+			t.Fatalf("expected %v for %s, got %v", test.expected, test.name, err)
+		}
+	}
+}
+
+func TestReadFetchResponse(t *testing.T) {
+	h := wireHeader{Kind: uint8(MsgFetchResponse), From: "127.0.0.1:7000"}
+	b, err := encodeFrame(h, wireFetchResponse{Data: []byte("data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(append(b, "next"...))
+	_, n, err := readFetchResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(r)
+	if n != 4 || string(rest) != "datanext" {
+		t.Fatalf("expected 4 bytes of data left unread, got %d with %q left", n, rest)
+	}
+	// The Data must end the frame.
+	binary.BigEndian.PutUint32(b, binary.BigEndian.Uint32(b)+1)
+	if _, _, err := readFetchResponse(bytes.NewReader(append(b, 0))); !errors.Is(err, errMalformedFrame) {
+		t.Fatalf("expected %v, got %v", errMalformedFrame, err)
+	}
+	hello := wireFrames(t)[0]
+	b = binary.BigEndian.AppendUint32(nil, uint32(len(hello)))
+	if _, _, err := readFetchResponse(bytes.NewReader(append(b, hello...))); !errors.Is(err, errWireKind) {
+		t.Fatalf("expected %v, got %v", errWireKind, err)
+	}
+}
+
+func TestWireSpec(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeWireSpec(&b); err != nil {
+		t.Fatal(err)
+	}
+	if *updateWireSpec {
+		if err := os.WriteFile("WIRE.md", b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	spec, err := os.ReadFile("WIRE.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spec, b.Bytes()) {
+		t.Fatal("WIRE.md is out of date, run go generate")
+	}
+}
+
+// FuzzDecodeFrame checks that decoding never panics, and that whatever
+// decodes encodes back to the same bytes.
+func FuzzDecodeFrame(f *testing.F) {
+	for _, b := range wireFrames(f) {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		h, body, err := decodeFrame(b)
+		if err != nil {
+			return
+		}
+		e, err := encodeFrame(h, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(e[4:], b) {
+			t.Fatalf("expected %x, got %x", b, e[4:])
+		}
+	})
+}
+
+// FuzzReadFrame checks that reading frames from a connection never panics, and
+// never reads past the frames' lengths.
+func FuzzReadFrame(f *testing.F) {
+	for _, b := range wireFrames(f) {
+		e := binary.BigEndian.AppendUint32(nil, uint32(len(b)))
+		e = append(e, b...)
+		f.Add(append(e, e...))
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bytes.NewReader(in)
+		read := 0
+		for {
+			b, err := readFrame(r)
+			if err != nil {
+				break
+			}
+			read += 4 + len(b)
+			if read != len(in)-r.Len() {
+				t.Fatalf("read %d bytes for frames of %d", len(in)-r.Len(), read)
+			}
+			decodeFrame(b)
+		}
+	})
+}